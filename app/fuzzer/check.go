@@ -0,0 +1,257 @@
+package fuzzer
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+)
+
+// stream is the analysis of the bytes sent after the fuzzed packet, split into packets as the broker parses them
+type stream struct {
+	malformed string // Why the first malformed packet is malformed, empty if all packets are valid
+	index     int    // Index of the first malformed or incomplete packet
+	pings     int    // Number of valid PINGREQ packets before it, which the broker answers
+	complete  bool   // Whether the bytes end at a packet boundary, the broker waits for the rest otherwise
+	connected bool   // Whether the session is connected after the valid packets
+}
+
+// analyze splits the bytes into packets and checks them in order, up to the first malformed packet.
+// A broker must close the connection on a malformed packet, so acknowledging it or a later packet is an anomaly.
+// The check is conservative: a packet it considers valid may still be refused by the broker.
+func analyze(b []byte, version byte, connected bool) stream {
+	var s stream
+	for ; len(b) > 0; s.index++ {
+		n, err := packetLength(b)
+		if err != nil {
+			s.malformed = err.Error()
+			return s
+		}
+		if n > len(b) {
+			s.connected = connected
+			return s
+		}
+
+		p, reason := check(b[:n], version, connected)
+		if reason != "" {
+			s.malformed = reason
+			return s
+		}
+		switch p.Type() {
+		case mqtt_packet.CONNECT:
+			connected = true
+		case mqtt_packet.PINGREQ:
+			s.pings++
+		}
+		b = b[n:]
+	}
+	s.complete, s.connected = true, connected
+	return s
+}
+
+// packetLength returns the length of the packet the bytes start with, including the fixed header.
+// It may be longer than the bytes if the packet is incomplete.
+func packetLength(b []byte) (int, error) {
+	length, multiplier := 0, 1
+	for i := 1; i < len(b); i++ {
+		length += int(b[i]&0x7F) * multiplier
+		if b[i]&0x80 == 0 {
+			return i + 1 + length, nil
+		}
+		if i == 4 {
+			return 0, fmt.Errorf("remaining length longer than 4 bytes")
+		}
+		multiplier *= 128
+	}
+	// The remaining length itself is incomplete
+	return len(b) + 1, nil
+}
+
+// check returns why the packet is not valid to send in the session state, empty if it is valid
+func check(b []byte, version byte, connected bool) (mqtt_packet.Packet, string) {
+	p, err := mqtt_packet.Decode(b, version)
+	if err != nil {
+		return p, err.Error()
+	}
+
+	if flags := b[0] & 0x0F; p.Type() != mqtt_packet.PUBLISH && flags != fixedFlags(p.Type()) {
+		return p, fmt.Sprintf("%s with the reserved flags %04b", mqtt_packet.TypeName(p.Type()), flags)
+	}
+	if p.Type() == mqtt_packet.CONNECT && connected {
+		return p, "second CONNECT"
+	}
+	if p.Type() != mqtt_packet.CONNECT && !connected {
+		return p, fmt.Sprintf("%s as the first packet", mqtt_packet.TypeName(p.Type()))
+	}
+	return p, malformed(p, b, version)
+}
+
+// malformed returns why the decoded packet is malformed, empty if it is valid
+func malformed(p mqtt_packet.Packet, b []byte, version byte) string {
+	switch p := p.(type) {
+	case *mqtt_packet.Connect:
+		return malformedConnect(p, version)
+	case *mqtt_packet.Publish:
+		switch {
+		case p.QoS == 0 && p.Dup:
+			return "PUBLISH QoS 0 with the DUP flag"
+		case p.QoS > 0 && p.PacketID == 0:
+			return "PUBLISH with the packet identifier 0"
+		case strings.ContainsAny(p.Topic, "+#"):
+			return "PUBLISH topic name with wildcards"
+		case p.Topic == "" && !hasTopicAlias(p.Properties):
+			return "PUBLISH with an empty topic name and no topic alias"
+		case !validString(p.Topic):
+			return "PUBLISH topic name which is not valid UTF-8"
+		}
+	case *mqtt_packet.Subscribe:
+		switch {
+		case p.PacketID == 0:
+			return "SUBSCRIBE with the packet identifier 0"
+		case len(p.Subscriptions) == 0:
+			return "SUBSCRIBE without topic filters"
+		}
+		for _, s := range p.Subscriptions {
+			if s.Topic == "" || !validString(s.Topic) {
+				return "SUBSCRIBE topic filter which is empty or not valid UTF-8"
+			}
+			if !validOptions(s.Options, version) {
+				return fmt.Sprintf("SUBSCRIBE with the invalid subscription options %08b", s.Options)
+			}
+		}
+	case *mqtt_packet.Unsubscribe:
+		switch {
+		case p.PacketID == 0:
+			return "UNSUBSCRIBE with the packet identifier 0"
+		case len(p.Topics) == 0:
+			return "UNSUBSCRIBE without topic filters"
+		}
+		for _, t := range p.Topics {
+			if t == "" || !validString(t) {
+				return "UNSUBSCRIBE topic filter which is empty or not valid UTF-8"
+			}
+		}
+	case *mqtt_packet.Pingreq:
+		if len(b) != 2 {
+			return "PINGREQ with a payload"
+		}
+	case *mqtt_packet.Ack:
+		if p.PacketID == 0 {
+			return fmt.Sprintf("%s with the packet identifier 0", mqtt_packet.TypeName(p.Type()))
+		}
+	case *mqtt_packet.Connack, *mqtt_packet.Suback, *mqtt_packet.Unsuback, *mqtt_packet.Pingresp:
+		return fmt.Sprintf("%s sent by a client", mqtt_packet.TypeName(p.Type()))
+	case *mqtt_packet.Auth:
+		if version == mqtt_packet.Version311 {
+			return "AUTH in MQTT 3.1.1"
+		}
+	}
+	return ""
+}
+
+// malformedConnect returns why the CONNECT packet is malformed, empty if it is valid
+func malformedConnect(p *mqtt_packet.Connect, version byte) string {
+	flags := p.Flags()
+	switch {
+	case p.ProtocolName != "MQTT" || (p.ProtocolLevel != mqtt_packet.Version311 && p.ProtocolLevel != mqtt_packet.Version5):
+		return fmt.Sprintf("CONNECT with the protocol %q level %d", p.ProtocolName, p.ProtocolLevel)
+	case flags&0x01 != 0:
+		return "CONNECT with the reserved connect flag"
+	case !p.WillFlag && (p.WillQoS != 0 || p.WillRetain):
+		return "CONNECT with will QoS or retain without the will flag"
+	case p.WillQoS == 3:
+		return "CONNECT with will QoS 3"
+	case version == mqtt_packet.Version311 && p.PasswordFlag && !p.UsernameFlag:
+		return "CONNECT with a password without a username"
+	case !validString(p.ClientID) || !validString(p.Username) || (p.WillFlag && !validString(p.WillTopic)):
+		return "CONNECT with a string which is not valid UTF-8"
+	case p.WillFlag && (p.WillTopic == "" || strings.ContainsAny(p.WillTopic, "+#")):
+		return "CONNECT with a will topic which is empty or contains wildcards"
+	}
+	return ""
+}
+
+func hasTopicAlias(props mqtt_packet.Properties) bool {
+	_, ok := props.Get(mqtt_packet.PropTopicAlias)
+	return ok
+}
+
+// validOptions reports whether the subscription options have a valid QoS and no reserved bits set
+func validOptions(options, version byte) bool {
+	if options&0x03 == 3 {
+		return false
+	}
+	if version == mqtt_packet.Version311 {
+		return options&0xFC == 0
+	}
+	return options&0xC0 == 0 && options&0x30 != 0x30
+}
+
+// fixedFlags returns the flags of the fixed header the packet type requires, PUBLISH flags are not fixed
+func fixedFlags(t byte) byte {
+	switch t {
+	case mqtt_packet.PUBREL, mqtt_packet.SUBSCRIBE, mqtt_packet.UNSUBSCRIBE:
+		return 0x02
+	}
+	return 0
+}
+
+// validString reports whether s is a valid MQTT UTF-8 encoded string, which must not contain U+0000
+func validString(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+// observation is the broker behavior after a fuzzed packet
+type observation struct {
+	closed   bool // The broker closed the connection
+	accepted bool // The broker acknowledged a packet with a success reason code
+	pongs    int  // Number of PINGRESP packets received
+	events   []string
+}
+
+// done reports whether the broker answered all packets of a valid stream, so that nothing more is expected
+func (obs *observation) done(s stream) bool {
+	return s.malformed == "" && s.complete && obs.pongs >= s.pings
+}
+
+// classify returns the anomaly the observed behavior is for the stream sent, empty if there is none
+func classify(s stream, obs *observation) Kind {
+	switch {
+	case s.malformed != "" && (obs.pongs > s.pings || (s.index == 0 && obs.accepted)):
+		// The broker answered a packet at or after the malformed one
+		return KindAccepted
+	case obs.closed:
+		return ""
+	case s.malformed != "", s.complete && obs.pongs < s.pings:
+		return KindHang
+	case !s.complete && s.connected:
+		// The broker waits for the rest of the packet, but the keep alive must close the connection within the wait
+		return KindHang
+	}
+	return ""
+}
+
+// succeeded reports whether a packet received from the broker acknowledges the fuzzed packet with success
+func succeeded(p mqtt_packet.Packet) bool {
+	switch p := p.(type) {
+	case *mqtt_packet.Connack:
+		return p.ReasonCode == 0
+	case *mqtt_packet.Ack:
+		return p.ReasonCode < 0x80
+	case *mqtt_packet.Suback:
+		return allSucceeded(p.ReasonCodes)
+	case *mqtt_packet.Unsuback:
+		return allSucceeded(p.ReasonCodes)
+	}
+	return false
+}
+
+func allSucceeded(codes []byte) bool {
+	for _, code := range codes {
+		if code >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
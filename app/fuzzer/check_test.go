@@ -0,0 +1,92 @@
+package fuzzer
+
+import (
+	"strings"
+	"testing"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+)
+
+func TestAnalyzeSeeds(t *testing.T) {
+	for _, version := range []byte{mqtt_packet.Version311, mqtt_packet.Version5} {
+		for _, s := range seeds {
+			o := testOptions
+			c := Case{Connected: s.connected, Data: mqtt_packet.Encode(s.packet(version, &o, 1), version)}
+			got := analyze(c.stream(), version, c.Connected)
+
+			pings := 1
+			if s.name == "PINGREQ" {
+				pings = 2
+			}
+			want := stream{index: 2, pings: pings, complete: true, connected: true}
+			if got != want {
+				t.Errorf("version %d: analyze(%s) = %+v, want %+v", version, s.name, got, want)
+			}
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	publish := mqtt_packet.Encode(&mqtt_packet.Publish{Topic: "a", Payload: []byte("1")}, mqtt_packet.Version311)
+	tests := []struct {
+		name      string
+		b         []byte
+		connected bool
+		malformed string // Substring of the reason, empty if the stream is valid
+		want      stream // Compared without the reason
+	}{
+		{"valid PUBLISH", publish, true, "", stream{index: 1, complete: true, connected: true}},
+		{"PUBLISH as the first packet", publish, false, "as the first packet", stream{}},
+		{"second CONNECT", mqtt_packet.Encode(mqtt_packet.NewConnect(mqtt_packet.Version311, "c"), mqtt_packet.Version311), true, "second CONNECT", stream{}},
+		{"reserved flags", []byte{0xC1, 0x00}, true, "reserved flags", stream{}},
+		{"QoS 0 with DUP", []byte{0x38, 0x04, 0x00, 0x01, 'a', '1'}, true, "DUP flag", stream{}},
+		{"wildcard topic", []byte{0x30, 0x04, 0x00, 0x01, '#', '1'}, true, "wildcards", stream{}},
+		{"remaining length too long", []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, true, "longer than 4 bytes", stream{}},
+		{"PINGRESP from a client", []byte{0xD0, 0x00}, true, "sent by a client", stream{}},
+		{"PINGREQ after a malformed packet", append([]byte{0xC1, 0x00}, pingreq...), true, "reserved flags", stream{}},
+		{"incomplete packet", publish[:len(publish)-1], true, "", stream{connected: true}},
+		{"PINGREQ then incomplete", append(append([]byte{}, pingreq...), publish[:3]...), true, "", stream{index: 1, pings: 1, connected: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := analyze(tt.b, mqtt_packet.Version311, tt.connected)
+			if tt.malformed == "" && got.malformed != "" || !strings.Contains(got.malformed, tt.malformed) {
+				t.Fatalf("analyze() malformed = %q, want %q", got.malformed, tt.malformed)
+			}
+			got.malformed = ""
+			if got != tt.want {
+				t.Errorf("analyze() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	valid := stream{index: 2, pings: 1, complete: true, connected: true}
+	malformed := stream{malformed: "PUBLISH QoS 0 with the DUP flag"}
+	incomplete := stream{connected: true}
+	tests := []struct {
+		name string
+		s    stream
+		obs  observation
+		want Kind
+	}{
+		{"valid answered", valid, observation{pongs: 1}, ""},
+		{"valid closed", valid, observation{closed: true}, ""},
+		{"valid unanswered", valid, observation{}, KindHang},
+		{"malformed closed", malformed, observation{closed: true}, ""},
+		{"malformed acknowledged", malformed, observation{accepted: true, closed: true}, KindAccepted},
+		{"PINGREQ after malformed answered", malformed, observation{pongs: 1, closed: true}, KindAccepted},
+		{"malformed left open", malformed, observation{}, KindHang},
+		{"incomplete left open", incomplete, observation{}, KindHang},
+		{"incomplete closed", incomplete, observation{closed: true}, ""},
+		{"incomplete CONNECT left open", stream{}, observation{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.s, &tt.obs); got != tt.want {
+				t.Errorf("classify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
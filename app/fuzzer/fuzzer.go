@@ -0,0 +1,305 @@
+// Package fuzzer mutates valid MQTT packets and sends them to a broker over raw connections,
+// to find the packets which make the broker unavailable, hang the connection or which the broker
+// accepts although they are malformed. The packet of every anomaly is saved to disk to be replayed.
+package fuzzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/config"
+)
+
+// Kind is the kind of an anomaly
+type Kind string
+
+const (
+	KindUnavailable Kind = "unavailable" // The broker could no longer be reached or did not answer a valid CONNECT after the packet
+	KindRefused     Kind = "refused"     // The broker refused a valid CONNECT after the packet, e.g. it banned the scanner
+	KindAccepted    Kind = "accepted"    // The broker acknowledged a malformed packet or kept processing packets after it
+	KindHang        Kind = "hang"        // The broker neither answered nor closed the connection after the packet
+)
+
+// Default options
+const (
+	DefaultWait = 5 * time.Second
+	DefaultDir  = "fuzz-findings"
+)
+
+// aliveAttempts is how often the liveness check is tried before the broker is considered unavailable
+const aliveAttempts = 3
+
+// aliveInterval is the minimum time between the valid CONNECT packets of the liveness checks, so that they do not trip
+// the flapping detection of the broker. The checks in between only open a connection.
+// The CONNECT packets sent before the fuzzed packets each have the client ID of their iteration for the same reason.
+const aliveInterval = 10 * time.Second
+
+// errRefused is returned when the broker refuses the valid CONNECT sent before a fuzzed packet,
+// which the run reports as a refused anomaly rather than a failed iteration
+var errRefused = errors.New("valid CONNECT before the fuzzed packet refused")
+
+// Options configure a fuzzing run
+type Options struct {
+	Listener   config.Listener
+	Username   string
+	Password   string
+	Iterations int           // Number of fuzzed packets, 0 means no limit
+	Duration   time.Duration // Maximum duration of the run, 0 means no limit
+	Seed       int64         // Seed of the mutations, the same seed fuzzes the same packets
+	Wait       time.Duration // How long the broker is given to answer or close the connection, DefaultWait if 0
+	Dir        string        // Directory the reproducers are saved to, DefaultDir if empty
+
+	// OnAnomaly is called for every anomaly after its reproducer is saved, optional
+	OnAnomaly func(*Anomaly)
+
+	connected time.Time // Time of the last valid CONNECT the broker accepted in a liveness check
+}
+
+// Result is the result of a fuzzing run
+type Result struct {
+	Iterations int        // Number of fuzzed packets sent
+	Errors     int        // Number of iterations which failed before the fuzzed packet was sent
+	Anomalies  []*Anomaly // Anomalies in the order they were found
+	Stopped    bool       // Whether the run stopped early because the broker became unavailable or refused the valid CONNECT
+}
+
+// Run fuzzes the listener until the iterations are done, the duration has passed or the context is done.
+// The run stops at the first packet after which the broker is unavailable or refuses a valid CONNECT.
+func Run(ctx context.Context, o Options) (*Result, error) {
+	o.defaults()
+	if o.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Duration)
+		defer cancel()
+	}
+
+	version := mqtt_packet.ProtocolLevel(o.Listener.Protocol)
+	r := rand.New(rand.NewSource(o.Seed))
+	res := &Result{}
+	for i := 1; o.Iterations == 0 || i <= o.Iterations; i++ {
+		c := generate(r, version, &o, i)
+		c.Transport, c.Protocol, c.Seed, c.Iteration = o.Listener.Transport, o.Listener.Protocol, o.Seed, i
+		c.Malformed = analyze(c.stream(), version, c.Connected).malformed
+
+		a, err := replay(ctx, &o, &c)
+		if ctx.Err() != nil {
+			break
+		}
+		res.Iterations = i
+		if err != nil {
+			res.Errors++
+		}
+		if a.Kind == "" {
+			continue
+		}
+
+		if err := save(o.Dir, a); err != nil {
+			return res, err
+		}
+		res.Anomalies = append(res.Anomalies, a)
+		if o.OnAnomaly != nil {
+			o.OnAnomaly(a)
+		}
+		if a.Kind == KindUnavailable || a.Kind == KindRefused {
+			res.Stopped = true
+			break
+		}
+	}
+	return res, nil
+}
+
+// Replay sends the packet of a saved case once more, the returned anomaly has an empty kind
+// if the broker behaved correctly this time
+func Replay(ctx context.Context, o Options, c *Case) (*Anomaly, error) {
+	o.defaults()
+	return replay(ctx, &o, c)
+}
+
+// replay sends the case and classifies the broker behavior, followed by a check that the broker is still available.
+// The error is returned together with the anomaly when the case could not be sent.
+func replay(ctx context.Context, o *Options, c *Case) (*Anomaly, error) {
+	a := &Anomaly{Case: *c}
+	s := analyze(c.stream(), mqtt_packet.ProtocolLevel(o.Listener.Protocol), c.Connected)
+	obs, err := execute(ctx, o, c, s)
+	if errors.Is(err, errRefused) {
+		// The broker refuses the scanner since an earlier packet, e.g. it banned it, so the fuzzed packet was not sent
+		a.Kind = KindRefused
+		a.Observed = append(a.Observed, err.Error()+", the fuzzed packet was not sent")
+		return a, nil
+	}
+	if err != nil {
+		a.Observed = append(a.Observed, err.Error())
+	} else {
+		a.Observed = append(a.Observed, obs.events...)
+		a.Kind = classify(s, obs)
+	}
+
+	if kind, events := alive(ctx, o); kind != "" && ctx.Err() == nil {
+		a.Kind = kind
+		a.Observed = append(a.Observed, events...)
+	}
+	return a, err
+}
+
+func (o *Options) defaults() {
+	if o.Wait <= 0 {
+		o.Wait = DefaultWait
+	}
+	if o.Dir == "" {
+		o.Dir = DefaultDir
+	}
+}
+
+// keepAlive returns the keep alive of the CONNECT packets, the broker must close a silent connection
+// after 1.5 times the keep alive, which is within the wait
+func (o *Options) keepAlive() uint16 {
+	return uint16(max(1, o.Wait/(2*time.Second)))
+}
+
+// connect returns a valid CONNECT packet with the configured credentials
+func (o *Options) connect(version byte, clientID string) *mqtt_packet.Connect {
+	p := mqtt_packet.NewConnect(version, clientID)
+	p.KeepAlive = o.keepAlive()
+	if o.Username != "" {
+		p.UsernameFlag, p.Username = true, o.Username
+	}
+	if o.Password != "" {
+		p.PasswordFlag, p.Password = true, []byte(o.Password)
+	}
+	return p
+}
+
+// clientID returns the client ID of the CONNECT packets of the iteration, every iteration has its own client ID
+// so that the broker does not count the connections of the run as one flapping client
+func (o *Options) clientID(iteration int) string {
+	return fmt.Sprintf("mqtt-security-scanner-fuzz-%s-%s-%d", o.Listener.Transport, o.Listener.Protocol, iteration)
+}
+
+// execute sends the stream of the case and observes the broker behavior
+func execute(ctx context.Context, o *Options, c *Case, s stream) (*observation, error) {
+	session, err := mqtt_packet.DialSession(ctx, o.Listener)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	if c.Connected {
+		connack, err := session.Connect(o.connect(session.Version, o.clientID(c.Iteration)), o.Wait)
+		if err != nil {
+			return nil, fmt.Errorf("connect before the fuzzed packet: %w", err)
+		}
+		if connack.ReasonCode != 0 {
+			return nil, fmt.Errorf("%w with CONNACK reason code %s", errRefused, mqtt_packet.ConnackReason(session.Version, connack.ReasonCode))
+		}
+	}
+
+	obs := &observation{}
+	if err := session.SendBytes(c.stream()); err != nil {
+		obs.closed = true
+		obs.events = append(obs.events, fmt.Sprintf("Broker closed the connection: %v", err))
+		return obs, nil
+	}
+
+	deadline := time.Now().Add(o.Wait)
+	for !obs.done(s) {
+		p, err := session.Receive(time.Until(deadline))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		switch {
+		case mqtt_packet.IsTimeout(err):
+			obs.events = append(obs.events, fmt.Sprintf("Connection still open after %v", o.Wait))
+			return obs, nil
+		case errors.Is(err, mqtt_packet.ErrMalformed):
+			obs.events = append(obs.events, fmt.Sprintf("Broker sent a packet which cannot be decoded: %v", err))
+		case err != nil:
+			obs.closed = true
+			obs.events = append(obs.events, fmt.Sprintf("Broker closed the connection: %v", err))
+			return obs, nil
+		case p.Type() == mqtt_packet.PINGRESP:
+			obs.pongs++
+			obs.events = append(obs.events, "Broker sent PINGRESP")
+		default:
+			obs.accepted = obs.accepted || succeeded(p)
+			obs.events = append(obs.events, mqtt_packet.Describe(p, session.Version))
+		}
+	}
+	return obs, nil
+}
+
+// alive checks that the broker is still available, it returns the anomaly and describes the failed attempts otherwise.
+// The check sends a valid CONNECT at most every aliveInterval, and only opens a connection in between.
+// The broker is unavailable if it cannot be reached or does not answer the CONNECT in time,
+// and refused if it answers the CONNECT of the last attempt with a reason code or closes the connection.
+func alive(ctx context.Context, o *Options) (Kind, []string) {
+	connect := time.Since(o.connected) >= aliveInterval
+	var events []string
+	for attempt := 1; ; attempt++ {
+		var refusal string
+		var err error
+		if connect {
+			refusal, err = connectOnce(ctx, o)
+		} else {
+			err = dialOnce(ctx, o)
+		}
+		switch {
+		case refusal != "":
+			events = append(events, fmt.Sprintf("Valid CONNECT attempt %d refused: %s", attempt, refusal))
+		case err != nil && connect:
+			events = append(events, fmt.Sprintf("Valid CONNECT attempt %d failed: %v", attempt, err))
+		case err != nil:
+			events = append(events, fmt.Sprintf("Connection attempt %d failed: %v", attempt, err))
+		default:
+			return "", nil
+		}
+
+		if attempt == aliveAttempts || ctx.Err() != nil {
+			if refusal != "" {
+				return KindRefused, events
+			}
+			return KindUnavailable, events
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// dialOnce opens a connection to the listener, including the TLS and WebSocket handshakes, and closes it
+func dialOnce(ctx context.Context, o *Options) error {
+	session, err := mqtt_packet.DialSession(ctx, o.Listener)
+	if err != nil {
+		return err
+	}
+	return session.Close()
+}
+
+// connectOnce connects with a valid CONNECT packet and disconnects. The refusal describes the CONNACK reason code or
+// the connection the broker closed after the CONNECT, the error is returned if the broker cannot be reached or does not
+// answer the CONNECT in time.
+func connectOnce(ctx context.Context, o *Options) (string, error) {
+	session, err := mqtt_packet.DialSession(ctx, o.Listener)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	connack, err := session.Connect(o.connect(session.Version, o.clientID(0)+"-check"), o.Wait)
+	switch {
+	case mqtt_packet.IsTimeout(err):
+		return "", err
+	case err != nil:
+		return fmt.Sprintf("connection closed after the CONNECT: %v", err), nil
+	case connack.ReasonCode != 0:
+		return "CONNACK reason code " + mqtt_packet.ConnackReason(session.Version, connack.ReasonCode).String(), nil
+	}
+	o.connected = time.Now()
+	session.Send(&mqtt_packet.Disconnect{})
+	return "", nil
+}
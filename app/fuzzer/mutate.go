@@ -0,0 +1,221 @@
+package fuzzer
+
+import (
+	"math/rand"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+)
+
+// fuzzTopic is the topic of the fuzzed PUBLISH, SUBSCRIBE and UNSUBSCRIBE packets
+const fuzzTopic = "mqtt-security-scanner/fuzz"
+
+// seed is a valid packet the mutations start from
+type seed struct {
+	name      string
+	connected bool // Whether the packet is sent after an accepted CONNECT
+	packet    func(version byte, o *Options, iteration int) mqtt_packet.Packet
+}
+
+var seeds = []seed{
+	{
+		name: "CONNECT",
+		packet: func(version byte, o *Options, iteration int) mqtt_packet.Packet {
+			p := o.connect(version, o.clientID(iteration))
+			p.WillFlag, p.WillQoS, p.WillTopic, p.WillPayload = true, 1, fuzzTopic, []byte("will")
+			if version == mqtt_packet.Version5 {
+				p.Properties = mqtt_packet.Properties{
+					{ID: mqtt_packet.PropSessionExpiry, Value: uint32(0)},
+					{ID: mqtt_packet.PropReceiveMaximum, Value: uint16(16)},
+					{ID: mqtt_packet.PropUserProperty, Value: mqtt_packet.StringPair{Key: "fuzz", Value: "connect"}},
+				}
+				p.WillProperties = mqtt_packet.Properties{{ID: mqtt_packet.PropWillDelayInterval, Value: uint32(0)}}
+			}
+			return p
+		},
+	},
+	{name: "PUBLISH QoS 0", connected: true, packet: publish(0)},
+	{name: "PUBLISH QoS 1", connected: true, packet: publish(1)},
+	{name: "PUBLISH QoS 2", connected: true, packet: publish(2)},
+	{
+		name:      "SUBSCRIBE",
+		connected: true,
+		packet: func(version byte, _ *Options, _ int) mqtt_packet.Packet {
+			p := &mqtt_packet.Subscribe{
+				PacketID:      1,
+				Subscriptions: []mqtt_packet.Subscription{{Topic: fuzzTopic, Options: 1}, {Topic: fuzzTopic + "/+/#", Options: 0}},
+			}
+			if version == mqtt_packet.Version5 {
+				p.Properties = mqtt_packet.Properties{{ID: mqtt_packet.PropSubscriptionIdentifier, Value: mqtt_packet.VarInt(1)}}
+			}
+			return p
+		},
+	},
+	{
+		name:      "UNSUBSCRIBE",
+		connected: true,
+		packet: func(byte, *Options, int) mqtt_packet.Packet {
+			return &mqtt_packet.Unsubscribe{PacketID: 1, Topics: []string{fuzzTopic}}
+		},
+	},
+	{
+		name:      "PUBREL",
+		connected: true,
+		packet: func(byte, *Options, int) mqtt_packet.Packet {
+			return &mqtt_packet.Ack{PacketType: mqtt_packet.PUBREL, PacketID: 1}
+		},
+	},
+	{
+		name:      "PINGREQ",
+		connected: true,
+		packet: func(byte, *Options, int) mqtt_packet.Packet {
+			return &mqtt_packet.Pingreq{}
+		},
+	},
+}
+
+// publish returns the seed function of a PUBLISH packet with the QoS
+func publish(qos byte) func(byte, *Options, int) mqtt_packet.Packet {
+	return func(version byte, _ *Options, _ int) mqtt_packet.Packet {
+		p := &mqtt_packet.Publish{QoS: qos, Topic: fuzzTopic, Payload: []byte("mqtt-security-scanner")}
+		if qos > 0 {
+			p.PacketID = 1
+		}
+		if version == mqtt_packet.Version5 {
+			p.Properties = mqtt_packet.Properties{
+				{ID: mqtt_packet.PropMessageExpiry, Value: uint32(60)},
+				{ID: mqtt_packet.PropContentType, Value: "text/plain"},
+			}
+		}
+		return p
+	}
+}
+
+// mutator mutates a valid packet into the bytes sent to the broker, it returns nil if the packet cannot be mutated
+type mutator struct {
+	name   string
+	mutate func(r *rand.Rand, p mqtt_packet.Packet, version byte) []byte
+}
+
+var mutators = []mutator{
+	{name: "bit-flip", mutate: flipBits},
+	{name: "length", mutate: tamperLength},
+	{name: "property", mutate: injectProperties},
+	{name: "truncate", mutate: truncate},
+}
+
+// flipBits flips 1 to 8 random bits of the encoded packet, including the fixed header
+func flipBits(r *rand.Rand, p mqtt_packet.Packet, version byte) []byte {
+	b := mqtt_packet.Encode(p, version)
+	for n := 1 + r.Intn(8); n > 0; n-- {
+		bit := r.Intn(len(b) * 8)
+		b[bit/8] ^= 1 << (bit % 8)
+	}
+	return b
+}
+
+// tamperLength rewrites the remaining length of the fixed header, shorter or longer than the body,
+// or beyond the largest length a variable byte integer of 4 bytes can encode
+func tamperLength(r *rand.Rand, p mqtt_packet.Packet, version byte) []byte {
+	rp := raw(p, version)
+	n := len(rp.Body)
+	lengths := []int{0, n + 1, n + 1 + r.Intn(256), r.Intn(n + 1), mqtt_packet.MaxRemainingLength, mqtt_packet.MaxRemainingLength + 1}
+	if n > 0 {
+		lengths = append(lengths, n-1)
+	}
+	length := lengths[r.Intn(len(lengths))]
+	rp.RemainingLength = &length
+	return mqtt_packet.Encode(rp, version)
+}
+
+// injectProperties adds random properties to the packet: unknown identifiers, values of the wrong type
+// and duplicates. The packet is encoded with MQTT 5.0 properties, also in a MQTT 3.1.1 session
+// where the properties are bytes the broker does not expect.
+func injectProperties(r *rand.Rand, p mqtt_packet.Packet, _ byte) []byte {
+	props := randomProperties(r)
+	switch p := p.(type) {
+	case *mqtt_packet.Connect:
+		if p.WillFlag && r.Intn(2) == 0 {
+			p.WillProperties = append(p.WillProperties, props...)
+		} else {
+			p.Properties = append(p.Properties, props...)
+		}
+	case *mqtt_packet.Publish:
+		p.Properties = append(p.Properties, props...)
+	case *mqtt_packet.Subscribe:
+		p.Properties = append(p.Properties, props...)
+	case *mqtt_packet.Unsubscribe:
+		p.Properties = append(p.Properties, props...)
+	case *mqtt_packet.Ack:
+		p.Properties = append(p.Properties, props...)
+	default:
+		return nil
+	}
+	return mqtt_packet.Encode(p, mqtt_packet.Version5)
+}
+
+// randomProperties returns 1 to 3 properties with identifiers up to 0x2F and values of a random type
+func randomProperties(r *rand.Rand) mqtt_packet.Properties {
+	var props mqtt_packet.Properties
+	for n := 1 + r.Intn(3); n > 0; n-- {
+		var v any
+		switch r.Intn(8) {
+		case 0:
+			v = byte(r.Intn(256))
+		case 1:
+			v = uint16(r.Intn(1 << 16))
+		case 2:
+			v = r.Uint32()
+		case 3:
+			v = mqtt_packet.VarInt(r.Intn(mqtt_packet.MaxRemainingLength + 2))
+		case 4:
+			v = string(randomBytes(r, 16))
+		case 5:
+			v = randomBytes(r, 16)
+		case 6:
+			v = mqtt_packet.StringPair{Key: string(randomBytes(r, 8)), Value: string(randomBytes(r, 8))}
+		default:
+			v = mqtt_packet.RawValue(randomBytes(r, 8))
+		}
+		props = append(props, mqtt_packet.Property{ID: byte(r.Intn(0x30)), Value: v})
+	}
+	return props
+}
+
+// truncate cuts the packet short, either keeping the remaining length so that the broker waits for the rest,
+// or with a remaining length matching the truncated body so that the packet ends in the middle of a field
+func truncate(r *rand.Rand, p mqtt_packet.Packet, version byte) []byte {
+	if r.Intn(2) == 0 {
+		b := mqtt_packet.Encode(p, version)
+		return b[:1+r.Intn(len(b)-1)]
+	}
+	rp := raw(p, version)
+	rp.Body = rp.Body[:r.Intn(len(rp.Body)+1)]
+	return mqtt_packet.Encode(rp, version)
+}
+
+// raw returns the encoded packet as a Raw packet, so that its fixed header and body can be rewritten
+func raw(p mqtt_packet.Packet, version byte) *mqtt_packet.Raw {
+	b := mqtt_packet.Encode(p, version)
+	i := 1
+	for b[i]&0x80 != 0 {
+		i++
+	}
+	return &mqtt_packet.Raw{PacketType: b[0] >> 4, Flags: b[0] & 0x0F, Body: b[i+1:]}
+}
+
+func randomBytes(r *rand.Rand, max int) []byte {
+	b := make([]byte, r.Intn(max+1))
+	r.Read(b)
+	return b
+}
+
+// generate returns the fuzzed case of the iteration, a random mutation of a random seed packet
+func generate(r *rand.Rand, version byte, o *Options, iteration int) Case {
+	s := seeds[r.Intn(len(seeds))]
+	for {
+		m := mutators[r.Intn(len(mutators))]
+		if b := m.mutate(r, s.packet(version, o, iteration), version); b != nil {
+			return Case{Packet: s.name, Mutator: m.name, Connected: s.connected, Data: b}
+		}
+	}
+}
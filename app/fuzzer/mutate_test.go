@@ -0,0 +1,111 @@
+package fuzzer
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/config"
+)
+
+var testOptions = Options{
+	Listener: config.Listener{Transport: config.TransportTCP, Protocol: config.ProtocolMQTT5},
+	Username: "user",
+	Password: "secret",
+	Wait:     DefaultWait,
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	for _, version := range []byte{mqtt_packet.Version311, mqtt_packet.Version5} {
+		generateAll := func(seed int64) []Case {
+			o := testOptions
+			r := rand.New(rand.NewSource(seed))
+			var cases []Case
+			for i := 1; i <= 200; i++ {
+				cases = append(cases, generate(r, version, &o, i))
+			}
+			return cases
+		}
+
+		first, second := generateAll(42), generateAll(42)
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("version %d: the same seed generated different cases", version)
+		}
+		if reflect.DeepEqual(first, generateAll(43)) {
+			t.Errorf("version %d: different seeds generated the same cases", version)
+		}
+	}
+}
+
+func TestGenerateCoverage(t *testing.T) {
+	o := testOptions
+	r := rand.New(rand.NewSource(1))
+	packets, mutations := map[string]bool{}, map[string]bool{}
+	for i := 1; i <= 1000; i++ {
+		c := generate(r, mqtt_packet.Version5, &o, i)
+		if len(c.Data) == 0 {
+			t.Fatalf("iteration %d: %s mutated by %s is empty", i, c.Packet, c.Mutator)
+		}
+		packets[c.Packet], mutations[c.Mutator] = true, true
+	}
+	for _, s := range seeds {
+		if !packets[s.name] {
+			t.Errorf("seed %s was never mutated", s.name)
+		}
+	}
+	for _, m := range mutators {
+		if !mutations[m.name] {
+			t.Errorf("mutator %s was never used", m.name)
+		}
+	}
+}
+
+func TestMutators(t *testing.T) {
+	o := testOptions
+	connect := func() mqtt_packet.Packet { return seeds[0].packet(mqtt_packet.Version5, &o, 1) }
+
+	for _, m := range mutators {
+		t.Run(m.name, func(t *testing.T) {
+			for seed := int64(0); seed < 50; seed++ {
+				a := m.mutate(rand.New(rand.NewSource(seed)), connect(), mqtt_packet.Version5)
+				b := m.mutate(rand.New(rand.NewSource(seed)), connect(), mqtt_packet.Version5)
+				if !reflect.DeepEqual(a, b) {
+					t.Fatalf("seed %d: mutations differ\n% x\n% x", seed, a, b)
+				}
+				if len(a) == 0 {
+					t.Errorf("seed %d: the mutated packet is empty", seed)
+				}
+			}
+		})
+	}
+
+	// Packets without properties cannot get injected properties
+	if b := injectProperties(rand.New(rand.NewSource(0)), &mqtt_packet.Pingreq{}, mqtt_packet.Version5); b != nil {
+		t.Errorf("injectProperties(PINGREQ) = % x, want nil", b)
+	}
+}
+
+func TestTamperLength(t *testing.T) {
+	// Only the remaining length is rewritten, the first byte and the body are kept
+	p := &mqtt_packet.Publish{Topic: fuzzTopic, Payload: []byte("payload")}
+	valid := mqtt_packet.Encode(p, mqtt_packet.Version311)
+	body := valid[2:]
+	for seed := int64(0); seed < 50; seed++ {
+		b := tamperLength(rand.New(rand.NewSource(seed)), p, mqtt_packet.Version311)
+		if b[0] != valid[0] || !bytes.HasSuffix(b, body) || len(b) < len(valid) {
+			t.Errorf("seed %d: tamperLength() = % x, want the header and body of % x", seed, b, valid)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	o := testOptions
+	if a, b := o.clientID(1), o.clientID(2); a == b {
+		t.Errorf("clientID(1) = clientID(2) = %s, want a client ID per iteration", a)
+	}
+	if got := o.clientID(7); got != "mqtt-security-scanner-fuzz-tcp-5.0-7" {
+		t.Errorf("clientID(7) = %s", got)
+	}
+}
@@ -0,0 +1,76 @@
+package fuzzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+)
+
+// Case is a fuzzed packet and how it was sent
+type Case struct {
+	Transport string `json:"transport"`
+	Protocol  string `json:"protocol"`
+	Seed      int64  `json:"seed"`
+	Iteration int    `json:"iteration"`
+	Packet    string `json:"packet"`              // Name of the valid packet which was mutated, e.g. "PUBLISH QoS 1"
+	Mutator   string `json:"mutator"`             // Name of the mutation, e.g. "bit-flip"
+	Connected bool   `json:"connected"`           // Whether the packet is sent after an accepted CONNECT
+	Malformed string `json:"malformed,omitempty"` // Why the first malformed packet of the stream is malformed, empty if all are valid
+	Data      []byte `json:"data"`                // The fuzzed packet, the stream sent is followed by a PINGREQ
+}
+
+// pingreq is sent right after the fuzzed packet, the broker answers it only if it kept processing packets
+var pingreq = mqtt_packet.Encode(&mqtt_packet.Pingreq{}, mqtt_packet.Version311)
+
+// stream returns the bytes sent to the broker, the fuzzed packet followed by a PINGREQ
+func (c *Case) stream() []byte {
+	return append(c.Data[:len(c.Data):len(c.Data)], pingreq...)
+}
+
+// Anomaly is a case after which the broker misbehaved, it is saved to disk as a reproducer
+type Anomaly struct {
+	Case
+	Kind     Kind     `json:"kind"`
+	Observed []string `json:"observed"` // The broker behavior after the packet
+	Path     string   `json:"-"`        // The reproducer file, set once it is saved
+}
+
+// save writes the anomaly as a JSON reproducer and the bytes of its packet to a .bin file of the same name
+func save(dir string, a *Anomaly) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%d-%06d-%s", a.Seed, a.Iteration, a.Kind))
+
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".json", b, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".bin", a.Data, 0o644); err != nil {
+		return err
+	}
+	a.Path = name + ".json"
+	return nil
+}
+
+// LoadCase reads the case of a JSON reproducer saved by a fuzzing run
+func LoadCase(path string) (*Case, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Anomaly
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("invalid reproducer %s: %w", path, err)
+	}
+	if len(a.Data) == 0 {
+		return nil, fmt.Errorf("invalid reproducer %s: no packet data", path)
+	}
+	return &a.Case, nil
+}
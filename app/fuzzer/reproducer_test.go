@@ -0,0 +1,54 @@
+package fuzzer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoadCase(t *testing.T) {
+	dir := t.TempDir()
+	a := &Anomaly{
+		Case: Case{
+			Transport: "tcp", Protocol: "5.0", Seed: 42, Iteration: 7,
+			Packet: "PUBLISH QoS 1", Mutator: "bit-flip", Connected: true,
+			Malformed: "PUBLISH with the packet identifier 0", Data: []byte{0x32, 0x05, 0x00, 0x01, 'a', 0x00, 0x00},
+		},
+		Kind:     KindAccepted,
+		Observed: []string{"Broker sent PUBACK reason code 0x00 Success"},
+	}
+	if err := save(dir, a); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "42-000007-accepted.json"); a.Path != want {
+		t.Errorf("Path = %s, want %s", a.Path, want)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "42-000007-accepted.bin")); err != nil || !reflect.DeepEqual(b, a.Data) {
+		t.Errorf("packet file = % x, %v, want % x", b, err, a.Data)
+	}
+
+	c, err := LoadCase(a.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*c, a.Case) {
+		t.Errorf("LoadCase() = %+v, want %+v", *c, a.Case)
+	}
+}
+
+func TestLoadCaseInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"invalid.json": "{",
+		"empty.json":   `{"transport": "tcp", "data": null}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCase(path); err == nil {
+			t.Errorf("LoadCase(%s) succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package mqtt_packet
+
+import "fmt"
+
+// Connect is the CONNECT packet
+type Connect struct {
+	Header
+	ProtocolName  string // "MQTT" for MQTT 3.1.1 and 5.0
+	ProtocolLevel byte   // Version311 or Version5
+	ConnectFlags  *byte  // Overrides the connect flags computed from the fields below, e.g. to set the reserved bit
+	CleanStart    bool   // Clean Session in MQTT 3.1.1
+	WillFlag      bool
+	WillQoS       byte
+	WillRetain    bool
+	UsernameFlag  bool
+	PasswordFlag  bool
+	KeepAlive     uint16
+	Properties    Properties // MQTT 5.0 only
+	ClientID      string
+	// The will fields are encoded if WillFlag is set
+	WillProperties Properties // MQTT 5.0 only
+	WillTopic      string
+	WillPayload    []byte
+	// The credentials are encoded if their flags are set
+	Username string
+	Password []byte
+}
+
+// NewConnect returns a CONNECT packet of the protocol version with a clean session and a keep alive of 30 seconds
+func NewConnect(version byte, clientID string) *Connect {
+	return &Connect{
+		ProtocolName:  "MQTT",
+		ProtocolLevel: version,
+		CleanStart:    true,
+		KeepAlive:     30,
+		ClientID:      clientID,
+	}
+}
+
+func (p *Connect) Type() byte     { return CONNECT }
+func (p *Connect) header() Header { return p.Header }
+func (p *Connect) flags() byte    { return 0 }
+
+// Flags returns the connect flags the packet is encoded with
+func (p *Connect) Flags() byte {
+	if p.ConnectFlags != nil {
+		return *p.ConnectFlags
+	}
+	var flags byte
+	if p.CleanStart {
+		flags |= 0x02
+	}
+	if p.WillFlag {
+		flags |= 0x04 | (p.WillQoS&0x03)<<3
+		if p.WillRetain {
+			flags |= 0x20
+		}
+	}
+	if p.PasswordFlag {
+		flags |= 0x40
+	}
+	if p.UsernameFlag {
+		flags |= 0x80
+	}
+	return flags
+}
+
+func (p *Connect) encode(version byte) []byte {
+	b := appendString(nil, p.ProtocolName)
+	b = append(b, p.ProtocolLevel, p.Flags())
+	b = appendUint16(b, p.KeepAlive)
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	b = appendString(b, p.ClientID)
+	if p.WillFlag {
+		if version == Version5 {
+			b = appendProperties(b, p.WillProperties)
+		}
+		b = appendString(b, p.WillTopic)
+		b = appendBinary(b, p.WillPayload)
+	}
+	if p.UsernameFlag {
+		b = appendString(b, p.Username)
+	}
+	if p.PasswordFlag {
+		b = appendBinary(b, p.Password)
+	}
+	return b
+}
+
+// decode parses the CONNECT packet with the protocol version it declares, whatever the session version is
+func (p *Connect) decode(_ byte, body []byte, _ byte) error {
+	r := reader{b: body}
+	p.ProtocolName = r.string()
+	p.ProtocolLevel = r.byte()
+	flags := r.byte()
+	p.ConnectFlags = &flags
+	p.CleanStart = flags&0x02 != 0
+	p.WillFlag = flags&0x04 != 0
+	p.WillQoS = flags >> 3 & 0x03
+	p.WillRetain = flags&0x20 != 0
+	p.PasswordFlag = flags&0x40 != 0
+	p.UsernameFlag = flags&0x80 != 0
+	p.KeepAlive = r.uint16()
+	if p.ProtocolLevel == Version5 {
+		p.Properties = r.properties()
+	}
+	p.ClientID = r.string()
+	if p.WillFlag {
+		if p.ProtocolLevel == Version5 {
+			p.WillProperties = r.properties()
+		}
+		p.WillTopic = r.string()
+		p.WillPayload = r.binary()
+	}
+	if p.UsernameFlag {
+		p.Username = r.string()
+	}
+	if p.PasswordFlag {
+		p.Password = r.binary()
+	}
+	if r.err == nil && len(r.b) > 0 {
+		return fmt.Errorf("%d bytes after the payload", len(r.b))
+	}
+	return r.err
+}
+
+// Connack is the CONNACK packet, the reason code is the return code in MQTT 3.1.1
+type Connack struct {
+	Header
+	SessionPresent bool
+	ReasonCode     byte
+	Properties     Properties // MQTT 5.0 only
+}
+
+func (p *Connack) Type() byte     { return CONNACK }
+func (p *Connack) header() Header { return p.Header }
+func (p *Connack) flags() byte    { return 0 }
+
+func (p *Connack) encode(version byte) []byte {
+	var ack byte
+	if p.SessionPresent {
+		ack = 0x01
+	}
+	b := []byte{ack, p.ReasonCode}
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	return b
+}
+
+func (p *Connack) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.SessionPresent = r.byte()&0x01 != 0
+	p.ReasonCode = r.byte()
+	if version == Version5 && !r.empty() {
+		p.Properties = r.properties()
+	}
+	return r.err
+}
@@ -0,0 +1,105 @@
+package mqtt_packet
+
+// Pingreq is the PINGREQ packet
+type Pingreq struct {
+	Header
+}
+
+func (p *Pingreq) Type() byte                      { return PINGREQ }
+func (p *Pingreq) header() Header                  { return p.Header }
+func (p *Pingreq) flags() byte                     { return 0 }
+func (p *Pingreq) encode(byte) []byte              { return nil }
+func (p *Pingreq) decode(byte, []byte, byte) error { return nil }
+
+// Pingresp is the PINGRESP packet
+type Pingresp struct {
+	Header
+}
+
+func (p *Pingresp) Type() byte                      { return PINGRESP }
+func (p *Pingresp) header() Header                  { return p.Header }
+func (p *Pingresp) flags() byte                     { return 0 }
+func (p *Pingresp) encode(byte) []byte              { return nil }
+func (p *Pingresp) decode(byte, []byte, byte) error { return nil }
+
+// Disconnect is the DISCONNECT packet, it has no variable header in MQTT 3.1.1
+type Disconnect struct {
+	Header
+	ReasonCode byte       // MQTT 5.0 only, omitted when it is 0 and there are no properties
+	Properties Properties // MQTT 5.0 only
+}
+
+func (p *Disconnect) Type() byte     { return DISCONNECT }
+func (p *Disconnect) header() Header { return p.Header }
+func (p *Disconnect) flags() byte    { return 0 }
+
+func (p *Disconnect) encode(version byte) []byte {
+	if version != Version5 || (p.ReasonCode == 0 && len(p.Properties) == 0) {
+		return nil
+	}
+	b := []byte{p.ReasonCode}
+	if len(p.Properties) > 0 {
+		b = appendProperties(b, p.Properties)
+	}
+	return b
+}
+
+func (p *Disconnect) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	if version == Version5 && !r.empty() {
+		p.ReasonCode = r.byte()
+		if !r.empty() {
+			p.Properties = r.properties()
+		}
+	}
+	return r.err
+}
+
+// Auth is the MQTT 5.0 AUTH packet
+type Auth struct {
+	Header
+	ReasonCode byte // Omitted when it is 0 and there are no properties
+	Properties Properties
+}
+
+func (p *Auth) Type() byte     { return AUTH }
+func (p *Auth) header() Header { return p.Header }
+func (p *Auth) flags() byte    { return 0 }
+
+func (p *Auth) encode(byte) []byte {
+	if p.ReasonCode == 0 && len(p.Properties) == 0 {
+		return nil
+	}
+	b := []byte{p.ReasonCode}
+	return appendProperties(b, p.Properties)
+}
+
+func (p *Auth) decode(_ byte, body []byte, _ byte) error {
+	r := reader{b: body}
+	if !r.empty() {
+		p.ReasonCode = r.byte()
+		if !r.empty() {
+			p.Properties = r.properties()
+		}
+	}
+	return r.err
+}
+
+// Raw is a packet of any type with a body which is encoded as is.
+// Received packets which cannot be decoded are returned as Raw.
+type Raw struct {
+	Header
+	PacketType byte // Any value of the 4 type bits, including the reserved type 0
+	Flags      byte
+	Body       []byte
+}
+
+func (p *Raw) Type() byte         { return p.PacketType }
+func (p *Raw) header() Header     { return p.Header }
+func (p *Raw) flags() byte        { return p.Flags }
+func (p *Raw) encode(byte) []byte { return p.Body }
+
+func (p *Raw) decode(flags byte, body []byte, _ byte) error {
+	p.Flags, p.Body = flags, body
+	return nil
+}
@@ -0,0 +1,315 @@
+// Package mqtt_packet encodes and decodes MQTT 3.1.1 and 5.0 control packets for the low level checks.
+// Packets are encoded exactly as their fields are set, flags, lengths and properties are not validated,
+// so that the checks can send the invalid packets a MQTT client library refuses to build.
+package mqtt_packet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Protocol levels of the CONNECT packet
+const (
+	Version311 byte = 4 // MQTT 3.1.1
+	Version5   byte = 5 // MQTT 5.0
+)
+
+// Control packet types
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+	AUTH        byte = 15
+)
+
+var typeNames = map[byte]string{
+	CONNECT:     "CONNECT",
+	CONNACK:     "CONNACK",
+	PUBLISH:     "PUBLISH",
+	PUBACK:      "PUBACK",
+	PUBREC:      "PUBREC",
+	PUBREL:      "PUBREL",
+	PUBCOMP:     "PUBCOMP",
+	SUBSCRIBE:   "SUBSCRIBE",
+	SUBACK:      "SUBACK",
+	UNSUBSCRIBE: "UNSUBSCRIBE",
+	UNSUBACK:    "UNSUBACK",
+	PINGREQ:     "PINGREQ",
+	PINGRESP:    "PINGRESP",
+	DISCONNECT:  "DISCONNECT",
+	AUTH:        "AUTH",
+}
+
+// TypeName returns the name of the packet type, e.g. "CONNECT"
+func TypeName(t byte) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("reserved(%d)", t)
+}
+
+// MaxRemainingLength is the largest remaining length a variable byte integer can encode
+const MaxRemainingLength = 268435455
+
+// ErrMalformed is returned when a received packet cannot be decoded
+var ErrMalformed = errors.New("malformed packet")
+
+// Header overrides the fixed header of an encoded packet, the zero value encodes the header the specification requires
+type Header struct {
+	HeaderFlags     *byte // Overrides the 4 flag bits, e.g. to set reserved bits
+	RemainingLength *int  // Overrides the remaining length computed from the encoded packet
+}
+
+// Packet is a MQTT control packet
+type Packet interface {
+	// Type returns the control packet type, one of the packet type constants
+	Type() byte
+	// header returns the overrides of the fixed header
+	header() Header
+	// flags returns the flag bits the packet is encoded with when not overridden
+	flags() byte
+	// encode returns the variable header and payload of the packet for the protocol version
+	encode(version byte) []byte
+	// decode parses the flag bits, variable header and payload of the packet for the protocol version
+	decode(flags byte, body []byte, version byte) error
+}
+
+// Encode returns the wire format of the packet for the protocol version
+func Encode(p Packet, version byte) []byte {
+	body := p.encode(version)
+	h := p.header()
+
+	flags := p.flags()
+	if h.HeaderFlags != nil {
+		flags = *h.HeaderFlags
+	}
+	length := len(body)
+	if h.RemainingLength != nil {
+		length = *h.RemainingLength
+	}
+
+	b := make([]byte, 0, len(body)+5)
+	b = append(b, p.Type()<<4|flags&0x0F)
+	b = appendVarInt(b, length)
+	return append(b, body...)
+}
+
+// Read reads and decodes the next packet of the protocol version.
+// Packets which cannot be decoded are returned as *Raw together with an error wrapping ErrMalformed. This includes
+// a remaining length longer than 4 bytes, and a packet cut short by the reader, whose *Raw holds the bytes read so far
+// and whose error also wraps the read error. The read error is returned as is if no byte of the packet was read.
+func Read(r io.Reader, version byte) (Packet, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return nil, err
+	}
+	t, flags := first[0]>>4, first[0]&0x0F
+	length, err := readVarInt(r)
+	if errors.Is(err, ErrMalformed) {
+		return &Raw{PacketType: t, Flags: flags}, err
+	}
+	if err != nil {
+		return &Raw{PacketType: t, Flags: flags}, fmt.Errorf("%w: %s truncated in the remaining length: %w", ErrMalformed, TypeName(t), unexpectedEOF(err))
+	}
+	body := make([]byte, length)
+	if n, err := io.ReadFull(r, body); err != nil {
+		raw := &Raw{PacketType: t, Flags: flags, Body: body[:n]}
+		return raw, fmt.Errorf("%w: %s truncated after %d of %d bytes: %w", ErrMalformed, TypeName(t), n, length, unexpectedEOF(err))
+	}
+	return decode(first[0], body, version)
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF for io.EOF, the end of the stream within a packet
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Decode decodes a single packet of the protocol version from its wire format
+func Decode(b []byte, version byte) (Packet, error) {
+	r := bytes.NewReader(b)
+	p, err := Read(r, version)
+	if err == nil && r.Len() > 0 {
+		err = fmt.Errorf("%w: %d bytes after the packet", ErrMalformed, r.Len())
+	}
+	if errors.Is(err, io.EOF) && !errors.Is(err, ErrMalformed) {
+		err = fmt.Errorf("%w: empty packet", ErrMalformed)
+	}
+	return p, err
+}
+
+func decode(first byte, body []byte, version byte) (Packet, error) {
+	var p Packet
+	switch t := first >> 4; t {
+	case CONNECT:
+		p = &Connect{}
+	case CONNACK:
+		p = &Connack{}
+	case PUBLISH:
+		p = &Publish{}
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
+		p = &Ack{PacketType: t}
+	case SUBSCRIBE:
+		p = &Subscribe{}
+	case SUBACK:
+		p = &Suback{}
+	case UNSUBSCRIBE:
+		p = &Unsubscribe{}
+	case UNSUBACK:
+		p = &Unsuback{}
+	case PINGREQ:
+		p = &Pingreq{}
+	case PINGRESP:
+		p = &Pingresp{}
+	case DISCONNECT:
+		p = &Disconnect{}
+	case AUTH:
+		p = &Auth{}
+	default:
+		raw := &Raw{PacketType: t, Flags: first & 0x0F, Body: body}
+		return raw, fmt.Errorf("%w: reserved packet type %d", ErrMalformed, t)
+	}
+
+	if err := p.decode(first&0x0F, body, version); err != nil {
+		raw := &Raw{PacketType: p.Type(), Flags: first & 0x0F, Body: body}
+		return raw, fmt.Errorf("%w: %s: %v", ErrMalformed, TypeName(p.Type()), err)
+	}
+	return p, nil
+}
+
+// appendVarInt appends the variable byte integer encoding of v, values above MaxRemainingLength take more than 4 bytes
+func appendVarInt(b []byte, v int) []byte {
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func readVarInt(r io.Reader) (int, error) {
+	var v, multiplier int = 0, 1
+	var digit [1]byte
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, digit[:]); err != nil {
+			return 0, err
+		}
+		v += int(digit[0]&0x7F) * multiplier
+		if digit[0]&0x80 == 0 {
+			return v, nil
+		}
+		multiplier *= 128
+	}
+	return 0, fmt.Errorf("%w: variable byte integer longer than 4 bytes", ErrMalformed)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendString appends a UTF-8 encoded string, which is not validated
+func appendString(b []byte, s string) []byte {
+	return appendBinary(b, []byte(s))
+}
+
+// appendBinary appends binary data prefixed with its length, data longer than 65535 bytes gets a truncated length
+func appendBinary(b []byte, v []byte) []byte {
+	b = appendUint16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+// reader decodes the fields of a packet body, the first error is kept and later reads return zero values
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = fmt.Errorf("%d bytes expected, %d left", n, len(r.b))
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if v := r.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if v := r.take(2); v != nil {
+		return uint16(v[0])<<8 | uint16(v[1])
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if v := r.take(4); v != nil {
+		return uint32(v[0])<<24 | uint32(v[1])<<16 | uint32(v[2])<<8 | uint32(v[3])
+	}
+	return 0
+}
+
+func (r *reader) varInt() int {
+	if r.err != nil {
+		return 0
+	}
+	br := bytes.NewReader(r.b)
+	v, err := readVarInt(br)
+	if err != nil {
+		r.err = errors.New("invalid variable byte integer")
+		return 0
+	}
+	r.b = r.b[len(r.b)-br.Len():]
+	return v
+}
+
+func (r *reader) binary() []byte {
+	n := r.uint16()
+	return bytes.Clone(r.take(int(n)))
+}
+
+func (r *reader) string() string {
+	return string(r.binary())
+}
+
+// rest returns the remaining bytes
+func (r *reader) rest() []byte {
+	return bytes.Clone(r.take(len(r.b)))
+}
+
+func (r *reader) empty() bool {
+	return r.err != nil || len(r.b) == 0
+}
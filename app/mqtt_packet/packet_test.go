@@ -0,0 +1,227 @@
+package mqtt_packet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		packet  Packet
+	}{
+		{"CONNECT 3.1.1", Version311, &Connect{
+			ProtocolName: "MQTT", ProtocolLevel: Version311, CleanStart: true, KeepAlive: 30, ClientID: "client",
+			WillFlag: true, WillQoS: 1, WillRetain: true, WillTopic: "will/topic", WillPayload: []byte("gone"),
+			UsernameFlag: true, Username: "user", PasswordFlag: true, Password: []byte("secret"),
+		}},
+		{"CONNECT 3.1.1 without credentials", Version311, NewConnect(Version311, "client")},
+		{"CONNECT 5.0", Version5, &Connect{
+			ProtocolName: "MQTT", ProtocolLevel: Version5, KeepAlive: 60, ClientID: "client",
+			Properties: Properties{
+				{PropSessionExpiry, uint32(3600)},
+				{PropReceiveMaximum, uint16(10)},
+				{PropUserProperty, StringPair{Key: "k", Value: "v"}},
+			},
+			WillFlag: true, WillTopic: "will/topic", WillPayload: []byte("gone"),
+			WillProperties: Properties{{PropWillDelayInterval, uint32(5)}, {PropPayloadFormat, byte(1)}},
+			UsernameFlag:   true, Username: "user",
+		}},
+		{"CONNACK 3.1.1", Version311, &Connack{SessionPresent: true, ReasonCode: 0x05}},
+		{"CONNACK 5.0", Version5, &Connack{ReasonCode: 0x00, Properties: Properties{
+			{PropAssignedClientID, "assigned"},
+			{PropMaximumQoS, byte(1)},
+			{PropServerKeepAlive, uint16(20)},
+		}}},
+		{"PUBLISH QoS 0 retained", Version311, &Publish{Retain: true, Topic: "a/b", Payload: []byte("payload")}},
+		{"PUBLISH QoS 1 dup", Version311, &Publish{Dup: true, QoS: 1, PacketID: 7, Topic: "a/b", Payload: []byte("payload")}},
+		{"PUBLISH 5.0", Version5, &Publish{QoS: 2, PacketID: 8, Topic: "a/b", Payload: []byte("payload"), Properties: Properties{
+			{PropMessageExpiry, uint32(60)},
+			{PropContentType, "text/plain"},
+			{PropCorrelationData, []byte{1, 2, 3}},
+			{PropSubscriptionIdentifier, VarInt(300)},
+			{PropTopicAlias, uint16(2)},
+		}}},
+		{"SUBSCRIBE 3.1.1", Version311, &Subscribe{PacketID: 1, Subscriptions: []Subscription{{"a/#", 1}, {"b/+", 0}}}},
+		{"SUBSCRIBE 5.0", Version5, &Subscribe{PacketID: 2, Properties: Properties{{PropSubscriptionIdentifier, VarInt(1)}},
+			Subscriptions: []Subscription{{"a/#", 0x2E}}}},
+		{"SUBACK 3.1.1", Version311, &Suback{PacketID: 1, ReasonCodes: []byte{0x01, 0x80}}},
+		{"SUBACK 5.0", Version5, &Suback{PacketID: 2, Properties: Properties{{PropReasonString, "denied"}}, ReasonCodes: []byte{0x87}}},
+		{"UNSUBSCRIBE 5.0", Version5, &Unsubscribe{PacketID: 3, Topics: []string{"a/#", "b"}}},
+		{"UNSUBACK 3.1.1", Version311, &Unsuback{PacketID: 3}},
+		{"UNSUBACK 5.0", Version5, &Unsuback{PacketID: 3, ReasonCodes: []byte{0x00, 0x11}}},
+		{"PUBACK 3.1.1", Version311, &Ack{PacketType: PUBACK, PacketID: 9}},
+		{"PUBACK 5.0 success", Version5, &Ack{PacketType: PUBACK, PacketID: 9}},
+		{"PUBREC 5.0", Version5, &Ack{PacketType: PUBREC, PacketID: 10, ReasonCode: 0x10}},
+		{"PUBREL 3.1.1", Version311, &Ack{PacketType: PUBREL, PacketID: 11}},
+		{"PUBCOMP 5.0", Version5, &Ack{PacketType: PUBCOMP, PacketID: 12, ReasonCode: 0x92, Properties: Properties{{PropReasonString, "unknown"}}}},
+		{"PINGREQ", Version311, &Pingreq{}},
+		{"PINGRESP", Version5, &Pingresp{}},
+		{"DISCONNECT 3.1.1", Version311, &Disconnect{}},
+		{"DISCONNECT 5.0", Version5, &Disconnect{ReasonCode: 0x04, Properties: Properties{{PropSessionExpiry, uint32(0)}}}},
+		{"AUTH 5.0", Version5, &Auth{ReasonCode: 0x18, Properties: Properties{{PropAuthMethod, "SCRAM-SHA-1"}, {PropAuthData, []byte{0xAA}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Encode(tt.packet, tt.version)
+			got, err := Decode(b, tt.version)
+			if err != nil {
+				t.Fatalf("Decode(% x) error: %v", b, err)
+			}
+			if c, ok := got.(*Connect); ok {
+				// The decoded connect flags are kept to see reserved bits, they must be the computed ones
+				if want := tt.packet.(*Connect).Flags(); c.ConnectFlags == nil || *c.ConnectFlags != want {
+					t.Fatalf("connect flags = %v, want 0x%02X", c.ConnectFlags, want)
+				}
+				c.ConnectFlags = nil
+			}
+			if !reflect.DeepEqual(got, tt.packet) {
+				t.Errorf("Decode(Encode(p)) = %+v, want %+v", got, tt.packet)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	flags := byte(0x0F)
+	length := MaxRemainingLength + 1
+	tests := []struct {
+		name    string
+		version byte
+		packet  Packet
+		want    []byte
+	}{
+		{"PINGREQ", Version311, &Pingreq{}, []byte{0xC0, 0x00}},
+		{"PUBREL flags", Version311, &Ack{PacketType: PUBREL, PacketID: 1}, []byte{0x62, 0x02, 0x00, 0x01}},
+		{"SUBSCRIBE flags", Version311, &Subscribe{PacketID: 1, Subscriptions: []Subscription{{"a", 1}}},
+			[]byte{0x82, 0x06, 0x00, 0x01, 0x00, 0x01, 'a', 0x01}},
+		{"PUBLISH QoS 1 retained", Version311, &Publish{QoS: 1, Retain: true, PacketID: 2, Topic: "t", Payload: []byte("p")},
+			[]byte{0x33, 0x06, 0x00, 0x01, 't', 0x00, 0x02, 'p'}},
+		{"PUBLISH 5.0 without properties", Version5, &Publish{Topic: "t"}, []byte{0x30, 0x04, 0x00, 0x01, 't', 0x00}},
+		{"header flags override", Version311, &Pingreq{Header: Header{HeaderFlags: &flags}}, []byte{0xCF, 0x00}},
+		{"remaining length override", Version311, &Pingreq{Header: Header{RemainingLength: &length}},
+			[]byte{0xC0, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{"remaining length of 2 bytes", Version311, &Raw{PacketType: PUBLISH, Body: make([]byte, 128)},
+			append([]byte{0x30, 0x80, 0x01}, make([]byte, 128)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Encode(tt.packet, tt.version); !bytes.Equal(got, tt.want) {
+				t.Errorf("Encode() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		b       []byte
+		want    Packet // The packet returned together with the error
+	}{
+		{"empty", Version311, nil, nil},
+		{"remaining length longer than 4 bytes", Version311, []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, &Raw{PacketType: PUBLISH}},
+		{"truncated remaining length", Version311, []byte{0x32, 0x80}, &Raw{PacketType: PUBLISH, Flags: 0x02}},
+		{"truncated body", Version311, []byte{0x30, 0x05, 0x00, 0x03, 'a'}, &Raw{PacketType: PUBLISH, Body: []byte{0x00, 0x03, 'a'}}},
+		{"reserved packet type", Version311, []byte{0x00, 0x01, 0xAA}, &Raw{PacketType: 0, Body: []byte{0xAA}}},
+		{"PUBLISH QoS 3", Version311, []byte{0x36, 0x03, 0x00, 0x01, 't'}, &Raw{PacketType: PUBLISH, Flags: 0x06, Body: []byte{0x00, 0x01, 't'}}},
+		{"PUBLISH topic longer than the body", Version311, []byte{0x30, 0x03, 0x00, 0x05, 't'}, &Raw{PacketType: PUBLISH, Body: []byte{0x00, 0x05, 't'}}},
+		{"CONNECT bytes after the payload", Version311,
+			[]byte{0x10, 0x0E, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x1E, 0x00, 0x01, 'c', 0xFF},
+			&Raw{PacketType: CONNECT, Body: []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x1E, 0x00, 0x01, 'c', 0xFF}}},
+		{"CONNECT truncated client ID", Version311,
+			[]byte{0x10, 0x0D, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x1E, 0x00, 0x05, 'c'},
+			&Raw{PacketType: CONNECT, Body: []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x1E, 0x00, 0x05, 'c'}}},
+		{"SUBSCRIBE without subscription options", Version311, []byte{0x82, 0x05, 0x00, 0x01, 0x00, 0x01, 'a'},
+			&Raw{PacketType: SUBSCRIBE, Flags: 0x02, Body: []byte{0x00, 0x01, 0x00, 0x01, 'a'}}},
+		{"PUBACK truncated packet identifier", Version311, []byte{0x40, 0x01, 0x00}, &Raw{PacketType: PUBACK, Body: []byte{0x00}}},
+		{"unknown property identifier", Version5, []byte{0x30, 0x06, 0x00, 0x01, 't', 0x02, 0x7F, 0x00},
+			&Raw{PacketType: PUBLISH, Body: []byte{0x00, 0x01, 't', 0x02, 0x7F, 0x00}}},
+		{"truncated property value", Version5, []byte{0x30, 0x07, 0x00, 0x01, 't', 0x03, 0x11, 0x00, 0x00},
+			&Raw{PacketType: PUBLISH, Body: []byte{0x00, 0x01, 't', 0x03, 0x11, 0x00, 0x00}}},
+		{"properties longer than the body", Version5, []byte{0xE0, 0x03, 0x00, 0x05, 0x11},
+			&Raw{PacketType: DISCONNECT, Body: []byte{0x00, 0x05, 0x11}}},
+		{"property identifier longer than 4 bytes", Version5, []byte{0xF0, 0x07, 0x18, 0x05, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F},
+			&Raw{PacketType: AUTH, Body: []byte{0x18, 0x05, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}}},
+		{"bytes after the packet", Version311, []byte{0xC0, 0x00, 0xC0}, &Pingreq{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.b, tt.version)
+			if !errors.Is(err, ErrMalformed) {
+				t.Fatalf("Decode(% x) error = %v, want ErrMalformed", tt.b, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(% x) = %+v, want %+v", tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	var stream []byte
+	stream = append(stream, Encode(&Publish{Topic: "a", Payload: []byte("1")}, Version311)...)
+	stream = append(stream, Encode(&Pingresp{}, Version311)...)
+	r := bytes.NewReader(stream)
+
+	if p, err := Read(r, Version311); err != nil || p.Type() != PUBLISH {
+		t.Fatalf("first Read() = %v, %v, want PUBLISH", p, err)
+	}
+	if p, err := Read(r, Version311); err != nil || p.Type() != PINGRESP {
+		t.Fatalf("second Read() = %v, %v, want PINGRESP", p, err)
+	}
+	// The end of the stream before a packet is not a malformed packet
+	if p, err := Read(r, Version311); err != io.EOF || p != nil {
+		t.Fatalf("Read() at the end = %v, %v, want nil, io.EOF", p, err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want *Raw
+	}{
+		{"in the remaining length", []byte{0x30, 0x80}, &Raw{PacketType: PUBLISH}},
+		{"in the body", []byte{0x90, 0x04, 0x00, 0x01}, &Raw{PacketType: SUBACK, Body: []byte{0x00, 0x01}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Read(bytes.NewReader(tt.b), Version311)
+			if !errors.Is(err, ErrMalformed) || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("Read() error = %v, want ErrMalformed and io.ErrUnexpectedEOF", err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("Read() = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		packet  Packet
+		want    string
+	}{
+		{"CONNACK 3.1.1 mapped", Version311, &Connack{ReasonCode: 0x05}, "Broker sent CONNACK reason code 0x87 Not authorized"},
+		{"CONNACK 5.0 banned", Version5, &Connack{ReasonCode: 0x8A}, "Broker sent CONNACK reason code 0x8A Banned"},
+		{"DISCONNECT unknown code", Version5, &Disconnect{ReasonCode: 0xFE}, "Broker sent DISCONNECT reason code 0xFE"},
+		{"PUBACK", Version5, &Ack{PacketType: PUBACK, PacketID: 1, ReasonCode: 0x87}, "Broker sent PUBACK reason code 0x87 Not authorized"},
+		{"SUBACK", Version311, &Suback{PacketID: 1, ReasonCodes: []byte{0x00, 0x80}}, "Broker sent SUBACK reason codes 00 80"},
+		{"PINGRESP", Version311, &Pingresp{}, "Broker sent PINGRESP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Describe(tt.packet, tt.version); got != tt.want {
+				t.Errorf("Describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package mqtt_packet
+
+import (
+	"fmt"
+)
+
+// MQTT 5.0 property identifiers, see MQTT 5.0 section 2.2.2.2 Property
+const (
+	PropPayloadFormat          byte = 0x01
+	PropMessageExpiry          byte = 0x02
+	PropContentType            byte = 0x03
+	PropResponseTopic          byte = 0x08
+	PropCorrelationData        byte = 0x09
+	PropSubscriptionIdentifier byte = 0x0B
+	PropSessionExpiry          byte = 0x11
+	PropAssignedClientID       byte = 0x12
+	PropServerKeepAlive        byte = 0x13
+	PropAuthMethod             byte = 0x15
+	PropAuthData               byte = 0x16
+	PropRequestProblemInfo     byte = 0x17
+	PropWillDelayInterval      byte = 0x18
+	PropRequestResponseInfo    byte = 0x19
+	PropResponseInfo           byte = 0x1A
+	PropServerReference        byte = 0x1C
+	PropReasonString           byte = 0x1F
+	PropReceiveMaximum         byte = 0x21
+	PropTopicAliasMaximum      byte = 0x22
+	PropTopicAlias             byte = 0x23
+	PropMaximumQoS             byte = 0x24
+	PropRetainAvailable        byte = 0x25
+	PropUserProperty           byte = 0x26
+	PropMaximumPacketSize      byte = 0x27
+	PropWildcardSubAvailable   byte = 0x28
+	PropSubIDAvailable         byte = 0x29
+	PropSharedSubAvailable     byte = 0x2A
+)
+
+// VarInt is a property value encoded as a variable byte integer
+type VarInt int
+
+// StringPair is a property value encoded as a UTF-8 string pair, used by user properties
+type StringPair struct {
+	Key   string
+	Value string
+}
+
+// RawValue is a property value which is encoded as is, e.g. to send a value of the wrong type
+type RawValue []byte
+
+// Property is a MQTT 5.0 property. The value is encoded by its Go type, whatever the identifier requires:
+// byte, uint16, uint32, VarInt, string(UTF-8 string), []byte(binary data), StringPair or RawValue.
+type Property struct {
+	ID    byte
+	Value any
+}
+
+// Properties is a list of MQTT 5.0 properties, encoded in order and including duplicates
+type Properties []Property
+
+// Get returns the value of the first property with the identifier
+func (ps Properties) Get(id byte) (any, bool) {
+	for _, p := range ps {
+		if p.ID == id {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the value of the first UTF-8 string property with the identifier, e.g. the reason string
+func (ps Properties) String(id byte) string {
+	v, _ := ps.Get(id)
+	s, _ := v.(string)
+	return s
+}
+
+// propertyKinds are the value types of the property identifiers, used to decode properties
+var propertyKinds = map[byte]any{
+	PropPayloadFormat:          byte(0),
+	PropMessageExpiry:          uint32(0),
+	PropContentType:            "",
+	PropResponseTopic:          "",
+	PropCorrelationData:        []byte(nil),
+	PropSubscriptionIdentifier: VarInt(0),
+	PropSessionExpiry:          uint32(0),
+	PropAssignedClientID:       "",
+	PropServerKeepAlive:        uint16(0),
+	PropAuthMethod:             "",
+	PropAuthData:               []byte(nil),
+	PropRequestProblemInfo:     byte(0),
+	PropWillDelayInterval:      uint32(0),
+	PropRequestResponseInfo:    byte(0),
+	PropResponseInfo:           "",
+	PropServerReference:        "",
+	PropReasonString:           "",
+	PropReceiveMaximum:         uint16(0),
+	PropTopicAliasMaximum:      uint16(0),
+	PropTopicAlias:             uint16(0),
+	PropMaximumQoS:             byte(0),
+	PropRetainAvailable:        byte(0),
+	PropUserProperty:           StringPair{},
+	PropMaximumPacketSize:      uint32(0),
+	PropWildcardSubAvailable:   byte(0),
+	PropSubIDAvailable:         byte(0),
+	PropSharedSubAvailable:     byte(0),
+}
+
+// appendProperties appends the properties prefixed with their length
+func appendProperties(b []byte, ps Properties) []byte {
+	var body []byte
+	for _, p := range ps {
+		body = appendVarInt(body, int(p.ID))
+		switch v := p.Value.(type) {
+		case byte:
+			body = append(body, v)
+		case uint16:
+			body = appendUint16(body, v)
+		case uint32:
+			body = appendUint32(body, v)
+		case VarInt:
+			body = appendVarInt(body, int(v))
+		case string:
+			body = appendString(body, v)
+		case []byte:
+			body = appendBinary(body, v)
+		case StringPair:
+			body = appendString(body, v.Key)
+			body = appendString(body, v.Value)
+		case RawValue:
+			body = append(body, v...)
+		}
+	}
+	b = appendVarInt(b, len(body))
+	return append(b, body...)
+}
+
+// properties decodes properties prefixed with their length
+func (r *reader) properties() Properties {
+	length := r.varInt()
+	pr := reader{b: r.take(length)}
+	if r.err != nil {
+		return nil
+	}
+
+	var ps Properties
+	for !pr.empty() {
+		id := pr.varInt()
+		kind, ok := propertyKinds[byte(id)]
+		if !ok || id > 0xFF {
+			r.err = fmt.Errorf("unknown property identifier 0x%02X", id)
+			return nil
+		}
+
+		var v any
+		switch kind.(type) {
+		case byte:
+			v = pr.byte()
+		case uint16:
+			v = pr.uint16()
+		case uint32:
+			v = pr.uint32()
+		case VarInt:
+			v = VarInt(pr.varInt())
+		case string:
+			v = pr.string()
+		case []byte:
+			v = pr.binary()
+		case StringPair:
+			v = StringPair{Key: pr.string(), Value: pr.string()}
+		}
+		ps = append(ps, Property{ID: byte(id), Value: v})
+	}
+	if pr.err != nil {
+		r.err = fmt.Errorf("invalid properties: %v", pr.err)
+	}
+	return ps
+}
@@ -0,0 +1,102 @@
+package mqtt_packet
+
+import "fmt"
+
+// Publish is the PUBLISH packet
+type Publish struct {
+	Header
+	Dup        bool
+	QoS        byte
+	Retain     bool
+	Topic      string
+	PacketID   uint16     // Encoded for QoS 1 and 2 only
+	Properties Properties // MQTT 5.0 only
+	Payload    []byte
+}
+
+func (p *Publish) Type() byte     { return PUBLISH }
+func (p *Publish) header() Header { return p.Header }
+
+func (p *Publish) flags() byte {
+	flags := (p.QoS & 0x03) << 1
+	if p.Dup {
+		flags |= 0x08
+	}
+	if p.Retain {
+		flags |= 0x01
+	}
+	return flags
+}
+
+func (p *Publish) encode(version byte) []byte {
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = appendUint16(b, p.PacketID)
+	}
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	return append(b, p.Payload...)
+}
+
+func (p *Publish) decode(flags byte, body []byte, version byte) error {
+	p.Dup = flags&0x08 != 0
+	p.QoS = flags >> 1 & 0x03
+	p.Retain = flags&0x01 != 0
+	if p.QoS == 3 {
+		return fmt.Errorf("invalid QoS 3")
+	}
+
+	r := reader{b: body}
+	p.Topic = r.string()
+	if p.QoS > 0 {
+		p.PacketID = r.uint16()
+	}
+	if version == Version5 {
+		p.Properties = r.properties()
+	}
+	p.Payload = r.rest()
+	return r.err
+}
+
+// Ack is the PUBACK, PUBREC, PUBREL or PUBCOMP packet which acknowledges a PUBLISH packet
+type Ack struct {
+	Header
+	PacketType byte // PUBACK, PUBREC, PUBREL or PUBCOMP
+	PacketID   uint16
+	ReasonCode byte       // MQTT 5.0 only, omitted when it is 0 and there are no properties
+	Properties Properties // MQTT 5.0 only
+}
+
+func (p *Ack) Type() byte     { return p.PacketType }
+func (p *Ack) header() Header { return p.Header }
+
+func (p *Ack) flags() byte {
+	if p.PacketType == PUBREL {
+		return 0x02
+	}
+	return 0
+}
+
+func (p *Ack) encode(version byte) []byte {
+	b := appendUint16(nil, p.PacketID)
+	if version == Version5 && (p.ReasonCode != 0 || len(p.Properties) > 0) {
+		b = append(b, p.ReasonCode)
+		if len(p.Properties) > 0 {
+			b = appendProperties(b, p.Properties)
+		}
+	}
+	return b
+}
+
+func (p *Ack) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.PacketID = r.uint16()
+	if version == Version5 && !r.empty() {
+		p.ReasonCode = r.byte()
+		if !r.empty() {
+			p.Properties = r.properties()
+		}
+	}
+	return r.err
+}
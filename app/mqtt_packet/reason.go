@@ -0,0 +1,111 @@
+package mqtt_packet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ReasonCode is a MQTT 5.0 reason code, MQTT 3.1.1 return codes are mapped to their MQTT 5.0 equivalents
+type ReasonCode byte
+
+// Reason codes the checks interpret, see MQTT 5.0 section 2.4 Reason Code
+const (
+	ReasonSuccess                  ReasonCode = 0x00
+	ReasonUnspecifiedError         ReasonCode = 0x80
+	ReasonMalformedPacket          ReasonCode = 0x81
+	ReasonProtocolError            ReasonCode = 0x82
+	ReasonImplementationError      ReasonCode = 0x83
+	ReasonUnsupportedProtocol      ReasonCode = 0x84
+	ReasonClientIDNotValid         ReasonCode = 0x85
+	ReasonBadUsernameOrPassword    ReasonCode = 0x86
+	ReasonNotAuthorized            ReasonCode = 0x87
+	ReasonServerUnavailable        ReasonCode = 0x88
+	ReasonServerBusy               ReasonCode = 0x89
+	ReasonBanned                   ReasonCode = 0x8A
+	ReasonBadAuthMethod            ReasonCode = 0x8C
+	ReasonTopicFilterInvalid       ReasonCode = 0x8F
+	ReasonTopicNameInvalid         ReasonCode = 0x90
+	ReasonPacketTooLarge           ReasonCode = 0x95
+	ReasonQuotaExceeded            ReasonCode = 0x97
+	ReasonPayloadFormatInvalid     ReasonCode = 0x99
+	ReasonUseAnotherServer         ReasonCode = 0x9C
+	ReasonServerMoved              ReasonCode = 0x9D
+	ReasonConnectionRateExceeded   ReasonCode = 0x9F
+	ReasonMaximumConnectTime       ReasonCode = 0xA0
+	ReasonWildcardSubsNotSupported ReasonCode = 0xA2
+)
+
+var reasonNames = map[ReasonCode]string{
+	ReasonSuccess:                  "Success",
+	ReasonUnspecifiedError:         "Unspecified error",
+	ReasonMalformedPacket:          "Malformed Packet",
+	ReasonProtocolError:            "Protocol Error",
+	ReasonImplementationError:      "Implementation specific error",
+	ReasonUnsupportedProtocol:      "Unsupported Protocol Version",
+	ReasonClientIDNotValid:         "Client Identifier not valid",
+	ReasonBadUsernameOrPassword:    "Bad User Name or Password",
+	ReasonNotAuthorized:            "Not authorized",
+	ReasonServerUnavailable:        "Server unavailable",
+	ReasonServerBusy:               "Server busy",
+	ReasonBanned:                   "Banned",
+	ReasonBadAuthMethod:            "Bad authentication method",
+	ReasonTopicFilterInvalid:       "Topic Filter invalid",
+	ReasonTopicNameInvalid:         "Topic Name invalid",
+	ReasonPacketTooLarge:           "Packet too large",
+	ReasonQuotaExceeded:            "Quota exceeded",
+	ReasonPayloadFormatInvalid:     "Payload format invalid",
+	ReasonUseAnotherServer:         "Use another server",
+	ReasonServerMoved:              "Server moved",
+	ReasonConnectionRateExceeded:   "Connection rate exceeded",
+	ReasonMaximumConnectTime:       "Maximum connect time",
+	ReasonWildcardSubsNotSupported: "Wildcard Subscriptions not supported",
+}
+
+func (c ReasonCode) String() string {
+	if name, ok := reasonNames[c]; ok {
+		return fmt.Sprintf("0x%02X %s", byte(c), name)
+	}
+	return fmt.Sprintf("0x%02X", byte(c))
+}
+
+// connackV3 maps the MQTT 3.1.1 CONNACK return codes to the MQTT 5.0 reason codes
+var connackV3 = map[byte]ReasonCode{
+	0x01: ReasonUnsupportedProtocol,
+	0x02: ReasonClientIDNotValid,
+	0x03: ReasonServerUnavailable,
+	0x04: ReasonBadUsernameOrPassword,
+	0x05: ReasonNotAuthorized,
+}
+
+// ConnackReason returns the reason code of a CONNACK packet of the protocol version
+func ConnackReason(version, code byte) ReasonCode {
+	if c, ok := connackV3[code]; ok && version == Version311 {
+		return c
+	}
+	return ReasonCode(code)
+}
+
+// Describe describes a packet received from the broker, including its reason codes
+func Describe(p Packet, version byte) string {
+	switch p := p.(type) {
+	case *Connack:
+		return fmt.Sprintf("Broker sent CONNACK reason code %s", ConnackReason(version, p.ReasonCode))
+	case *Disconnect:
+		return fmt.Sprintf("Broker sent DISCONNECT reason code %s", ReasonCode(p.ReasonCode))
+	case *Ack:
+		return fmt.Sprintf("Broker sent %s reason code %s", TypeName(p.Type()), ReasonCode(p.ReasonCode))
+	case *Suback:
+		return fmt.Sprintf("Broker sent SUBACK reason codes % x", p.ReasonCodes)
+	case *Unsuback:
+		return fmt.Sprintf("Broker sent UNSUBACK reason codes % x", p.ReasonCodes)
+	default:
+		return fmt.Sprintf("Broker sent %s", TypeName(p.Type()))
+	}
+}
+
+// IsTimeout reports whether err is a network timeout, e.g. of a Receive which got no packet in time
+func IsTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,183 @@
+package mqtt_packet
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"mqtt-security-scanner/config"
+)
+
+// dialTimeout bounds the TCP, TLS and WebSocket handshakes of a listener connection
+const dialTimeout = 10 * time.Second
+
+// Dial opens a connection to the listener which MQTT packets can be written to and read from,
+// WebSocket listeners are connected with the "mqtt" subprotocol and the packets are carried in binary messages.
+// TLS listeners are connected with the TLS configuration of the listener.
+func Dial(ctx context.Context, l config.Listener) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	address := net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+	tlsConfig := l.TLSConfig()
+
+	switch l.Transport {
+	case config.TransportSSL:
+		d := tls.Dialer{Config: tlsConfig}
+		return d.DialContext(ctx, "tcp", address)
+	case config.TransportWS, config.TransportWSS:
+		d := websocket.Dialer{
+			Subprotocols:     []string{"mqtt"},
+			TLSClientConfig:  tlsConfig,
+			HandshakeTimeout: dialTimeout,
+		}
+		ws, resp, err := d.DialContext(ctx, l.URL(), http.Header{})
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return WebSocketConn(ws), nil
+	default:
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", address)
+	}
+}
+
+// WebSocketConn adapts an established WebSocket connection to a net.Conn carrying MQTT packets in binary messages
+func WebSocketConn(ws *websocket.Conn) net.Conn {
+	return &wsConn{Conn: ws}
+}
+
+// wsConn adapts a WebSocket connection to a net.Conn carrying a byte stream in binary messages
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader // The message being read, nil if the next message must be read first
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			// Messages may end in the middle of a MQTT packet, so the stream continues with the next message
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// ProtocolLevel returns the protocol level of a config.ProtocolMQTT* version, MQTT 3.1.1 if it is unknown
+func ProtocolLevel(protocol string) byte {
+	if protocol == config.ProtocolMQTT5 {
+		return Version5
+	}
+	return Version311
+}
+
+// Session is a raw connection to a broker which sends packets exactly as they are built,
+// for the checks a MQTT client library cannot express. It does not acknowledge or ping on its own.
+type Session struct {
+	Version byte // The protocol version packets are encoded and decoded with
+
+	conn   net.Conn
+	reader *bufio.Reader
+	stop   func() bool
+}
+
+// DialSession opens a raw session to the listener with the protocol version of the listener,
+// the connection is closed when the context is done
+func DialSession(ctx context.Context, l config.Listener) (*Session, error) {
+	conn, err := Dial(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	s := NewSession(conn, ProtocolLevel(l.Protocol))
+	s.stop = context.AfterFunc(ctx, func() { conn.Close() })
+	return s, nil
+}
+
+// NewSession returns a raw session over an open connection
+func NewSession(conn net.Conn, version byte) *Session {
+	return &Session{Version: version, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Send encodes and writes the packet
+func (s *Session) Send(p Packet) error {
+	return s.SendBytes(Encode(p, s.Version))
+}
+
+// SendBytes writes bytes as they are, e.g. a truncated or mutated packet
+func (s *Session) SendBytes(b []byte) error {
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// Receive reads and decodes the next packet, waiting at most the timeout.
+// Packets which cannot be decoded are returned as *Raw together with an error wrapping ErrMalformed.
+// A timed out Receive leaves a WebSocket session unusable, as the WebSocket connection fails on a read timeout.
+func (s *Session) Receive(timeout time.Duration) (Packet, error) {
+	if err := s.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	return Read(s.reader, s.Version)
+}
+
+// Connect sends the CONNECT packet and waits for the CONNACK packet.
+// The CONNACK packet is returned whatever its reason code, other packets are an error.
+func (s *Session) Connect(p *Connect, timeout time.Duration) (*Connack, error) {
+	if err := s.Send(p); err != nil {
+		return nil, err
+	}
+	reply, err := s.Receive(timeout)
+	if err != nil {
+		return nil, err
+	}
+	connack, ok := reply.(*Connack)
+	if !ok {
+		return nil, fmt.Errorf("%s received instead of CONNACK", TypeName(reply.Type()))
+	}
+	return connack, nil
+}
+
+// Close closes the connection
+func (s *Session) Close() error {
+	if s.stop != nil {
+		s.stop()
+	}
+	return s.conn.Close()
+}
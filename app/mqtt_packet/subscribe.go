@@ -0,0 +1,141 @@
+package mqtt_packet
+
+// Subscription is a topic filter of a SUBSCRIBE packet
+type Subscription struct {
+	Topic string
+	// Options are the subscription options, the maximum QoS in the lowest 2 bits,
+	// and in MQTT 5.0 No Local(0x04), Retain As Published(0x08) and Retain Handling(0x30)
+	Options byte
+}
+
+// Subscribe is the SUBSCRIBE packet
+type Subscribe struct {
+	Header
+	PacketID      uint16
+	Properties    Properties // MQTT 5.0 only
+	Subscriptions []Subscription
+}
+
+func (p *Subscribe) Type() byte     { return SUBSCRIBE }
+func (p *Subscribe) header() Header { return p.Header }
+func (p *Subscribe) flags() byte    { return 0x02 }
+
+func (p *Subscribe) encode(version byte) []byte {
+	b := appendUint16(nil, p.PacketID)
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	for _, s := range p.Subscriptions {
+		b = appendString(b, s.Topic)
+		b = append(b, s.Options)
+	}
+	return b
+}
+
+func (p *Subscribe) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.PacketID = r.uint16()
+	if version == Version5 {
+		p.Properties = r.properties()
+	}
+	for !r.empty() {
+		p.Subscriptions = append(p.Subscriptions, Subscription{Topic: r.string(), Options: r.byte()})
+	}
+	return r.err
+}
+
+// Suback is the SUBACK packet, the reason codes are the return codes in MQTT 3.1.1
+type Suback struct {
+	Header
+	PacketID    uint16
+	Properties  Properties // MQTT 5.0 only
+	ReasonCodes []byte
+}
+
+func (p *Suback) Type() byte     { return SUBACK }
+func (p *Suback) header() Header { return p.Header }
+func (p *Suback) flags() byte    { return 0 }
+
+func (p *Suback) encode(version byte) []byte {
+	b := appendUint16(nil, p.PacketID)
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	return append(b, p.ReasonCodes...)
+}
+
+func (p *Suback) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.PacketID = r.uint16()
+	if version == Version5 {
+		p.Properties = r.properties()
+	}
+	p.ReasonCodes = r.rest()
+	return r.err
+}
+
+// Unsubscribe is the UNSUBSCRIBE packet
+type Unsubscribe struct {
+	Header
+	PacketID   uint16
+	Properties Properties // MQTT 5.0 only
+	Topics     []string
+}
+
+func (p *Unsubscribe) Type() byte     { return UNSUBSCRIBE }
+func (p *Unsubscribe) header() Header { return p.Header }
+func (p *Unsubscribe) flags() byte    { return 0x02 }
+
+func (p *Unsubscribe) encode(version byte) []byte {
+	b := appendUint16(nil, p.PacketID)
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+	}
+	for _, t := range p.Topics {
+		b = appendString(b, t)
+	}
+	return b
+}
+
+func (p *Unsubscribe) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.PacketID = r.uint16()
+	if version == Version5 {
+		p.Properties = r.properties()
+	}
+	for !r.empty() {
+		p.Topics = append(p.Topics, r.string())
+	}
+	return r.err
+}
+
+// Unsuback is the UNSUBACK packet
+type Unsuback struct {
+	Header
+	PacketID    uint16
+	Properties  Properties // MQTT 5.0 only
+	ReasonCodes []byte     // MQTT 5.0 only
+}
+
+func (p *Unsuback) Type() byte     { return UNSUBACK }
+func (p *Unsuback) header() Header { return p.Header }
+func (p *Unsuback) flags() byte    { return 0 }
+
+func (p *Unsuback) encode(version byte) []byte {
+	b := appendUint16(nil, p.PacketID)
+	if version == Version5 {
+		b = appendProperties(b, p.Properties)
+		b = append(b, p.ReasonCodes...)
+	}
+	return b
+}
+
+func (p *Unsuback) decode(_ byte, body []byte, version byte) error {
+	r := reader{b: body}
+	p.PacketID = r.uint16()
+	if version == Version5 {
+		p.Properties = r.properties()
+		p.ReasonCodes = r.rest()
+	}
+	return r.err
+}
@@ -0,0 +1,316 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/config"
+)
+
+// connectTimeout bounds connection attempts which previously waited without limit
+const connectTimeout = 10 * time.Second
+
+// disconnectWait is how long a failed MQTT 5.0 operation waits for the DISCONNECT packet telling why it failed
+const disconnectWait = time.Second
+
+var errTimeout = errors.New("MQTT operation timeout")
+
+// Client is a MQTT client which connects to a listener with the protocol version of the listener.
+// Rejections are returned as *ReasonError, so that the scanners interpret MQTT 3.1.1 and 5.0 results the same way.
+// All operations return errTimeout if the broker does not answer within the timeout, a timeout of 0 only waits for the context.
+type Client interface {
+	// Connect connects to the broker, a CONNACK refusing the connection is returned as a *ReasonError
+	Connect(ctx context.Context, timeout time.Duration) error
+	// Subscribe subscribes to the topic with QoS 1, a SUBACK refusing the subscription is returned as a *ReasonError
+	Subscribe(ctx context.Context, topic string, timeout time.Duration) error
+	// Publish publishes the payload to the topic with QoS 1, a PUBACK refusing the message is returned as a *ReasonError
+	Publish(ctx context.Context, topic, payload string, timeout time.Duration) error
+	// Disconnect closes the connection, it does nothing if the client is not connected
+	Disconnect()
+}
+
+// NewMQTTClient returns a new mqtt client with the specified connection settings
+// TLS listeners are connected with the TLS configuration of the listener
+func NewMQTTClient(l config.Listener, clientID, username, password string) Client {
+	if l.Protocol == config.ProtocolMQTT5 {
+		return &mqtt5Client{
+			listener: l,
+			connect: paho.Connect{
+				ClientID:     clientID,
+				Username:     username,
+				UsernameFlag: username != "",
+				Password:     []byte(password),
+				PasswordFlag: password != "",
+				KeepAlive:    30,
+				CleanStart:   true,
+			},
+		}
+	}
+
+	// Initialize a new mqtt client options
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(l.URL())
+	if l.TLS() {
+		opts.SetTLSConfig(l.TLSConfig())
+	}
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetClientID(clientID)
+	// Without an explicit version a refused connection is retried with MQTT 3.1
+	opts.SetProtocolVersion(4)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectTimeout(connectTimeout)
+
+	// Create a new mqtt client
+	return &mqtt3Client{client: mqtt.NewClient(opts)}
+}
+
+// clientID returns the client ID of a scanner connection, unique per listener and protocol version
+// so that instances of a scanner running concurrently do not take over each other's session
+func clientID(l config.Listener, name string) string {
+	return fmt.Sprintf("mqtt-security-scanner-%s-%s-v%s", name, l.Transport, strings.ReplaceAll(l.Protocol, ".", ""))
+}
+
+// mqtt3Client is a MQTT 3.1.1 client
+type mqtt3Client struct {
+	client mqtt.Client
+}
+
+func (c *mqtt3Client) Connect(ctx context.Context, timeout time.Duration) error {
+	token := c.client.Connect()
+	err := waitToken(ctx, token, timeout)
+	if err == nil || errors.Is(err, errTimeout) || ctx.Err() != nil {
+		return err
+	}
+	// Only the CONNACK return codes refuse the connection, paho reports network failures with codes of its own
+	if rc := token.(*mqtt.ConnectToken).ReturnCode(); rc >= 0x01 && rc <= 0x05 {
+		return &ReasonError{Packet: "CONNACK", Code: connackReason(mqtt_packet.Version311, rc)}
+	}
+	return err
+}
+
+func (c *mqtt3Client) Subscribe(ctx context.Context, topic string, timeout time.Duration) error {
+	token := c.client.Subscribe(topic, 1, nil)
+	if err := waitToken(ctx, token, timeout); err != nil {
+		return c.closed(err)
+	}
+	// MQTT 3.1.1 refuses a subscription with the return code 0x80 without failing the token
+	for _, code := range token.(*mqtt.SubscribeToken).Result() {
+		if code >= byte(ReasonUnspecifiedError) {
+			return &ReasonError{Packet: "SUBACK", Code: ReasonCode(code)}
+		}
+	}
+	return nil
+}
+
+func (c *mqtt3Client) Publish(ctx context.Context, topic, payload string, timeout time.Duration) error {
+	if err := waitToken(ctx, c.client.Publish(topic, 1, false, payload), timeout); err != nil {
+		return c.closed(err)
+	}
+	return nil
+}
+
+func (c *mqtt3Client) Disconnect() {
+	if c.client.IsConnected() {
+		// Disconnect(0) returns before the client is disconnected, so that a following Connect may fail as already connected
+		c.client.Disconnect(10)
+	}
+}
+
+// closed marks the error of a failed operation with errConnectionClosed if the broker closed the connection,
+// which is how MQTT 3.1.1 brokers refuse a packet
+func (c *mqtt3Client) closed(err error) error {
+	if errors.Is(err, errTimeout) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if !c.client.IsConnectionOpen() {
+		return fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+	return err
+}
+
+// mqtt5Client is a MQTT 5.0 client, every Connect opens a new connection
+type mqtt5Client struct {
+	listener   config.Listener
+	connect    paho.Connect
+	client     *paho.Client          // The client of the open connection, nil if not connected
+	disconnect chan *paho.Disconnect // Receives the DISCONNECT packet the broker closed the connection with
+}
+
+func (c *mqtt5Client) Connect(ctx context.Context, timeout time.Duration) error {
+	opCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := mqtt_packet.Dial(opCtx, c.listener)
+	if err != nil {
+		return timedOut(ctx, opCtx, err)
+	}
+
+	disconnect := make(chan *paho.Disconnect, 1)
+	client := paho.NewClient(paho.ClientConfig{
+		// The connection is written by the client and its pinger concurrently
+		Conn: packets.NewThreadSafeConn(conn),
+		// Operations are bounded by the timeouts passed to the client instead
+		PacketTimeout:      time.Hour,
+		OnServerDisconnect: func(d *paho.Disconnect) { disconnect <- d },
+		OnClientError:      func(error) {},
+	})
+
+	connect := c.connect
+	ca, err := client.Connect(opCtx, &connect)
+	if ca != nil && ca.ReasonCode >= byte(ReasonUnspecifiedError) {
+		return &ReasonError{Packet: "CONNACK", Code: ReasonCode(ca.ReasonCode)}
+	}
+	if err != nil {
+		return timedOut(ctx, opCtx, err)
+	}
+
+	c.client, c.disconnect = client, disconnect
+	return nil
+}
+
+func (c *mqtt5Client) Subscribe(ctx context.Context, topic string, timeout time.Duration) error {
+	if c.client == nil {
+		return errors.New("MQTT client is not connected")
+	}
+	opCtx, cancel := c.operation(ctx, timeout)
+	defer cancel()
+
+	sa, err := c.client.Subscribe(opCtx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: 1}},
+	})
+	if sa != nil && len(sa.Reasons) > 0 && sa.Reasons[0] >= byte(ReasonUnspecifiedError) {
+		return &ReasonError{Packet: "SUBACK", Code: ReasonCode(sa.Reasons[0])}
+	}
+	if err != nil {
+		return c.closed(ctx, opCtx, err)
+	}
+	return nil
+}
+
+func (c *mqtt5Client) Publish(ctx context.Context, topic, payload string, timeout time.Duration) error {
+	if c.client == nil {
+		return errors.New("MQTT client is not connected")
+	}
+	opCtx, cancel := c.operation(ctx, timeout)
+	defer cancel()
+
+	pr, err := c.client.Publish(opCtx, &paho.Publish{Topic: topic, QoS: 1, Payload: []byte(payload)})
+	if pr != nil && pr.ReasonCode >= byte(ReasonUnspecifiedError) {
+		return &ReasonError{Packet: "PUBACK", Code: ReasonCode(pr.ReasonCode)}
+	}
+	if err != nil {
+		return c.closed(ctx, opCtx, err)
+	}
+	return nil
+}
+
+func (c *mqtt5Client) Disconnect() {
+	if c.client == nil {
+		return
+	}
+	c.client.Disconnect(&paho.Disconnect{ReasonCode: byte(ReasonSuccess)})
+	c.client = nil
+}
+
+// operation returns the context of an operation on the open connection, which expires after the timeout.
+// It is cancelled with errConnectionClosed when the connection is closed, as in-flight messages are otherwise
+// kept for a retransmission which never happens.
+func (c *mqtt5Client) operation(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	timeoutCtx, cancelTimeout := withTimeout(ctx, timeout)
+	opCtx, cancel := context.WithCancelCause(timeoutCtx)
+
+	done := c.client.Done()
+	go func() {
+		select {
+		case <-done:
+			cancel(errConnectionClosed)
+		case <-opCtx.Done():
+		}
+	}()
+	return opCtx, func() {
+		cancel(nil)
+		cancelTimeout()
+	}
+}
+
+// closed returns the reason code of the DISCONNECT packet if the broker closed the connection after an operation failed,
+// errConnectionClosed if it closed the connection without one, and the error of the operation otherwise
+func (c *mqtt5Client) closed(ctx, opCtx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if opCtx.Err() != nil {
+		if !errors.Is(context.Cause(opCtx), errConnectionClosed) {
+			return errTimeout
+		}
+		err = errors.New("operation not acknowledged")
+	}
+
+	timer := time.NewTimer(disconnectWait)
+	defer timer.Stop()
+
+	select {
+	case d := <-c.disconnect:
+		return &ReasonError{Packet: "DISCONNECT", Code: ReasonCode(d.ReasonCode)}
+	case <-c.client.Done():
+	case <-timer.C:
+		return err
+	}
+	// The DISCONNECT packet is delivered right after the client is done
+	select {
+	case d := <-c.disconnect:
+		return &ReasonError{Packet: "DISCONNECT", Code: ReasonCode(d.ReasonCode)}
+	case <-timer.C:
+		return fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+}
+
+// withTimeout returns a context which expires after the timeout, a timeout of 0 only cancels with the parent context
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timedOut returns the error of an operation bounded by opCtx, which is derived from ctx.
+// It returns the context error if ctx is done, and errTimeout if only opCtx expired.
+func timedOut(ctx, opCtx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if opCtx.Err() != nil {
+		return errTimeout
+	}
+	return err
+}
+
+// waitToken waits for the token to complete and returns its error.
+// It returns errTimeout if the token does not complete within the timeout,
+// or the context error if the context is done first. A timeout of 0 only waits for the context.
+func waitToken(ctx context.Context, token mqtt.Token, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-expired:
+		return errTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
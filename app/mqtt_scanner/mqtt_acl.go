@@ -0,0 +1,290 @@
+package mqtt_scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+// deliveryTimeout is how long an observer waits for a published message before it is considered dropped
+const deliveryTimeout = 3 * time.Second
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "message.acl",
+		Name:     "MQTT ACL Matrix",
+		Category: scanner.CategoryMessage,
+		Description: "Check that the broker allows and denies publishing and subscribing as configured in the ACL matrix, " +
+			"publishes are confirmed by an observer client receiving the message or not",
+		Severity: config.SeverityHigh,
+		Remediation: "Add the rules of the matrix to the authorization sources in `authorization.sources` and set `authorization.no_match` to `deny`. " +
+			"Set `authorization.deny_action` to `disconnect` so that clients notice denied publishes instead of the broker dropping them silently.",
+		References: []string{
+			"MQTT 3.1.1 section 5.4.2 Authorization of Clients by the Server",
+			"MQTT 5.0 section 3.4.2.1 PUBACK Reason Code",
+			"MQTT 5.0 section 3.9.3 SUBACK Payload",
+		},
+		Skip: func(cfg *config.Config) string {
+			if len(cfg.ACL.Rules) == 0 {
+				return "No ACL rules are configured"
+			}
+			return ""
+		},
+		RunListener: MQTTACLMatrix,
+	}))
+}
+
+// MQTTACLMatrix verifies every rule of the ACL matrix. A publish is allowed if the observer receives the message,
+// whatever the broker acknowledged, since brokers often acknowledge denied publishes and drop them.
+// A subscription is allowed if the broker grants it in the SUBACK. An identity whose CONNECT the broker refuses
+// is allowed neither. A check which cannot be verified, e.g. because the observer cannot subscribe,
+// is reported in the evidence and the remaining checks go on.
+func MQTTACLMatrix(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT ACL Matrix")
+	observer := aclObserver(cfg)
+	var verified int
+	var lastErr error
+
+	for _, rule := range cfg.ACL.Rules {
+		id, _ := cfg.ACL.Identity(rule.Identity)
+		expected := "deny"
+		if rule.Allow {
+			expected = "allow"
+		}
+
+		type check struct {
+			action string
+			run    func() (bool, string, error)
+		}
+		var checks []check
+		if rule.Publishes() {
+			checks = append(checks, check{config.ActionPublish, func() (bool, string, error) {
+				return checkPublish(ctx, l, observer, id, rule.Topic)
+			}})
+		}
+		if rule.Subscribes() {
+			checks = append(checks, check{config.ActionSubscribe, func() (bool, string, error) {
+				return checkSubscribe(ctx, l, id, rule.Topic)
+			}})
+		}
+
+		for _, c := range checks {
+			allowed, observed, err := c.run()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				si.Evidence = append(si.Evidence, fmt.Sprintf("%s %s %s (expected %s): not verified, %v", id.Name, c.action, rule.Topic, expected, err))
+				lastErr = err
+				continue
+			}
+			verified++
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s %s %s (expected %s): %s", id.Name, c.action, rule.Topic, expected, observed))
+			switch {
+			case allowed && !rule.Allow:
+				si.Message = append(si.Message, fmt.Sprintf("%s can %s %s although it is denied", id.Name, c.action, rule.Topic))
+			case !allowed && rule.Allow:
+				si.Message = append(si.Message, fmt.Sprintf("%s cannot %s %s although it is allowed", id.Name, c.action, rule.Topic))
+			}
+		}
+	}
+
+	if verified == 0 {
+		return nil, fmt.Errorf("no check of the ACL matrix could be verified: %w", lastErr)
+	}
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// aclObserver returns the configured observer, or the broker credentials if no observer is configured
+func aclObserver(cfg *config.Config) config.Identity {
+	observer := cfg.ACL.Observer
+	if observer.Username == "" {
+		observer.Username, observer.Password = cfg.BrokerInfo.Username, cfg.BrokerInfo.Password
+	}
+	if observer.Name == "" {
+		observer.Name = "observer"
+	}
+	return observer
+}
+
+// checkPublish publishes a message to the topic as the identity, and reports whether the observer received it.
+// The publish is not allowed if the broker refuses the CONNECT of the identity.
+func checkPublish(ctx context.Context, l config.Listener, observer, id config.Identity, topic string) (bool, string, error) {
+	obs, err := identitySession(ctx, l, observer, "acl-"+observer.Name)
+	if err != nil {
+		return false, "", err
+	}
+	defer obs.Close()
+	if code, err := rawSubscribe(obs, topic); err != nil || code >= ReasonUnspecifiedError {
+		return false, "", fmt.Errorf("observer %s cannot subscribe to %s to confirm the delivery: %s", observer.Name, topic, describeResult("SUBACK", code, err))
+	}
+
+	pub, err := identitySession(ctx, l, id, "acl-"+id.Name)
+	if connectRefused(err) {
+		return false, err.Error(), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	defer pub.Close()
+	payload := []byte("mqtt-security-scanner " + RandomString(16))
+	code, err := rawPublish(pub, topic, payload, false)
+	acked := describeResult("PUBACK", code, err)
+
+	delivered, err := awaitMessage(obs, topic, payload, deliveryTimeout)
+	if ctx.Err() != nil {
+		return false, "", ctx.Err()
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("observer %s waiting for the message on %s: %w", observer.Name, topic, err)
+	}
+	if delivered {
+		return true, acked + ", message delivered to the observer", nil
+	}
+	return false, fmt.Sprintf("%s, message not delivered to the observer within %v", acked, deliveryTimeout), nil
+}
+
+// checkSubscribe subscribes to the topic filter as the identity, and reports whether the broker granted the subscription.
+// The subscription is not allowed if the broker refuses the CONNECT of the identity.
+func checkSubscribe(ctx context.Context, l config.Listener, id config.Identity, topic string) (bool, string, error) {
+	s, err := identitySession(ctx, l, id, "acl-"+id.Name)
+	if connectRefused(err) {
+		return false, err.Error(), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	defer s.Close()
+	code, err := rawSubscribe(s, topic)
+	if ctx.Err() != nil {
+		return false, "", ctx.Err()
+	}
+	return err == nil && code < ReasonUnspecifiedError, describeResult("SUBACK", code, err), nil
+}
+
+// identitySession opens a raw session to the listener connected with the credentials of the identity,
+// the name makes the client ID unique among the sessions of a scanner. The CONNECT packet is configured before it is sent.
+// A CONNECT the broker refuses with a reason code or by closing the connection is reported by connectRefused.
+func identitySession(ctx context.Context, l config.Listener, id config.Identity, name string, configure ...func(*mqtt_packet.Connect)) (*mqtt_packet.Session, error) {
+	s, err := mqtt_packet.DialSession(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	connack, err := s.Connect(identityConnect(l, s.Version, id, name, configure...), connectTimeout)
+	switch {
+	case err == nil && connack.ReasonCode != 0:
+		err = &ReasonError{Packet: "CONNACK", Code: connackReason(s.Version, connack.ReasonCode)}
+	case isTimeout(err):
+		err = errTimeout
+	case err != nil && !errors.Is(err, mqtt_packet.ErrMalformed):
+		err = fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+	if err != nil {
+		s.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("connect as %s: %w", id.Name, err)
+	}
+	return s, nil
+}
+
+// connectRefused reports whether identitySession failed because the broker refused the CONNECT,
+// rather than because the listener cannot be reached or does not answer
+func connectRefused(err error) bool {
+	_, ok := reasonCode(err)
+	return ok || errors.Is(err, errConnectionClosed)
+}
+
+// identityConnect returns the CONNECT packet of the protocol version with the credentials of the identity,
+// configured before it is sent
+func identityConnect(l config.Listener, version byte, id config.Identity, name string, configure ...func(*mqtt_packet.Connect)) *mqtt_packet.Connect {
+	connect := mqtt_packet.NewConnect(version, clientID(l, name))
+	if id.Username != "" {
+		connect.UsernameFlag, connect.Username = true, id.Username
+	}
+	if id.Password != "" {
+		connect.PasswordFlag, connect.Password = true, []byte(id.Password)
+	}
+	for _, c := range configure {
+		c(connect)
+	}
+	return connect
+}
+
+// rawSubscribe subscribes the session to the topic filter with QoS 0 and returns the reason code of the SUBACK packet,
+// MQTT 3.1.1 return codes are valid MQTT 5.0 reason codes. Messages received before the SUBACK packet are discarded.
+func rawSubscribe(s *mqtt_packet.Session, topic string) (ReasonCode, error) {
+	subscribe := &mqtt_packet.Subscribe{PacketID: 1, Subscriptions: []mqtt_packet.Subscription{{Topic: topic}}}
+	if err := s.Send(subscribe); err != nil {
+		return 0, fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+	for {
+		p, err := s.Receive(connectTimeout)
+		if err != nil {
+			if isTimeout(err) {
+				return 0, errTimeout
+			}
+			return 0, fmt.Errorf("%w: %v", errConnectionClosed, err)
+		}
+		if suback, ok := p.(*mqtt_packet.Suback); ok {
+			if len(suback.ReasonCodes) == 0 {
+				return 0, fmt.Errorf("SUBACK without reason code")
+			}
+			return ReasonCode(suback.ReasonCodes[0]), nil
+		}
+	}
+}
+
+// rawPublish publishes the payload to the topic with QoS 1 and returns the reason code of the PUBACK packet,
+// always success in MQTT 3.1.1. Messages received before the PUBACK packet are discarded.
+func rawPublish(s *mqtt_packet.Session, topic string, payload []byte, retain bool) (ReasonCode, error) {
+	if err := s.Send(&mqtt_packet.Publish{QoS: 1, Retain: retain, PacketID: 1, Topic: topic, Payload: payload}); err != nil {
+		return 0, fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+	for {
+		p, err := s.Receive(connectTimeout)
+		if err != nil {
+			if isTimeout(err) {
+				return 0, errTimeout
+			}
+			return 0, fmt.Errorf("%w: %v", errConnectionClosed, err)
+		}
+		if ack, ok := p.(*mqtt_packet.Ack); ok && ack.PacketType == mqtt_packet.PUBACK {
+			return ReasonCode(ack.ReasonCode), nil
+		}
+	}
+}
+
+// awaitMessage waits for a message with the payload on the topic, it reports false if none arrives within the timeout.
+// A WebSocket session is unusable after a timeout.
+func awaitMessage(s *mqtt_packet.Session, topic string, payload []byte, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		p, err := s.Receive(time.Until(deadline))
+		if isTimeout(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if m, ok := p.(*mqtt_packet.Publish); ok && m.Topic == topic && bytes.Equal(m.Payload, payload) {
+			return true, nil
+		}
+	}
+}
+
+// describeResult describes the reason code or the error of an operation acknowledged by the packet
+func describeResult(packet string, code ReasonCode, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s reason code %s", packet, code)
+}
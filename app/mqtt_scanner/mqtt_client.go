@@ -1,246 +1,381 @@
 package mqtt_scanner
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"strings"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-
+	"mqtt-security-scanner/app/scanner"
 	"mqtt-security-scanner/config"
 )
 
-// NewMQTTClient returns a new mqtt client with the specified connection settings
-func NewMQTTClient(protocol, broker string, port int, clientID, username, password string) mqtt.Client {
-	// Form a connection address string with the given protocol, broker and port
-	connectAddress := fmt.Sprintf("%s://%s:%d", protocol, broker, port)
-
-	// Initialize a new mqtt client options
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(connectAddress)
-	opts.SetUsername(username)
-	opts.SetPassword(password)
-	opts.SetClientID(clientID)
-	opts.SetAutoReconnect(false)
-
-	// Create a new mqtt client
-	client := mqtt.NewClient(opts)
-	return client
+// authenticatedConnectID is the preflight scanner which the scanners connecting with the configured credentials depend on
+const authenticatedConnectID = "client.connect"
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          authenticatedConnectID,
+		Name:        "MQTT Client Connect",
+		Category:    scanner.CategoryClient,
+		Description: "Check if a client can connect with the configured username/password",
+		Severity:    config.SeverityInfo,
+		Remediation: "Verify the broker address, the MQTT listener and the configured username/password, the scanners which connect with these credentials are skipped until this check passes.",
+		References:  []string{"MQTT 3.1.1 section 3.1 CONNECT", "MQTT 5.0 section 3.2.2.2 Connect Reason Code"},
+		Phase:       scanner.PhasePreflight,
+		RunListener: MQTTClientConnect,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.authentication",
+		Name:        "Client Authentication",
+		Category:    scanner.CategoryClient,
+		Description: "Check the client connections with valid, no and wrong username/password",
+		Severity:    config.SeverityCritical,
+		Remediation: "Configure an authenticator in `authentication` and keep `listeners.<type>.<name>.enable_authn` set to `true` on every listener, so that clients without or with wrong credentials are rejected.",
+		References:  []string{"MQTT 3.1.1 section 5.4.1 Authentication of Clients by the Server", "MQTT 5.0 section 5.4.1 Authentication of Clients by the Server"},
+		RunListener: MQTTClientAuthentication,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.username-length",
+		Name:        "MQTT Client Username Length",
+		Category:    scanner.CategoryClient,
+		Description: "Check if a client with an excessive username length can connect",
+		Severity:    config.SeverityLow,
+		Remediation: "Reject usernames longer than your devices use in the authentication backend, e.g. with a length check in the HTTP or database authenticator, so that they are refused before the user lookup.",
+		References:  []string{"MQTT 3.1.1 section 3.1.3.4 User Name"},
+		Skip: func(cfg *config.Config) string {
+			if cfg.Limit.UsernameLen <= 0 {
+				return "limit.username_len is not set"
+			}
+			return ""
+		},
+		RunListener: MQTTClientUsernameLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.password-length",
+		Name:        "MQTT Client Password Length",
+		Category:    scanner.CategoryClient,
+		Description: "Check if a client with an excessive password length can connect",
+		Severity:    config.SeverityLow,
+		Remediation: "Reject passwords longer than the password hash of the authentication backend supports before hashing them, e.g. bcrypt ignores the bytes after the 72nd.",
+		References:  []string{"MQTT 3.1.1 section 3.1.3.5 Password"},
+		RunListener: MQTTClientPasswordLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.id-length",
+		Name:        "MQTT Client ID Length",
+		Category:    scanner.CategoryClient,
+		Description: "Check if a client with an excessive client ID length can connect",
+		Severity:    config.SeverityLow,
+		Remediation: "Set `mqtt.max_clientid_len` to the longest client ID your devices use.",
+		References:  []string{"MQTT 3.1.1 section 3.1.3.1 Client Identifier", "MQTT 5.0 section 3.2.2.2 Connect Reason Code"},
+		Requires:    []string{authenticatedConnectID},
+		RunListener: MQTTClientIDLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.flapping",
+		Name:        "MQTT Client Flapping",
+		Category:    scanner.CategoryClient,
+		Description: "Check if a client is added to a blacklist after frequent connect/disconnect cycles",
+		Severity:    config.SeverityMedium,
+		Remediation: "Enable `flapping_detect.enable` and tune `flapping_detect.max_count`, `flapping_detect.window_time` and `flapping_detect.ban_time`.",
+		References:  []string{"MQTT 3.1.1 section 5.4.7 Detecting abnormal behaviors"},
+		Phase:       scanner.PhaseDisruptive,
+		Requires:    []string{authenticatedConnectID},
+		Exclusive:   true, // A banned client must not interfere with other scanners
+		RunListener: MQTTClientFlapping,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "client.connection",
+		Name:        "MQTT Client Connection",
+		Category:    scanner.CategoryClient,
+		Description: "Check if the broker limits the number of concurrent connections",
+		Severity:    config.SeverityMedium,
+		Remediation: "Limit the concurrent connections with `listeners.<type>.<name>.max_connections` and the connection rate with `listeners.<type>.<name>.max_conn_rate`.",
+		References:  []string{"MQTT 3.1.1 section 5.4.7 Detecting abnormal behaviors"},
+		Phase:       scanner.PhaseDisruptive,
+		Requires:    []string{authenticatedConnectID},
+		Exclusive:   true, // The connection limit is exhausted while the scanner runs
+		RunListener: MQTTClientConnection,
+	}))
+}
+
+// MQTTClientConnect checks if a client can connect with the configured username and password
+func MQTTClientConnect(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Client Connect")
+
+	client := NewMQTTClient(l,
+		clientID(l, "connect"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+
+	err := client.Connect(ctx, connectTimeout)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		si.Message = append(si.Message, "MQTT client connection with authentication failed")
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+		return si, nil
+	}
+	client.Disconnect()
+
+	si.Outcome = config.OutcomePass
+	return si, nil
 }
 
 // MQTTClientAuthentication scans for client connection authentication
-func MQTTClientAuthentication(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTClientAuthentication(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("Client Authentication")
 
 	satisfied := true
 	// Check the client connection when username and password are set
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-with-authentication", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "with-authentication"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	if ok := verifyClientConnection(client); !ok {
+	ok, err := verifyClientConnection(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
 		satisfied = false
 		si.Message = append(si.Message, "MQTT client connection with authentication failed")
 	}
 
 	// Check the client connection when no username and password are set
-	client = NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-without-authentication", "", "")
+	client = NewMQTTClient(l,
+		clientID(l, "without-authentication"), "", "")
 
-	if ok := verifyClientConnection(client); ok {
+	ok, err = verifyClientConnection(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		satisfied = false
 		si.Message = append(si.Message, "MQTT client connection without authentication succeed")
 	}
 
 	// Check the client connection when wrong username and password are set
-	client = NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-with-wrong-authentication", "wrong_user", "wrong_pass")
+	client = NewMQTTClient(l,
+		clientID(l, "with-wrong-authentication"), "wrong_user", "wrong_pass")
 
-	if ok := verifyClientConnection(client); ok {
+	ok, err = verifyClientConnection(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		satisfied = false
 		si.Message = append(si.Message, "MQTT client connection with wrong authentication succeed")
 	}
 
-	si.Pass = satisfied
+	si.SetPass(satisfied)
 	return si, nil
 }
 
 // MQTTClientUsernameLength scans if a client with an excessive username length can connect
-func MQTTClientUsernameLength(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTClientUsernameLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Client Username Length")
 
-	// Create a client with an exceeded username length
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-exceeded-username", RandomString(cfg.Limit.PasswordLen+10), "password")
+	// Create a client with a username one byte longer than the limit
+	client := NewMQTTClient(l,
+		clientID(l, "exceeded-username"), RandomString(cfg.Limit.UsernameLen+1), "password")
 
-	token := client.Connect()
-	if !token.Wait() {
+	err := client.Connect(ctx, connectTimeout)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errTimeout) {
 		si.Message = append(si.Message, "MQTT client username length limit connection timeout")
 		return si, nil
 	}
 
-	if token.Error() == nil {
+	if err == nil {
+		client.Disconnect()
 		si.Message = append(si.Message, "MQTT client can still connect even if username len exceed")
 		return si, nil
 	}
 
-	if token.Error() != nil && !errors.Is(token.Error(), io.EOF) {
-		si.Message = append(si.Message, fmt.Sprintf("MQTT client username length limit does not work, error: %v", token.Error()))
+	// The CONNECT packet is refused as a whole, either by closing the connection or with a MQTT 5.0 reason code
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+	if !rejected(err, ReasonMalformedPacket, ReasonPacketTooLarge) {
+		si.Message = append(si.Message, fmt.Sprintf("MQTT client username length limit does not work, error: %v", err))
 		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTClientPasswordLength scans if a client with an excessive password length can connect
-func MQTTClientPasswordLength(cfg *config.Config) (*config.ScanItem, error) {
-	si := config.NewScanItem("MQTT Client password Length")
+func MQTTClientPasswordLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Client Password Length")
 
 	// Create a client with an exceeded password length
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-exceeded-password", "username", RandomString(cfg.Limit.PasswordLen+10))
+	client := NewMQTTClient(l,
+		clientID(l, "exceeded-password"), "username", RandomString(cfg.Limit.PasswordLen+10))
 
-	token := client.Connect()
-	if !token.Wait() {
+	err := client.Connect(ctx, connectTimeout)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errTimeout) {
 		si.Message = append(si.Message, "MQTT client password length limit connection timeout")
 		return si, nil
 	}
 
-	if token.Error() == nil {
+	if err == nil {
+		client.Disconnect()
 		si.Message = append(si.Message, "MQTT client can still connect even if password len exceed")
 		return si, nil
 	}
 
-	if token.Error() != nil && !errors.Is(token.Error(), io.EOF) {
-		si.Message = append(si.Message, fmt.Sprintf("MQTT client password length limit does not work, error: %v", token.Error()))
+	// The CONNECT packet is refused as a whole, either by closing the connection or with a MQTT 5.0 reason code
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+	if !rejected(err, ReasonMalformedPacket, ReasonPacketTooLarge) {
+		si.Message = append(si.Message, fmt.Sprintf("MQTT client password length limit does not work, error: %v", err))
 		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTClientIDLength scans if a client with an excessive ID length can connect
-func MQTTClientIDLength(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTClientIDLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Client ID Length")
 
 	// Create a client with an exceeded ID length
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
+	client := NewMQTTClient(l,
 		RandomString(cfg.Limit.ClientIDLen+1000), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	token := client.Connect()
-	if !token.WaitTimeout(3 * time.Second) {
+	err := client.Connect(ctx, 3*time.Second)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errTimeout) {
 		return nil, errors.New("MQTT client ID length limit connection timeout")
 	}
-	if token.Error() == nil {
+	if err == nil {
+		client.Disconnect()
 		si.Message = append(si.Message, "MQTT client can still connect even if ID len exceed")
 		return si, nil
 	}
-	if token.Error() != nil {
-		// For id len exceed, the error message is "identifier rejected"
-		if !strings.Contains(token.Error().Error(), "identifier rejected") {
-			si.Message = append(si.Message, "MQTT client ID length limit does not work")
-			return si, nil
-		}
+	// For id len exceed, the CONNACK reason code is 0x85 Client Identifier not valid (identifier rejected in MQTT 3.1.1)
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+	if code, ok := reasonCode(err); !ok || code != ReasonClientIDNotValid {
+		si.Message = append(si.Message, "MQTT client ID length limit does not work")
+		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTClientFlapping is used to check if a MQTT client is being added to a blacklist after flapping.
-func MQTTClientFlapping(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTClientFlapping(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Client Flapping")
 
 	// Initialize a MQTT client
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-flapping", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "flapping"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
 	// Repeatedly connect and disconnect limit+10 times
 	for i := 0; i < cfg.Limit.Flapping+10; i++ {
-		time.Sleep(10 * time.Millisecond)
-		if token := client.Connect(); token.WaitTimeout(3*time.Second) && token.Error() == nil {
-			client.Disconnect(0)
+		if err := sleep(ctx, 10*time.Millisecond); err != nil {
+			return nil, err
+		}
+		if err := client.Connect(ctx, 3*time.Second); err == nil {
+			client.Disconnect()
 		} else {
 			break
 		}
 	}
 
 	// Check if next connection attempt is blocked due to flapping
-	token := client.Connect()
-	if !token.WaitTimeout(3*time.Second) || token.Error() == nil {
+	err := client.Connect(ctx, 3*time.Second)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil || errors.Is(err, errTimeout) {
+		client.Disconnect()
 		si.Message = append(si.Message, "MQTT client connection flapping does not work")
 		return si, nil
 	}
-	// For flapping, the error message is "not Authorized"
-	if token.Error() != nil {
-		if !strings.Contains(token.Error().Error(), "not Authorized") {
-			si.Message = append(si.Message,
-				fmt.Sprintf("MQTT client connection flapping does not work, with error: %v", token.Error()))
-			return si, nil
-		}
+	// For flapping, the CONNACK reason code is 0x87 Not authorized, or 0x8A Banned in MQTT 5.0
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+	if code, ok := reasonCode(err); !ok || (code != ReasonNotAuthorized && code != ReasonBanned) {
+		si.Message = append(si.Message,
+			fmt.Sprintf("MQTT client connection flapping does not work, with error: %v", err))
+		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTClientConnection is used to test the maximum concurrent connections a MQTT broker can handle.
-func MQTTClientConnection(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTClientConnection(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Client Connection")
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	// Create the specified concurrent connections
+	// Create the specified concurrent connections, they are closed when the scanner returns
 	for i := 0; i < cfg.Limit.Connection+500; i++ {
-		time.Sleep(10 * time.Millisecond)
+		if err := sleep(ctx, 10*time.Millisecond); err != nil {
+			return nil, err
+		}
 		go func() {
-			client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
+			client := NewMQTTClient(l,
 				"mqtt-security-scanner-connection-"+RandomString(10),
 				cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-			client.Connect()
+			client.Connect(ctx, connectTimeout)
 			<-stopCh
+			client.Disconnect()
 		}()
 	}
 
-	time.Sleep(5 * time.Second)
+	if err := sleep(ctx, 5*time.Second); err != nil {
+		return nil, err
+	}
 
 	// Create one more connection to check if the connection limit is working
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-connection", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "connection"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	token := client.Connect()
-	if !token.WaitTimeout(3 * time.Second) {
+	err := client.Connect(ctx, 3*time.Second)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errTimeout) {
 		si.Message = append(si.Message, "MQTT client connection number scanner connect timeout")
 		return si, nil
 	}
 
-	if token.Error() == nil {
+	if err == nil {
+		client.Disconnect()
 		si.Message = append(si.Message, "MQTT client connection limit does not work, can still connect")
 		return si, nil
 	}
 
-	if token.Error() != nil && !strings.Contains(token.Error().Error(), "server Unavailable") {
+	// For connection limit, the CONNACK reason code is 0x88 Server unavailable, or one of the quota reason codes in MQTT 5.0
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Connect error: %v", err))
+	switch code, _ := reasonCode(err); code {
+	case ReasonServerUnavailable, ReasonServerBusy, ReasonQuotaExceeded, ReasonConnectionRateExceeded:
+	default:
 		si.Message = append(si.Message, "MQTT client connection limit does not work")
 		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // verifyClientConnection verifies if the MQTT client can establish a connection with the MQTT broker
-func verifyClientConnection(client mqtt.Client) bool {
-	token := client.Connect()
-	if !token.Wait() {
-		return false
-	}
-	if token.Error() != nil {
-		return false
+// An error is returned only if the context is done before the connection attempt completes
+func verifyClientConnection(ctx context.Context, client Client) (bool, error) {
+	if err := client.Connect(ctx, connectTimeout); err != nil {
+		return false, ctx.Err()
 	}
-	return true
+	client.Disconnect()
+	return true, nil
 }
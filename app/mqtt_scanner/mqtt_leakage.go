@@ -0,0 +1,194 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "message.wildcard-leakage",
+		Name:     "MQTT Cross-Tenant Leakage",
+		Category: scanner.CategoryMessage,
+		Description: "Check that an attacker identity subscribing with wildcard topic filters, shared subscriptions and mount point escapes " +
+			"does not receive the canary messages a victim identity publishes on its private topics",
+		Severity: config.SeverityCritical,
+		Remediation: "Restrict every tenant to its own topics in `authorization.sources` with placeholders such as `tenants/${username}/#`, " +
+			"deny subscribing to `#` with `{deny, all, subscribe, [{eq, \"#\"}]}` in the ACL file and set `authorization.no_match` to `deny`. " +
+			"Authorize shared subscriptions by the topic filter without the `$share/<group>/` prefix, and isolate the tenants with `mqtt.mountpoint` set to e.g. `tenants/${username}/`.",
+		References: []string{
+			"MQTT 5.0 section 4.7 Topic Names and Topic Filters",
+			"MQTT 5.0 section 4.8.2 Shared Subscriptions",
+			"MQTT 5.0 section 5.4.2 Authorization of Clients by the Server",
+		},
+		Skip: func(cfg *config.Config) string {
+			if !cfg.ACL.Leakage.Enabled() {
+				return "No leakage victim and attacker are configured"
+			}
+			return ""
+		},
+		RunListener: MQTTWildcardLeakage,
+	}))
+}
+
+// leakageSubscriber is an attacker session subscribed to a topic filter
+type leakageSubscriber struct {
+	filter  string
+	granted string // SUBACK reason code
+	session *mqtt_packet.Session
+}
+
+// MQTTWildcardLeakage subscribes the attacker to every leakage filter on a session of its own, then publishes a canary
+// to every topic of the victim and reports the canaries each filter received. The victim subscribes to its own topics
+// as a control, so that a canary the broker drops for everyone is not mistaken for a protected one.
+func MQTTWildcardLeakage(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Cross-Tenant Leakage")
+	lk := cfg.ACL.Leakage
+	victim, _ := cfg.ACL.Identity(lk.Victim)
+	attacker, _ := cfg.ACL.Identity(lk.Attacker)
+
+	control, err := identitySession(ctx, l, victim, "leakage-control")
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+	for _, t := range lk.Topics {
+		if code, err := rawSubscribe(control, t); err != nil || code >= ReasonUnspecifiedError {
+			return nil, fmt.Errorf("victim %s cannot subscribe to its topic %s: %s", victim.Name, t, describeResult("SUBACK", code, err))
+		}
+	}
+
+	var subscribers []leakageSubscriber
+	defer func() {
+		for _, s := range subscribers {
+			s.session.Close()
+		}
+	}()
+	for i, f := range leakageFilters(lk) {
+		s, err := identitySession(ctx, l, attacker, fmt.Sprintf("leakage-attacker-%d", i))
+		if err != nil {
+			return nil, err
+		}
+		code, err := rawSubscribe(s, f)
+		if ctx.Err() != nil {
+			s.Close()
+			return nil, ctx.Err()
+		}
+		if err != nil || code >= ReasonUnspecifiedError {
+			s.Close()
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Filter %s refused: %s", f, describeResult("SUBACK", code, err)))
+			continue
+		}
+		subscribers = append(subscribers, leakageSubscriber{filter: f, granted: describeResult("SUBACK", code, nil), session: s})
+	}
+
+	pub, err := identitySession(ctx, l, victim, "leakage-victim")
+	if err != nil {
+		return nil, err
+	}
+	defer pub.Close()
+	canaries := make(map[string]string, len(lk.Topics))
+	for _, t := range lk.Topics {
+		payload := "mqtt-security-scanner canary " + RandomString(16)
+		if code, err := rawPublish(pub, t, []byte(payload), false); err != nil || code >= ReasonUnspecifiedError {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("victim %s cannot publish to its topic %s: %s", victim.Name, t, describeResult("PUBACK", code, err))
+		}
+		canaries[payload] = t
+	}
+
+	// The sessions are read concurrently, a session read after the deadline would miss the canaries already buffered
+	sessions := []*mqtt_packet.Session{control}
+	for _, s := range subscribers {
+		sessions = append(sessions, s.session)
+	}
+	received := make([][]string, len(sessions))
+	deadline := time.Now().Add(deliveryTimeout)
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			received[i] = collectCanaries(s, canaries, deadline)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for _, t := range lk.Topics {
+		if !slices.Contains(received[0], t) {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Canary of %s was not delivered to the victim either, its leakage is not verified", t))
+		}
+	}
+	for i, s := range subscribers {
+		leaked := received[i+1]
+		if len(leaked) == 0 {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Filter %s granted (%s), no canary received", s.filter, s.granted))
+			continue
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Filter %s granted (%s), received the canaries of %s", s.filter, s.granted, strings.Join(leaked, ", ")))
+		for _, t := range leaked {
+			si.Message = append(si.Message, fmt.Sprintf("%s received the canary %s published to %s with the topic filter %s", attacker.Name, victim.Name, t, s.filter))
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// leakageFilters returns the topic filters the attacker subscribes to: wildcards matching every topic, shared subscriptions
+// of a new group, queue subscriptions, mount point escapes, the victim topics themselves and with a wildcard in place of
+// each of their levels, followed by the configured filters
+func leakageFilters(lk config.Leakage) []string {
+	share := "$share/mqtt-security-scanner-" + RandomString(8) + "/"
+	filters := []string{"#", "+/#", "+/+/#", share + "#", share + "+/#", "$queue/#", "/#", "//#", "../#", "../../#"}
+	for _, t := range lk.Topics {
+		levels := strings.Split(t, "/")
+		filters = append(filters, t, levels[0]+"/#", share+t, "$queue/"+t)
+		for i := range levels {
+			wildcard := slices.Clone(levels)
+			wildcard[i] = "+"
+			filters = append(filters, strings.Join(wildcard, "/"))
+		}
+	}
+	filters = append(filters, lk.Filters...)
+
+	var unique []string
+	for _, f := range filters {
+		if !slices.Contains(unique, f) {
+			unique = append(unique, f)
+		}
+	}
+	return unique
+}
+
+// collectCanaries reads the session until the deadline, and returns the topics of the canaries received in the order of arrival
+func collectCanaries(s *mqtt_packet.Session, canaries map[string]string, deadline time.Time) []string {
+	var topics []string
+	for len(topics) < len(canaries) {
+		p, err := s.Receive(time.Until(deadline))
+		if err != nil {
+			return topics
+		}
+		m, ok := p.(*mqtt_packet.Publish)
+		if !ok {
+			continue
+		}
+		if t, ok := canaries[string(m.Payload)]; ok && !slices.Contains(topics, t) {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
@@ -0,0 +1,285 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+// malformedCase is a malformed packet the broker must close the connection for
+type malformedCase struct {
+	id          string // Suffix of the scanner ID
+	name        string
+	description string
+	sent        string // Describes the packet in the scan item evidence and messages
+	severity    config.Severity
+	references  []string
+	connected   bool // Whether the packet is sent after an accepted CONNECT
+	// packet returns the wire format of the malformed packet for the listener, its protocol version and the configuration
+	packet func(l config.Listener, version byte, cfg *config.Config) []byte
+}
+
+// observeTimeout is how long the broker is given to close the connection after a malformed packet
+const observeTimeout = 5 * time.Second
+
+var malformedCases = []malformedCase{
+	{
+		id:          "reserved-flags",
+		name:        "Malformed Packet Reserved Flags",
+		description: "Check if the broker closes the connection on a packet with the reserved flag bits of the fixed header set",
+		sent:        "PINGREQ with the reserved flag bits set",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 2.2.2 Flags", "MQTT 5.0 section 2.1.3 Flags"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			flags := byte(0x0F)
+			return mqtt_packet.Encode(&mqtt_packet.Pingreq{Header: mqtt_packet.Header{HeaderFlags: &flags}}, version)
+		},
+	},
+	{
+		id:          "connect-reserved-flag",
+		name:        "Malformed Packet CONNECT Reserved Flag",
+		description: "Check if the broker refuses a CONNECT packet with the reserved connect flag set",
+		sent:        "CONNECT with the reserved connect flag set",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 3.1.2.3 Connect Flags", "MQTT 5.0 section 3.1.2.3 Connect Flags"},
+		packet: func(l config.Listener, version byte, cfg *config.Config) []byte {
+			connect := credentialsConnect(version, clientID(l, "malformed-connect-reserved-flag"), cfg)
+			flags := connect.Flags() | 0x01
+			connect.ConnectFlags = &flags
+			return mqtt_packet.Encode(connect, version)
+		},
+	},
+	{
+		id:          "remaining-length",
+		name:        "Malformed Packet Remaining Length",
+		description: "Check if the broker closes the connection on a remaining length encoded in more than 4 bytes",
+		sent:        "PINGREQ with a remaining length encoded in 5 bytes",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 2.2.3 Remaining Length", "MQTT 5.0 section 1.5.5 Variable Byte Integer"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			length := mqtt_packet.MaxRemainingLength + 1
+			return mqtt_packet.Encode(&mqtt_packet.Pingreq{Header: mqtt_packet.Header{RemainingLength: &length}}, version)
+		},
+	},
+	{
+		id:          "invalid-utf8",
+		name:        "Malformed Packet Invalid UTF-8 Topic",
+		description: "Check if the broker closes the connection on a PUBLISH topic name which is not valid UTF-8",
+		sent:        "PUBLISH with a topic name which is not valid UTF-8",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 1.5.3 UTF-8 encoded strings", "MQTT 5.0 section 1.5.4 UTF-8 Encoded String"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return malformedPublish(version, "mqtt-security-scanner/\xff\xfe", 1, 1)
+		},
+	},
+	{
+		id:          "null-character",
+		name:        "Malformed Packet Null Character Topic",
+		description: "Check if the broker closes the connection on a PUBLISH topic name containing U+0000",
+		sent:        "PUBLISH with a topic name containing U+0000",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 1.5.3 UTF-8 encoded strings", "MQTT 5.0 section 1.5.4 UTF-8 Encoded String"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return malformedPublish(version, "mqtt-security-scanner/\x00", 1, 1)
+		},
+	},
+	{
+		id:          "wildcard-topic",
+		name:        "Malformed Packet Wildcard Topic Name",
+		description: "Check if the broker closes the connection on a PUBLISH topic name containing wildcards",
+		sent:        "PUBLISH with the topic name mqtt-security-scanner/+/#",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 3.3.2.1 Topic Name", "MQTT 5.0 section 3.3.2.1 Topic Name"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return malformedPublish(version, "mqtt-security-scanner/+/#", 1, 1)
+		},
+	},
+	{
+		id:          "qos3",
+		name:        "Malformed Packet QoS 3",
+		description: "Check if the broker closes the connection on a PUBLISH packet with both QoS bits set",
+		sent:        "PUBLISH with QoS 3",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 3.3.1.2 QoS", "MQTT 5.0 section 3.3.1.2 QoS"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return malformedPublish(version, "mqtt-security-scanner/"+RandomString(8), 3, 1)
+		},
+	},
+	{
+		id:          "packet-id-zero",
+		name:        "Malformed Packet Identifier Zero",
+		description: "Check if the broker closes the connection on a SUBSCRIBE packet with the packet identifier 0",
+		sent:        "SUBSCRIBE with the packet identifier 0",
+		severity:    config.SeverityLow,
+		references:  []string{"MQTT 3.1.1 section 2.3.1 Packet Identifier", "MQTT 5.0 section 2.2.1 Packet Identifier"},
+		connected:   true,
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return mqtt_packet.Encode(&mqtt_packet.Subscribe{
+				PacketID:      0,
+				Subscriptions: []mqtt_packet.Subscription{{Topic: "mqtt-security-scanner/" + RandomString(8), Options: 1}},
+			}, version)
+		},
+	},
+	{
+		id:          "second-connect",
+		name:        "Malformed Packet Second CONNECT",
+		description: "Check if the broker closes the connection on a second CONNECT packet",
+		sent:        "a second CONNECT",
+		severity:    config.SeverityMedium,
+		references:  []string{"MQTT 3.1.1 section 3.1 CONNECT", "MQTT 5.0 section 3.1 CONNECT"},
+		connected:   true,
+		packet: func(l config.Listener, version byte, cfg *config.Config) []byte {
+			return mqtt_packet.Encode(credentialsConnect(version, clientID(l, "malformed-second-connect"), cfg), version)
+		},
+	},
+	{
+		id:          "first-packet",
+		name:        "Malformed Packet First Packet Not CONNECT",
+		description: "Check if the broker closes the connection when the first packet is not a CONNECT packet",
+		sent:        "PINGREQ as the first packet",
+		severity:    config.SeverityHigh,
+		references:  []string{"MQTT 3.1.1 section 3.1 CONNECT", "MQTT 5.0 section 3.1 CONNECT"},
+		packet: func(_ config.Listener, version byte, _ *config.Config) []byte {
+			return mqtt_packet.Encode(&mqtt_packet.Pingreq{}, version)
+		},
+	},
+}
+
+func init() {
+	for _, c := range malformedCases {
+		spec := scanner.Spec{
+			ID:          "protocol.malformed." + c.id,
+			Name:        c.name,
+			Category:    scanner.CategoryProtocol,
+			Description: c.description,
+			Severity:    c.severity,
+			Remediation: "Enable `mqtt.strict_mode` so that the broker validates every packet, and upgrade the broker if it still accepts the packet.",
+			References:  append(c.references, "MQTT 5.0 section 4.13 Handling errors"),
+			RunListener: malformedScanner(c),
+		}
+		if c.connected {
+			spec.Requires = []string{authenticatedConnectID}
+		}
+		scanner.Register(scanner.New(spec))
+	}
+}
+
+// malformedScanner returns the scanner function which sends the malformed packet of the case
+// and checks that the broker closes the connection
+func malformedScanner(c malformedCase) scanner.ListenerFunc {
+	return func(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+		si := config.NewScanItem(c.name)
+
+		session, err := mqtt_packet.DialSession(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		defer session.Close()
+
+		if c.connected {
+			connect := credentialsConnect(session.Version, clientID(l, "malformed-"+c.id), cfg)
+			connack, err := session.Connect(connect, connectTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("connect before sending the malformed packet: %w", err)
+			}
+			if connack.ReasonCode != 0 {
+				return nil, fmt.Errorf("connect before sending the malformed packet refused with reason code %s", connackReason(session.Version, connack.ReasonCode))
+			}
+		}
+
+		packet := c.packet(l, session.Version, cfg)
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Sent %s: %s", c.sent, hexdump(packet)))
+		if err := session.SendBytes(packet); err != nil {
+			return nil, err
+		}
+
+		closed, observed, err := observeClose(ctx, session)
+		if err != nil {
+			return nil, err
+		}
+		si.Evidence = append(si.Evidence, observed...)
+		if !closed {
+			si.Message = append(si.Message, fmt.Sprintf("Broker did not close the connection after %s", c.sent))
+		}
+
+		si.SetPass(closed)
+		return si, nil
+	}
+}
+
+// observeClose reads the packets the broker sends until it closes the connection or observeTimeout expires.
+// It reports whether the connection was closed and describes every packet received.
+func observeClose(ctx context.Context, session *mqtt_packet.Session) (bool, []string, error) {
+	var observed []string
+	deadline := time.Now().Add(observeTimeout)
+	for {
+		p, err := session.Receive(time.Until(deadline))
+		if ctx.Err() != nil {
+			return false, nil, ctx.Err()
+		}
+
+		switch {
+		case isTimeout(err):
+			observed = append(observed, fmt.Sprintf("Connection still open after %v", observeTimeout))
+			return false, observed, nil
+		case errors.Is(err, mqtt_packet.ErrMalformed):
+			observed = append(observed, fmt.Sprintf("Broker sent a packet which cannot be decoded: %v", err))
+		case err != nil:
+			observed = append(observed, fmt.Sprintf("Broker closed the connection: %v", err))
+			return true, observed, nil
+		default:
+			observed = append(observed, describePacket(p, session.Version))
+		}
+	}
+}
+
+// describePacket describes a packet received from the broker, including its reason code
+func describePacket(p mqtt_packet.Packet, version byte) string {
+	return mqtt_packet.Describe(p, version)
+}
+
+// credentialsConnect returns a CONNECT packet with the configured username and password
+func credentialsConnect(version byte, clientID string, cfg *config.Config) *mqtt_packet.Connect {
+	connect := mqtt_packet.NewConnect(version, clientID)
+	if cfg.BrokerInfo.Username != "" {
+		connect.UsernameFlag, connect.Username = true, cfg.BrokerInfo.Username
+	}
+	if cfg.BrokerInfo.Password != "" {
+		connect.PasswordFlag, connect.Password = true, []byte(cfg.BrokerInfo.Password)
+	}
+	return connect
+}
+
+// malformedPublish returns a PUBLISH packet with the given topic name, QoS and packet identifier
+func malformedPublish(version byte, topic string, qos byte, packetID uint16) []byte {
+	return mqtt_packet.Encode(&mqtt_packet.Publish{
+		QoS:      qos,
+		Topic:    topic,
+		PacketID: packetID,
+		Payload:  []byte("mqtt-security-scanner"),
+	}, version)
+}
+
+// hexdump returns the hex encoding of the first 64 bytes of a packet
+func hexdump(b []byte) string {
+	if len(b) > 64 {
+		return fmt.Sprintf("% x ... (%d bytes)", b[:64], len(b))
+	}
+	return fmt.Sprintf("% x", b)
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	return mqtt_packet.IsTimeout(err)
+}
@@ -1,139 +1,225 @@
 package mqtt_scanner
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-
+	"mqtt-security-scanner/app/scanner"
 	"mqtt-security-scanner/config"
 )
 
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.deny-topic",
+		Name:        "MQTT Message Deny Topic",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker denies subscriptions to the configured deny topics",
+		Severity:    config.SeverityHigh,
+		Remediation: "Add deny rules for the topics to the authorization sources in `authorization.sources` and set `authorization.no_match` to `deny`.",
+		References:  []string{"MQTT 3.1.1 section 5.4.2 Authorization of Clients by the Server", "MQTT 5.0 section 3.9.3 SUBACK Payload"},
+		Requires:    []string{authenticatedConnectID},
+		RunListener: MQTTMessageDenyTopic,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.topic-level",
+		Name:        "MQTT Topic Level",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker accepts a topic with more levels than the limit",
+		Severity:    config.SeverityLow,
+		Remediation: "Set `mqtt.max_topic_levels` to the deepest topic hierarchy your applications use.",
+		References:  []string{"MQTT 3.1.1 section 4.7 Topic Names and Topic Filters", "MQTT 5.0 section 3.4.2.1 PUBACK Reason Code"},
+		Requires:    []string{authenticatedConnectID},
+		RunListener: MQTTTopicLevel,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.topic-length",
+		Name:        "MQTT Topic Length",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker accepts a topic longer than the limit",
+		Severity:    config.SeverityLow,
+		Remediation: "Limit `mqtt.max_packet_size` and deny unexpected topics in the authorization rules.",
+		References:  []string{"MQTT 3.1.1 section 4.7.3 Topic semantic and usage", "MQTT 5.0 section 3.9.3 SUBACK Payload"},
+		Requires:    []string{authenticatedConnectID},
+		RunListener: MQTTTopicLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.payload-length",
+		Name:        "MQTT Message Payload Length",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker accepts a message payload larger than the limit",
+		Severity:    config.SeverityMedium,
+		Remediation: "Set `mqtt.max_packet_size` to the largest message your applications send.",
+		References:  []string{"MQTT 3.1.1 section 3.3.3 Payload", "MQTT 5.0 section 3.1.2.11.4 Maximum Packet Size"},
+		Requires:    []string{authenticatedConnectID},
+		RunListener: MQTTMessagePayloadLength,
+	}))
+}
+
 // MQTTMessageDenyTopic checks if the MQTT broker denies messages to certain topics.
-func MQTTMessageDenyTopic(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTMessageDenyTopic(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Message Deny Topic")
 
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-deny-topic", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "deny-topic"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
+	if err := client.Connect(ctx, connectTimeout); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		si.Message = append(si.Message, "MQTT message deny topic connect failed")
 		return si, nil
 	}
+	defer client.Disconnect()
 
 	satisfied := true
 	for _, topic := range cfg.BrokerInfo.DenyTopics {
-		if err := subscribe(client, topic); err == nil {
+		err := subscribe(ctx, client, topic)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil {
 			satisfied = false
 			si.Message = append(si.Message, fmt.Sprintf("MQTT deny topic %s does not work", topic))
+			continue
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Subscribe %s error: %v", topic, err))
+		// A broker closing the connection to refuse the subscription requires a new connection for the next topic
+		if rejected(err) {
+			client.Disconnect()
+			if err := client.Connect(ctx, connectTimeout); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				si.Message = append(si.Message, "MQTT message deny topic reconnect failed")
+				satisfied = false
+				break
+			}
 		}
 	}
 
-	si.Pass = satisfied
+	si.SetPass(satisfied)
 	return si, nil
 }
 
 // MQTTTopicLevel checks if the MQTT broker supports a topic with more levels than the limit.
-func MQTTTopicLevel(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTTopicLevel(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Topic Level")
 
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-topic-level", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "topic-level"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
+	if err := client.Connect(ctx, connectTimeout); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		si.Message = append(si.Message, "MQTT topic level connect failed")
 		return si, nil
 	}
+	defer client.Disconnect()
 
-	err := publish(client, GenerateRandomTopic(cfg.Limit.TopicLevel+5), "MQTT Topic Level")
+	err := publish(ctx, client, GenerateRandomTopic(cfg.Limit.TopicLevel+5), "MQTT Topic Level")
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err == nil {
 		si.Message = append(si.Message, "MQTT topic level limit do not work")
 		return si, nil
 	}
 
-	// For topic level exceeded, error is "connection lost before Publish completed"
-	if !strings.Contains(err.Error(), "connection lost before Publish completed") {
+	// For topic level exceeded, the connection is closed, or the reason code is 0x90 Topic Name invalid in MQTT 5.0
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Publish error: %v", err))
+	if !rejected(err, ReasonTopicNameInvalid, ReasonMalformedPacket, ReasonProtocolError) {
 		si.Message = append(si.Message, fmt.Sprintf("MQTT topic level limit do not work, with error %v", err))
 		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTTopicLength checks if the MQTT broker supports a topic length larger than the limit.
-func MQTTTopicLength(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTTopicLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Topic Length")
 
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-topic-length", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "topic-length"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
+	if err := client.Connect(ctx, connectTimeout); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		si.Message = append(si.Message, "MQTT topic length connect failed")
 		return si, nil
 	}
+	defer client.Disconnect()
 
-	err := subscribe(client, GenerateRandomTopic(cfg.Limit.TopicLen+10))
+	err := subscribe(ctx, client, GenerateRandomTopic(cfg.Limit.TopicLen+10))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err == nil {
 		si.Message = append(si.Message, "MQTT topic length limit do not work")
 		return si, nil
 	}
-	// For topic len exceeding, error is "connection lost before Subscribe completed"
-	if !strings.Contains(err.Error(), "connection lost before Subscribe completed") {
+	// For topic len exceeding, the connection is closed, or the reason code is 0x8F Topic Filter invalid in MQTT 5.0
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Subscribe error: %v", err))
+	if !rejected(err, ReasonTopicFilterInvalid, ReasonMalformedPacket, ReasonProtocolError, ReasonPacketTooLarge) {
 		si.Message = append(si.Message, fmt.Sprintf("MQTT topic length limit do not work, with error %v", err))
 		return si, nil
 	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // MQTTMessagePayloadLength checks if the MQTT broker supports a message payload length larger than the limit.
-func MQTTMessagePayloadLength(cfg *config.Config) (*config.ScanItem, error) {
+func MQTTMessagePayloadLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
 	si := config.NewScanItem("MQTT Message Payload Length")
 
-	client := NewMQTTClient("tcp", cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort,
-		"mqtt-security-scanner-message-payload-length", cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
+	client := NewMQTTClient(l,
+		clientID(l, "message-payload-length"), cfg.BrokerInfo.Username, cfg.BrokerInfo.Password)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
+	if err := client.Connect(ctx, connectTimeout); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		si.Message = append(si.Message, "MQTT message payload length connect failed")
 		return si, nil
 	}
+	defer client.Disconnect()
 
-	if err := publish(client, "payload-len-scanner", GenerateRandomTopic(1024*1200*cfg.Limit.PayloadLen)); err == nil {
+	err := publish(ctx, client, "payload-len-scanner", GenerateRandomTopic(1024*1200*cfg.Limit.PayloadLen))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil {
 		si.Message = append(si.Message, "MQTT message payload length limit do not work")
 		return si, nil
 	}
+	// For payload len exceeding, the connection is closed, or the reason code is 0x95 Packet too large in MQTT 5.0
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Publish error: %v", err))
+	if !rejected(err, ReasonPacketTooLarge, ReasonMalformedPacket) {
+		si.Message = append(si.Message, fmt.Sprintf("MQTT message payload length limit do not work, with error %v", err))
+		return si, nil
+	}
 
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // subscribe tries to subscribe the MQTT client to a given topic
-func subscribe(client mqtt.Client, topic string) error {
-	token := client.Subscribe(topic, 1, nil)
-	if !token.WaitTimeout(10 * time.Second) {
+func subscribe(ctx context.Context, client Client, topic string) error {
+	err := client.Subscribe(ctx, topic, 10*time.Second)
+	if errors.Is(err, errTimeout) {
 		return errors.New("Broker close connection")
 	}
-
-	if token.Error() != nil {
-		return token.Error()
-	}
-	return nil
+	return err
 }
 
 // publish tries to publish a message with a given payload to a given topic
-func publish(client mqtt.Client, topic, payload string) error {
-	token := client.Publish(topic, 1, false, payload)
-	// When the topic length is too long, it may take a long time to return
-	if !token.Wait() {
-		return errors.New("Broker close connection")
-	}
-
-	if token.Error() != nil {
-		return token.Error()
-	}
-	return nil
+func publish(ctx context.Context, client Client, topic, payload string) error {
+	// When the topic length is too long, it may take a long time to return, so the wait is bounded by the context only
+	return client.Publish(ctx, topic, payload, 0)
 }
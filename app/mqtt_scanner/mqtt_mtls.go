@@ -0,0 +1,250 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "tls.mutual-auth",
+		Name:     "Mutual TLS",
+		Category: scanner.CategoryTLS,
+		Description: "Check that the SSL and WSS listeners accept the configured client certificate and refuse connections without a client certificate, " +
+			"with a self-signed, expired or untrusted one, and that the certificate is used as the client identity",
+		Severity: config.SeverityHigh,
+		Remediation: "Set `listeners.ssl.<name>.ssl_options.verify` to `verify_peer` and `fail_if_no_peer_cert` to `true` with `cacertfile` containing only the CA issuing the client certificates, " +
+			"and the same keys of `listeners.wss.<name>`. Set `mqtt.peer_cert_as_username` or `mqtt.peer_cert_as_clientid` to `cn` so that the certificate is the client identity.",
+		References: []string{
+			"RFC 8446 section 4.4.2.4 Receiving a Certificate Message https://www.rfc-editor.org/rfc/rfc8446#section-4.4.2.4",
+			"MQTT 5.0 section 5.4.9 TLS",
+			"NIST SP 800-52 Rev. 2",
+		},
+		Skip: func(cfg *config.Config) string {
+			if !cfg.BrokerInfo.TLS {
+				return "TLS is disabled in the configuration"
+			}
+			if cfg.BrokerInfo.ClientCertificate() == nil {
+				return "No client certificate is configured"
+			}
+			return ""
+		},
+		Listeners:   config.Listener.TLS,
+		RunListener: MutualTLSScanner,
+	}))
+}
+
+// mtlsAttempt is a connection presenting a client certificate the broker should refuse
+type mtlsAttempt struct {
+	name        string
+	certificate *tls.Certificate // nil for no client certificate
+}
+
+// MutualTLSScanner connects to the TLS listener with the configured client certificate and with certificates the broker
+// must refuse. An expired certificate and a certificate of another identity are only tried when the client CA is configured,
+// since the broker refuses any certificate of an unknown CA for that reason alone.
+func MutualTLSScanner(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("Mutual TLS")
+	valid := l.Certificate
+	subject := valid.Leaf.Subject
+
+	// The broker must accept the valid certificate, otherwise a refusal of the others proves nothing
+	accepted, observed, err := mtlsConnect(ctx, cfg, l, valid)
+	if err != nil {
+		return nil, err
+	}
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Configured client certificate %q: %s", subject, observed))
+	if !accepted {
+		si.Message = append(si.Message, fmt.Sprintf("Broker refused the configured client certificate %q: %s", subject, observed))
+		si.SetPass(false)
+		return si, nil
+	}
+
+	selfSigned, err := issueClientCertificate(subject, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	untrustedCA, untrustedKey, err := newCA("mqtt-security-scanner untrusted CA")
+	if err != nil {
+		return nil, err
+	}
+	untrusted, err := issueClientCertificate(subject, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), untrustedCA, untrustedKey)
+	if err != nil {
+		return nil, err
+	}
+	attempts := []mtlsAttempt{
+		{name: "without a client certificate"},
+		{name: "with a self-signed client certificate", certificate: selfSigned},
+		{name: "with a client certificate of an untrusted CA", certificate: untrusted},
+	}
+
+	ca, caKey, err := clientCA(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var other *tls.Certificate
+	if ca != nil {
+		expired, err := issueClientCertificate(subject, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour), ca, caKey)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, mtlsAttempt{name: "with an expired client certificate of the client CA", certificate: expired})
+
+		other, err = issueClientCertificate(pkix.Name{CommonName: "mqtt-security-scanner-" + RandomString(8)}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), ca, caKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		si.Evidence = append(si.Evidence, "Expired certificate and identity checks skipped, broker.client_ca_cert and broker.client_ca_key are not configured")
+	}
+
+	for _, a := range attempts {
+		accepted, observed, err := mtlsConnect(ctx, cfg, l, a.certificate)
+		if err != nil {
+			return nil, err
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Connection %s: %s", a.name, observed))
+		if accepted {
+			si.Message = append(si.Message, fmt.Sprintf("Broker accepted a connection %s", a.name))
+		}
+	}
+
+	// A certificate of another identity with the configured username and password is refused if the broker takes the
+	// identity from the certificate, either by overriding the username with it or by checking that they match
+	if other != nil {
+		name := other.Leaf.Subject.CommonName
+		accepted, observed, err := mtlsConnect(ctx, cfg, l, other)
+		if err != nil {
+			return nil, err
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Connection with a client certificate issued to %s and the username %q: %s", name, cfg.BrokerInfo.Username, observed))
+		if accepted {
+			si.Message = append(si.Message, fmt.Sprintf("Broker accepted the username %q with a client certificate issued to %s, the certificate is not the client identity",
+				cfg.BrokerInfo.Username, name))
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// mtlsConnect connects to the listener presenting the client certificate and sends a CONNECT with the configured credentials.
+// It reports whether the broker accepted the connection, and describes what the broker did.
+func mtlsConnect(ctx context.Context, cfg *config.Config, l config.Listener, cert *tls.Certificate) (bool, string, error) {
+	l.Certificate = cert
+	session, err := mqtt_packet.DialSession(ctx, l)
+	if ctx.Err() != nil {
+		return false, "", ctx.Err()
+	}
+	if err != nil {
+		// TLS 1.2 brokers refuse the certificate during the handshake
+		return false, fmt.Sprintf("Handshake refused: %v", err), nil
+	}
+	defer session.Close()
+
+	connack, err := session.Connect(credentialsConnect(session.Version, clientID(l, "mtls"), cfg), connectTimeout)
+	switch {
+	case ctx.Err() != nil:
+		return false, "", ctx.Err()
+	case err != nil:
+		// TLS 1.3 brokers refuse the certificate after the client finished the handshake
+		return false, fmt.Sprintf("Connection closed: %v", err), nil
+	case connack.ReasonCode != 0:
+		return false, fmt.Sprintf("CONNECT refused with reason code %s", connackReason(session.Version, connack.ReasonCode)), nil
+	}
+	session.Send(&mqtt_packet.Disconnect{})
+	return true, "CONNECT accepted", nil
+}
+
+// clientCA loads the configured CA which issues the client certificates, nil if it is not configured
+func clientCA(cfg *config.Config) (*x509.Certificate, crypto.Signer, error) {
+	b := cfg.BrokerInfo
+	if b.ClientCACert == "" && b.ClientCAKey == "" {
+		return nil, nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(b.ClientCACert, b.ClientCAKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load broker.client_ca_cert and broker.client_ca_key: %w", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse broker.client_ca_cert: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("broker.client_ca_key cannot sign certificates")
+	}
+	return ca, key, nil
+}
+
+// newCA returns a new self-signed CA certificate and its key
+func newCA(name string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// issueClientCertificate issues a client certificate for the subject with a new key, self-signed if the CA is nil.
+// The chain of the returned certificate includes the CA.
+func issueClientCertificate(subject pkix.Name, notBefore, notAfter time.Time, ca *x509.Certificate, caKey crypto.Signer) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, crypto.Signer(key)
+	if ca != nil {
+		parent, signer = ca, caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	if ca != nil {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
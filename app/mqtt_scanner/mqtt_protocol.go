@@ -1,26 +1,82 @@
 package mqtt_scanner
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/app/tls_probe"
 	"mqtt-security-scanner/config"
 )
 
 // TLSMap Mapping between string representations and TLS versions
-var TLSMap = map[string]uint16{"SSL3.0": 768, "TLS1.0": 769, "TLS1.1": 770, "TLS1.2": 771, "TLS1.3": 772}
+var TLSMap = map[string]uint16{
+	"SSL3.0": tls_probe.VersionSSL30,
+	"TLS1.0": tls_probe.VersionTLS10,
+	"TLS1.1": tls_probe.VersionTLS11,
+	"TLS1.2": tls_probe.VersionTLS12,
+	"TLS1.3": tls_probe.VersionTLS13,
+}
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "protocol.invalid-mqtt",
+		Name:        "Invalid MQTT Message",
+		Category:    scanner.CategoryProtocol,
+		Description: "Check if the broker accepts invalid MQTT protocol connections",
+		Severity:    config.SeverityLow,
+		Remediation: "Make sure the MQTT port is served by the broker's MQTT listener only and not by a proxy which accepts arbitrary TCP traffic.",
+		References:  []string{"MQTT 3.1.1 section 4.8 Handling errors"},
+		Run:         InvalidMQTTProtocolScanner,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "protocol.invalid-ws",
+		Name:        "Invalid Websocket Protocol",
+		Category:    scanner.CategoryProtocol,
+		Description: "Check if the WS and WSS listeners accept WebSocket handshakes without the mqtt subprotocol, from a foreign Origin or on another path, and oversized, text or unmasked frames",
+		Severity:    config.SeverityLow,
+		Remediation: "Set `listeners.ws.<name>.websocket.fail_if_no_subprotocol` to `true`, restrict `listeners.ws.<name>.websocket.supported_subprotocols` to `mqtt`, " +
+			"enable `listeners.ws.<name>.websocket.check_origin_enable` with the allowed `check_origins`, and limit `listeners.ws.<name>.websocket.max_frame_size`. The same keys apply to `listeners.wss.<name>`.",
+		References: []string{
+			"MQTT 3.1.1 section 6 Using WebSocket as a network transport",
+			"MQTT 5.0 section 6 Using WebSocket as a network transport",
+			"RFC 6455 section 5.1 Overview, masking of client frames https://www.rfc-editor.org/rfc/rfc6455#section-5.1",
+			"RFC 6455 section 10.2 Origin Considerations https://www.rfc-editor.org/rfc/rfc6455#section-10.2",
+		},
+		ListenerSource: config.BrokerInfo.WebSocketListeners,
+		RunListener:    WebSocketScanner,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "tls.version",
+		Name:        "TLS Version",
+		Category:    scanner.CategoryTLS,
+		Description: "Check the supported and unsupported TLS versions of the MQTTS listener",
+		Severity:    config.SeverityHigh,
+		Remediation: "Set `listeners.ssl.<name>.ssl_options.versions` and `listeners.wss.<name>.ssl_options.versions` to the supported TLS versions only, e.g. `[\"tlsv1.3\", \"tlsv1.2\"]`.",
+		References:  []string{"RFC 8996 Deprecating TLS 1.0 and TLS 1.1 https://www.rfc-editor.org/rfc/rfc8996", "NIST SP 800-52 Rev. 2"},
+		Skip: func(cfg *config.Config) string {
+			if !cfg.BrokerInfo.TLS {
+				return "TLS is disabled in the configuration"
+			}
+			return ""
+		},
+		Run: TLSVersionsScanner,
+	}))
+}
 
 // InvalidMQTTProtocolScanner scans the broker to check if it supports invalid MQTT message format connections
-func InvalidMQTTProtocolScanner(cfg *config.Config) (*config.ScanItem, error) {
+func InvalidMQTTProtocolScanner(ctx context.Context, cfg *config.Config) (*config.ScanItem, error) {
 	si := config.NewScanItem("Invalid MQTT Message")
 
 	// Check if MQTT port accepts non-MQTT messages
-	ok, err := checkDenyNonMQTTConnection(cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort)
+	ok, err := checkDenyNonMQTTConnection(ctx, cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTPort)
 	if err != nil {
 		return nil, err
 	}
@@ -30,31 +86,12 @@ func InvalidMQTTProtocolScanner(cfg *config.Config) (*config.ScanItem, error) {
 		return si, nil
 	}
 
-	si.Pass = true
-	return si, nil
-}
-
-// InvalidWSProtocolScanner scans the broker to check if it supports invalid WebSocket message format connections
-func InvalidWSProtocolScanner(cfg *config.Config) (*config.ScanItem, error) {
-	si := config.NewScanItem("Invalid Websocket Protocol")
-
-	// Check if MQTT over websocket port accepts non-MQTT messages
-	ok, err := checkDenyNonMQTTConnection(cfg.BrokerInfo.Host, cfg.BrokerInfo.WSPort)
-	if err != nil {
-		return nil, err
-	}
-
-	if !ok {
-		si.Message = append(si.Message, "Invalid websocket protocol connect successfully")
-		return si, nil
-	}
-
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	return si, nil
 }
 
 // TLSVersionsScanner scans the broker for supported TLS protocol versions
-func TLSVersionsScanner(cfg *config.Config) (*config.ScanItem, error) {
+func TLSVersionsScanner(ctx context.Context, cfg *config.Config) (*config.ScanItem, error) {
 	si := config.NewScanItem("TLS Version")
 
 	satisfied := true
@@ -64,11 +101,12 @@ func TLSVersionsScanner(cfg *config.Config) (*config.ScanItem, error) {
 		if err != nil {
 			return nil, err
 		}
-		ok, err := checkTLSVersion(cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTSPort, tlsVersion)
+		ok, err := checkTLSVersion(ctx, cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTSPort, cfg.BrokerInfo.ClientCertificate(), tlsVersion)
 		if err != nil {
 			return nil, err
 		}
 
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s handshake accepted: %t", version, ok))
 		if !ok {
 			satisfied = false
 			si.Message = append(si.Message, fmt.Sprintf("TLS version %s is not supported", version))
@@ -81,25 +119,26 @@ func TLSVersionsScanner(cfg *config.Config) (*config.ScanItem, error) {
 		if err != nil {
 			return nil, err
 		}
-		ok, err := checkTLSVersion(cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTSPort, tlsVersion)
+		ok, err := checkTLSVersion(ctx, cfg.BrokerInfo.Host, cfg.BrokerInfo.MQTTSPort, cfg.BrokerInfo.ClientCertificate(), tlsVersion)
 		if err != nil {
 			return nil, err
 		}
 
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s handshake accepted: %t", version, ok))
 		if ok {
 			satisfied = false
 			si.Message = append(si.Message, fmt.Sprintf("Unsafe TLS version %s is support", version))
 		}
 	}
 
-	si.Pass = satisfied
+	si.SetPass(satisfied)
 	return si, nil
 }
 
 // checkDenyNonMQTTConnection checks if the specified TCP port denies non-MQTT protocol connections
 // Under normal circumstances, the behavior of the EMQX broker's denial is to reply with a fin packet, corresponding to EOF
-func checkDenyNonMQTTConnection(host string, port int) (bool, error) {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), 3*time.Second)
+func checkDenyNonMQTTConnection(ctx context.Context, host string, port int) (bool, error) {
+	conn, err := dial(ctx, host, port)
 	if err != nil {
 		return false, err
 	}
@@ -121,12 +160,18 @@ func checkDenyNonMQTTConnection(host string, port int) (bool, error) {
 	return false, nil
 }
 
-// checkTLSVersion checks the supported TLS protocol versions
+// checkTLSVersion checks the supported TLS protocol versions, presenting the client certificate if not nil
 // True should be returned for a given version that is supported
 // False should be returned for a given version that is unsupported
 // An error is returned if an unknown error occurs in the connection
-func checkTLSVersion(host string, port int, tlsVersion uint16) (bool, error) {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), 3*time.Second)
+func checkTLSVersion(ctx context.Context, host string, port int, cert *tls.Certificate, tlsVersion uint16) (bool, error) {
+	// crypto/tls cannot negotiate SSL 3.0 and refuses most of the cipher suites of TLS 1.0 and 1.1,
+	// so these versions are detected with a raw ClientHello independently of it
+	if tlsVersion < tls.VersionTLS12 {
+		return tls_probe.Supports(ctx, net.JoinHostPort(host, strconv.Itoa(port)), host, tlsVersion)
+	}
+
+	conn, err := dial(ctx, host, port)
 	if err != nil {
 		return false, err
 	}
@@ -137,12 +182,17 @@ func checkTLSVersion(host string, port int, tlsVersion uint16) (bool, error) {
 		MinVersion:         tlsVersion,
 		MaxVersion:         tlsVersion,
 	}
+	if cert != nil {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
 
 	tlsClient := tls.Client(conn, c)
 	defer tlsClient.Close()
 
 	// Unsupported TLS versions may output the following two error messages
-	if err := tlsClient.Handshake(); err != nil {
+	if err := tlsClient.HandshakeContext(ctx); err != nil {
 		if strings.Contains(err.Error(), "no supported versions satisfy MinVersion and MaxVersion") ||
 			strings.Contains(err.Error(), "protocol version not supported") {
 			return false, nil
@@ -153,6 +203,30 @@ func checkTLSVersion(host string, port int, tlsVersion uint16) (bool, error) {
 	return true, nil
 }
 
+// dial opens a TCP connection to the given address, the connection is closed when the context is done
+// so that blocking reads and writes are interrupted
+func dial(ctx context.Context, host string, port int) (net.Conn, error) {
+	d := net.Dialer{Timeout: 3 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+// ctxConn is a net.Conn bound to a context by dial
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
 // convertStringToUint16 converts the string representation of TLS protocol version to uint16
 func convertStringToUint16(version string) (uint16, error) {
 	tlsVersion, ok := TLSMap[strings.ToUpper(version)]
@@ -0,0 +1,354 @@
+package mqtt_scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	retainedSkip := func(cfg *config.Config) string {
+		if !cfg.ACL.Retained.Enabled() {
+			return "No retained owner and intruder are configured"
+		}
+		return ""
+	}
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.retained-read",
+		Name:        "MQTT Retained Read",
+		Category:    scanner.CategoryMessage,
+		Description: "Check that an intruder identity cannot read the retained messages an owner identity published on its restricted topics",
+		Severity:    config.SeverityHigh,
+		Remediation: "Deny subscribing to the restricted topics for other clients in `authorization.sources` and set `authorization.no_match` to `deny`, " +
+			"the broker delivers the retained messages to every subscription it grants on a matching topic filter.",
+		References:  []string{"MQTT 5.0 section 3.3.1.3 RETAIN", "MQTT 5.0 section 5.4.2 Authorization of Clients by the Server"},
+		Exclusive:   true, // The instances publish to the same owner topics
+		Skip:        retainedSkip,
+		RunListener: MQTTRetainedRead,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.retained-overwrite",
+		Name:        "MQTT Retained Overwrite",
+		Category:    scanner.CategoryMessage,
+		Description: "Check that an intruder identity cannot overwrite or delete the retained messages an owner identity published on its restricted topics",
+		Severity:    config.SeverityHigh,
+		Remediation: "Deny publishing to the restricted topics for other clients in `authorization.sources` and set `authorization.no_match` to `deny`, " +
+			"a publish with an empty retained payload deletes the retained message and needs the same permission.",
+		References:  []string{"MQTT 5.0 section 3.3.1.3 RETAIN", "MQTT 5.0 section 5.4.2 Authorization of Clients by the Server"},
+		Exclusive:   true, // The instances publish to the same owner topics
+		Skip:        retainedSkip,
+		RunListener: MQTTRetainedOverwrite,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.retained-payload-length",
+		Name:        "MQTT Retained Payload Length",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker retains a payload larger than the limit",
+		Severity:    config.SeverityMedium,
+		Remediation: "Set `retainer.max_payload_size` to the largest retained message your applications publish.",
+		References:  []string{"MQTT 5.0 section 3.3.1.3 RETAIN", "MQTT 5.0 section 3.2.2.3.5 Retain Available"},
+		Requires:    []string{authenticatedConnectID},
+		Skip: func(cfg *config.Config) string {
+			if cfg.Limit.RetainedPayloadLen <= 0 {
+				return "limit.retained_payload_len is not set"
+			}
+			return ""
+		},
+		RunListener: MQTTRetainedPayloadLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.retained-count",
+		Name:        "MQTT Retained Count",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if a single client can create more retained messages than the limit",
+		Severity:    config.SeverityMedium,
+		Remediation: "Set `retainer.max_retained_messages` to bound the retained messages of the broker, and deny publishing retained messages to the clients which do not need them.",
+		References:  []string{"MQTT 5.0 section 3.3.1.3 RETAIN", "MQTT 3.1.1 section 5.4.7 Detecting abnormal behaviors"},
+		Phase:       scanner.PhaseDisruptive,
+		Requires:    []string{authenticatedConnectID},
+		Exclusive:   true, // The retained messages may exhaust a broker wide limit while the scanner runs
+		Skip: func(cfg *config.Config) string {
+			if cfg.Limit.RetainedCount <= 0 {
+				return "limit.retained_count is not set"
+			}
+			return ""
+		},
+		RunListener: MQTTRetainedCount,
+	}))
+}
+
+// MQTTRetainedRead publishes a retained canary to every topic of the owner, and checks that the intruder subscribing to the
+// topic and to the topic filter of its first level receives none. The owner reads its canary first as a control.
+func MQTTRetainedRead(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Retained Read")
+	r := cfg.ACL.Retained
+	owner, _ := cfg.ACL.Identity(r.Owner)
+	intruder, _ := cfg.ACL.Identity(r.Intruder)
+	defer clearRetained(ctx, si, l, owner, "retained-read-clear", r.Topics)
+
+	for _, t := range r.Topics {
+		canary := retainedCanary()
+		result, err := retain(ctx, l, owner, "retained-read-owner", t, canary)
+		if err != nil {
+			return nil, err
+		}
+		stored, err := retainedPayload(ctx, l, owner, "retained-read-control", t)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored, canary) {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Broker did not retain the canary of %s on %s (%s), its read is not verified", owner.Name, t, result))
+			continue
+		}
+
+		for _, f := range []string{t, strings.Split(t, "/")[0] + "/#"} {
+			want := 0 // Other retained messages may match the wildcard filter
+			if f == t {
+				want = 1
+			}
+			messages, refused, err := readRetained(ctx, l, intruder, "retained-read-intruder", f, want)
+			switch {
+			case err != nil:
+				return nil, err
+			case refused != "":
+				si.Evidence = append(si.Evidence, fmt.Sprintf("%s subscribe %s refused: %s", intruder.Name, f, refused))
+			case bytes.Equal(messages[t], canary):
+				si.Evidence = append(si.Evidence, fmt.Sprintf("%s subscribe %s granted, received the retained canary of %s", intruder.Name, f, t))
+				si.Message = append(si.Message, fmt.Sprintf("%s read the retained message of %s on %s with the topic filter %s", intruder.Name, owner.Name, t, f))
+			default:
+				si.Evidence = append(si.Evidence, fmt.Sprintf("%s subscribe %s granted, the retained canary of %s was not delivered", intruder.Name, f, t))
+			}
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// MQTTRetainedOverwrite publishes a retained canary to every topic of the owner, then lets the intruder publish a forged
+// retained message and an empty retained payload to the topic, and checks as the owner that the canary is still retained
+func MQTTRetainedOverwrite(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Retained Overwrite")
+	r := cfg.ACL.Retained
+	owner, _ := cfg.ACL.Identity(r.Owner)
+	intruder, _ := cfg.ACL.Identity(r.Intruder)
+	defer clearRetained(ctx, si, l, owner, "retained-overwrite-clear", r.Topics)
+
+	for _, t := range r.Topics {
+		forgery := []byte("mqtt-security-scanner retained forgery " + RandomString(16))
+		for _, attempt := range []struct {
+			name    string
+			payload []byte
+		}{
+			{"overwrite", forgery},
+			{"delete", nil},
+		} {
+			// The canary is published again for each attempt, in case the previous one replaced it
+			canary := retainedCanary()
+			result, err := retain(ctx, l, owner, "retained-overwrite-owner", t, canary)
+			if err != nil {
+				return nil, err
+			}
+			stored, err := retainedPayload(ctx, l, owner, "retained-overwrite-control", t)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(stored, canary) {
+				si.Evidence = append(si.Evidence, fmt.Sprintf("Broker did not retain the canary of %s on %s (%s), the %s is not verified", owner.Name, t, result, attempt.name))
+				continue
+			}
+
+			result, err = retain(ctx, l, intruder, "retained-overwrite-intruder", t, attempt.payload)
+			if err != nil {
+				return nil, err
+			}
+			stored, err = retainedPayload(ctx, l, owner, "retained-overwrite-control", t)
+			if err != nil {
+				return nil, err
+			}
+
+			var now string
+			switch {
+			case bytes.Equal(stored, canary):
+				now = "the canary is still retained"
+			case bytes.Equal(stored, forgery):
+				now = "the forgery is retained"
+				si.Message = append(si.Message, fmt.Sprintf("%s overwrote the retained message of %s on %s", intruder.Name, owner.Name, t))
+			case stored == nil:
+				now = "no message is retained"
+				si.Message = append(si.Message, fmt.Sprintf("%s deleted the retained message of %s on %s", intruder.Name, owner.Name, t))
+			default:
+				now = "another message is retained"
+			}
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s %s of %s: %s, %s", intruder.Name, attempt.name, t, result, now))
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// MQTTRetainedPayloadLength publishes a retained payload one byte larger than the limit, and checks that the broker did not retain it
+func MQTTRetainedPayloadLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Retained Payload Length")
+	id := brokerIdentity(cfg)
+	topic := "mqtt-security-scanner/retained/" + RandomString(16)
+	size := cfg.Limit.RetainedPayloadLen + 1
+	defer clearRetained(ctx, si, l, id, "retained-payload-clear", []string{topic})
+
+	result, err := retain(ctx, l, id, "retained-payload", topic, bytes.Repeat([]byte{'x'}, size))
+	if err != nil {
+		return nil, err
+	}
+	stored, err := retainedPayload(ctx, l, id, "retained-payload-read", topic)
+	if err != nil {
+		return nil, err
+	}
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Retained publish of %d bytes: %s, %d bytes retained", size, result, len(stored)))
+	if len(stored) == size {
+		si.Message = append(si.Message, fmt.Sprintf("Broker retained a payload of %d bytes, larger than the limit of %d bytes", size, cfg.Limit.RetainedPayloadLen))
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// MQTTRetainedCount publishes one retained message more than the limit on a single connection, and counts the retained messages
+func MQTTRetainedCount(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Retained Count")
+	id := brokerIdentity(cfg)
+	limit := cfg.Limit.RetainedCount
+	prefix := "mqtt-security-scanner/retained/" + RandomString(16) + "/"
+
+	s, err := identitySession(ctx, l, id, "retained-count")
+	if err != nil {
+		return nil, err
+	}
+	var topics []string
+	defer func() {
+		clearRetained(ctx, si, l, id, "retained-count-clear", topics)
+	}()
+	for i := 0; i <= limit; i++ {
+		t := prefix + strconv.Itoa(i)
+		code, err := rawPublish(s, t, []byte("mqtt-security-scanner retained canary"), true)
+		if ctx.Err() != nil {
+			s.Close()
+			return nil, ctx.Err()
+		}
+		if err != nil || code >= ReasonUnspecifiedError {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Retained message %d refused: %s", i+1, describeResult("PUBACK", code, err)))
+			break
+		}
+		topics = append(topics, t)
+	}
+	s.Close()
+
+	messages, refused, err := readRetained(ctx, l, id, "retained-count-read", prefix+"#", limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if refused != "" {
+		return nil, fmt.Errorf("subscribe %s#: %s", prefix, refused)
+	}
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Broker retained %d of the %d messages published", len(messages), len(topics)))
+	if len(messages) > limit {
+		si.Message = append(si.Message, fmt.Sprintf("Broker retained %d messages of a single client, more than the limit of %d", len(messages), limit))
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// retainedCanary returns a new payload of a retained canary message
+func retainedCanary() []byte {
+	return []byte("mqtt-security-scanner retained canary " + RandomString(16))
+}
+
+// retain publishes the retained payload to the topic with a new session of the identity, an empty payload deletes the
+// retained message. It describes the result of the publish, and only fails if the identity cannot connect.
+func retain(ctx context.Context, l config.Listener, id config.Identity, name, topic string, payload []byte) (string, error) {
+	s, err := identitySession(ctx, l, id, name)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	code, err := rawPublish(s, topic, payload, true)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return describeResult("PUBACK", code, err), nil
+}
+
+// readRetained subscribes a new session of the identity to the topic filter, and returns the payloads of the retained
+// messages received by topic. It stops at want messages, or if none arrives within the delivery timeout when want is 0.
+// A refused subscription is described rather than returned as an error.
+func readRetained(ctx context.Context, l config.Listener, id config.Identity, name, filter string, want int) (map[string][]byte, string, error) {
+	s, err := identitySession(ctx, l, id, name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer s.Close()
+	code, err := rawSubscribe(s, filter)
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	if err != nil || code >= ReasonUnspecifiedError {
+		return nil, describeResult("SUBACK", code, err), nil
+	}
+
+	messages := make(map[string][]byte)
+	for want == 0 || len(messages) < want {
+		p, err := s.Receive(deliveryTimeout)
+		if err != nil {
+			break
+		}
+		if m, ok := p.(*mqtt_packet.Publish); ok && m.Retain {
+			messages[m.Topic] = m.Payload
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	return messages, "", nil
+}
+
+// retainedPayload returns the payload retained on the topic as the identity reads it, nil if none is retained
+func retainedPayload(ctx context.Context, l config.Listener, id config.Identity, name, topic string) ([]byte, error) {
+	messages, refused, err := readRetained(ctx, l, id, name, topic, 1)
+	if err != nil {
+		return nil, err
+	}
+	if refused != "" {
+		return nil, fmt.Errorf("%s cannot subscribe to %s to read the retained message: %s", id.Name, topic, refused)
+	}
+	return messages[topic], nil
+}
+
+// clearRetained deletes the retained messages of the topics with empty retained payloads as the identity, also after the
+// scanner was canceled, and adds a failure to the evidence of the scan item
+func clearRetained(ctx context.Context, si *config.ScanItem, l config.Listener, id config.Identity, name string, topics []string) {
+	if len(topics) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), connectTimeout)
+	defer cancel()
+
+	s, err := identitySession(ctx, l, id, name)
+	if err != nil {
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Retained canaries not deleted: %v", err))
+		return
+	}
+	defer s.Close()
+	for _, t := range topics {
+		if code, err := rawPublish(s, t, nil, true); err != nil || code >= ReasonUnspecifiedError {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Retained canary of %s not deleted: %s", t, describeResult("PUBACK", code, err)))
+			return
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "message.sys-exposure",
+		Name:     "MQTT System Topic Exposure",
+		Category: scanner.CategoryMessage,
+		Description: "Check that identities which are not admins cannot read the broker version, node names and client connection events " +
+			"from `$SYS/#` and the EMQX system and event topics",
+		Severity: config.SeverityMedium,
+		Remediation: "Deny subscribing to `$SYS/#` and `$event/#` for everyone but the admins in `authorization.sources`, " +
+			"e.g. with `{deny, all, subscribe, [\"$SYS/#\", \"$event/#\"]}` in the ACL file, and set `sys_topics.sys_event_messages` to `false` if no client needs the connection events.",
+		References: []string{
+			"MQTT 5.0 section 4.7.2 Topics beginning with $",
+			"MQTT 5.0 section 5.4.2 Authorization of Clients by the Server",
+		},
+		Requires: []string{authenticatedConnectID},
+		Skip: func(cfg *config.Config) string {
+			if len(cfg.ACL.Identities) == 0 {
+				return "No acl identities are configured"
+			}
+			return ""
+		},
+		RunListener: MQTTSysExposure,
+	}))
+}
+
+// defaultSysWindow is the number of seconds the system topics are listened to if limit.sys_window is not set
+const defaultSysWindow = 10
+
+// maxSysTopics bounds the number of topics collected per identity on a busy broker
+const maxSysTopics = 1000
+
+// sysFilters are the system topic filters of EMQX and Mosquitto. The specific filters are subscribed to as well as `$SYS/#`,
+// since authorization rules may deny the wildcard and allow the topics below it.
+var sysFilters = []string{
+	"$SYS/#",
+	"$SYS/brokers",
+	"$SYS/brokers/+/version",
+	"$SYS/brokers/+/sysdescr",
+	"$SYS/brokers/+/uptime",
+	"$SYS/brokers/+/clients/#",
+	"$SYS/brokers/+/stats/#",
+	"$SYS/brokers/+/metrics/#",
+	"$SYS/brokers/+/alarms/#",
+	"$event/#",
+	"$SYS/broker/#",
+}
+
+// Sensitive fields the system topics disclose
+const (
+	fieldVersion  = "broker version"
+	fieldNodes    = "node names"
+	fieldIPs      = "client IP addresses"
+	fieldUsername = "usernames"
+	fieldClientID = "client IDs"
+)
+
+// sysFields are the sensitive fields in the order they are reported
+var sysFields = []string{fieldVersion, fieldNodes, fieldIPs, fieldUsername, fieldClientID}
+
+// sysPayloadFields maps the keys of the JSON payloads of the EMQX system and event messages to the fields they disclose
+var sysPayloadFields = map[string]string{
+	"version":    fieldVersion,
+	"node":       fieldNodes,
+	"ipaddress":  fieldIPs,
+	"ip_address": fieldIPs,
+	"peername":   fieldIPs,
+	"peerhost":   fieldIPs,
+	"username":   fieldUsername,
+	"clientid":   fieldClientID,
+}
+
+// sysDisclosure is what an identity received from the system topics
+type sysDisclosure struct {
+	topics []string
+	fields map[string][]string // Distinct values per sensitive field
+}
+
+// add records the message and the sensitive fields it discloses
+func (d *sysDisclosure) add(topic string, payload []byte) {
+	if slices.Contains(d.topics, topic) || len(d.topics) >= maxSysTopics {
+		return
+	}
+	d.topics = append(d.topics, topic)
+
+	levels := strings.Split(topic, "/")
+	switch {
+	case topic == "$SYS/brokers":
+		for _, node := range strings.Split(string(payload), ",") {
+			d.record(fieldNodes, node)
+		}
+	case levels[0] == "$SYS" && len(levels) > 2 && levels[1] == "brokers":
+		d.record(fieldNodes, levels[2])
+		if len(levels) > 4 && levels[3] == "clients" {
+			d.record(fieldClientID, levels[4])
+		}
+	}
+	if last := levels[len(levels)-1]; last == "version" || last == "sysdescr" {
+		d.record(fieldVersion, string(payload))
+	}
+
+	var object map[string]any
+	if json.Unmarshal(payload, &object) != nil {
+		return
+	}
+	for key, value := range object {
+		if field, ok := sysPayloadFields[key]; ok && value != nil {
+			d.record(field, fmt.Sprint(value))
+		}
+	}
+}
+
+// record adds a distinct value of the field
+func (d *sysDisclosure) record(field, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" || slices.Contains(d.fields[field], value) {
+		return
+	}
+	d.fields[field] = append(d.fields[field], value)
+}
+
+// MQTTSysExposure subscribes every identity of the acl and the broker credentials to the system topics, connects and
+// disconnects a client so that the broker publishes its connection events, and reports the sensitive fields the
+// identities which are not admins received within the window. An identity which cannot connect is reported in the evidence.
+func MQTTSysExposure(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT System Topic Exposure")
+	window := cfg.Limit.SysWindow
+	if window <= 0 {
+		window = defaultSysWindow
+	}
+
+	identities := sysIdentities(cfg)
+	sessions := make([]*mqtt_packet.Session, len(identities))
+	defer func() {
+		for _, s := range sessions {
+			if s != nil {
+				s.Close()
+			}
+		}
+	}()
+	for i, id := range identities {
+		s, err := identitySession(ctx, l, id, "sys-"+id.Name)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s cannot connect: %v", id.Name, err))
+			continue
+		}
+		sessions[i] = s
+		granted, err := subscribeAll(s, sysFilters)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s cannot subscribe to the system topics: %v", id.Name, err))
+			s.Close()
+			sessions[i] = nil
+			continue
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s subscribed to %d of %d system topic filters: %s", id.Name, len(granted), len(sysFilters), strings.Join(granted, ", ")))
+	}
+
+	// The connection events of the probe are published while the identities listen, the other system topics are
+	// published without it
+	probe, err := identitySession(ctx, l, brokerIdentity(cfg), "sys-probe")
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Probe client cannot connect, no connection events are expected: %v", err))
+	} else {
+		probe.Send(&mqtt_packet.Disconnect{})
+		probe.Close()
+	}
+
+	disclosures := make([]*sysDisclosure, len(sessions))
+	deadline := time.Now().Add(time.Duration(window) * time.Second)
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		disclosures[i] = &sysDisclosure{fields: make(map[string][]string)}
+		if s == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				p, err := s.Receive(time.Until(deadline))
+				if err != nil {
+					return
+				}
+				if m, ok := p.(*mqtt_packet.Publish); ok {
+					disclosures[i].add(m.Topic, m.Payload)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for i, id := range identities {
+		if sessions[i] == nil {
+			continue
+		}
+		d := disclosures[i]
+		if len(d.topics) == 0 {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s received no system message within %ds", id.Name, window))
+			continue
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s received %d system topics, e.g. %s", id.Name, len(d.topics), strings.Join(d.topics[:min(len(d.topics), 5)], ", ")))
+		for _, field := range sysFields {
+			values := d.fields[field]
+			if len(values) == 0 {
+				continue
+			}
+			sample := strings.Join(values[:min(len(values), 5)], ", ")
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s received %d %s: %s", id.Name, len(values), field, sample))
+			if !id.Admin {
+				si.Message = append(si.Message, fmt.Sprintf("%s is not an admin and can read the %s (%s)", id.Name, field, sample))
+			}
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// brokerIdentity returns the identity of the configured broker credentials
+func brokerIdentity(cfg *config.Config) config.Identity {
+	return config.Identity{Name: "broker", Username: cfg.BrokerInfo.Username, Password: cfg.BrokerInfo.Password}
+}
+
+// sysIdentities returns the broker credentials followed by the identities of the acl. The broker credentials are an admin,
+// unless an identity has the same username and decides whether they are.
+func sysIdentities(cfg *config.Config) []config.Identity {
+	identities := slices.Clone(cfg.ACL.Identities)
+	if !slices.ContainsFunc(identities, func(id config.Identity) bool { return id.Username == cfg.BrokerInfo.Username }) {
+		broker := brokerIdentity(cfg)
+		broker.Admin = true
+		identities = append([]config.Identity{broker}, identities...)
+	}
+	return identities
+}
+
+// subscribeAll subscribes the session to the topic filters with QoS 0 in a single SUBSCRIBE packet, and returns the filters
+// the broker granted. Messages received before the SUBACK packet are discarded.
+func subscribeAll(s *mqtt_packet.Session, filters []string) ([]string, error) {
+	subscribe := &mqtt_packet.Subscribe{PacketID: 1}
+	for _, f := range filters {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, mqtt_packet.Subscription{Topic: f})
+	}
+	if err := s.Send(subscribe); err != nil {
+		return nil, fmt.Errorf("%w: %v", errConnectionClosed, err)
+	}
+	for {
+		p, err := s.Receive(connectTimeout)
+		if err != nil {
+			if isTimeout(err) {
+				return nil, errTimeout
+			}
+			return nil, fmt.Errorf("%w: %v", errConnectionClosed, err)
+		}
+		suback, ok := p.(*mqtt_packet.Suback)
+		if !ok {
+			continue
+		}
+		var granted []string
+		for i, code := range suback.ReasonCodes {
+			if i < len(filters) && ReasonCode(code) < ReasonUnspecifiedError {
+				granted = append(granted, filters[i])
+			}
+		}
+		return granted, nil
+	}
+}
@@ -0,0 +1,308 @@
+package mqtt_scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/app/tls_probe"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "tls.cipher-suites",
+		Name:     "TLS Cipher Suites",
+		Category: scanner.CategoryTLS,
+		Description: "Enumerate the cipher suites the SSL and WSS listeners accept per TLS version and check them against the weak suites " +
+			"(NULL, anonymous, export, RC4, DES, 3DES, CBC with SHA-1, no forward secrecy) and the allowed and denied suites of the configuration",
+		Severity: config.SeverityHigh,
+		Remediation: "Set `listeners.ssl.<name>.ssl_options.ciphers` and `listeners.wss.<name>.ssl_options.ciphers` to AEAD cipher suites with ECDHE key exchange only, " +
+			"e.g. `TLS_AES_256_GCM_SHA384,TLS_AES_128_GCM_SHA256,TLS_CHACHA20_POLY1305_SHA256,ECDHE-ECDSA-AES256-GCM-SHA384,ECDHE-RSA-AES256-GCM-SHA384`, and enable `honor_cipher_order`.",
+		References: []string{
+			"RFC 7525 section 4.2 Recommended Cipher Suites https://www.rfc-editor.org/rfc/rfc7525#section-4.2",
+			"RFC 7465 Prohibiting RC4 Cipher Suites https://www.rfc-editor.org/rfc/rfc7465",
+			"NIST SP 800-52 Rev. 2",
+		},
+		Skip: func(cfg *config.Config) string {
+			if !cfg.BrokerInfo.TLS {
+				return "TLS is disabled in the configuration"
+			}
+			return ""
+		},
+		ListenerSource: config.BrokerInfo.TLSListeners,
+		RunListener:    CipherSuitesScanner,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "tls.certificate",
+		Name:     "TLS Certificate",
+		Category: scanner.CategoryTLS,
+		Description: "Check the certificate chain the SSL and WSS listeners present: validity and expiry, host name match, " +
+			"trust against the configured CA bundle, self-signed certificates, key size, SHA-1 or MD5 signatures and subject alternative names",
+		Severity: config.SeverityHigh,
+		Remediation: "Set `listeners.ssl.<name>.ssl_options.certfile`, `keyfile` and `cacertfile`, and the same keys of `listeners.wss.<name>`, to a certificate issued by a trusted CA " +
+			"for the broker host name in its subject alternative names, with a RSA key of at least 2048 bits or an ECDSA key of at least 256 bits and a SHA-256 or stronger signature. Renew it before it expires.",
+		References: []string{
+			"RFC 5280 Internet X.509 Public Key Infrastructure Certificate and CRL Profile https://www.rfc-editor.org/rfc/rfc5280",
+			"RFC 6125 section 6 Verifying Service Identity https://www.rfc-editor.org/rfc/rfc6125#section-6",
+			"NIST SP 800-52 Rev. 2",
+		},
+		Skip: func(cfg *config.Config) string {
+			if !cfg.BrokerInfo.TLS {
+				return "TLS is disabled in the configuration"
+			}
+			return ""
+		},
+		ListenerSource: config.BrokerInfo.TLSListeners,
+		RunListener:    CertificateScanner,
+	}))
+}
+
+const (
+	// defaultCertExpiryDays is the number of days before expiry a certificate is reported if limit.cert_expiry_days is not set
+	defaultCertExpiryDays = 30
+	// minRSAKeySize and minECDSAKeySize are the smallest acceptable key sizes in bits
+	minRSAKeySize   = 2048
+	minECDSAKeySize = 256
+)
+
+// weakSignatures are the signature algorithms based on broken hash functions
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// CipherSuitesScanner enumerates the cipher suites the TLS listener accepts for every TLS version.
+// A suite fails the check if it is denied, if an allow list is configured and it is not on it,
+// or if it is weak and not explicitly allowed.
+func CipherSuitesScanner(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("TLS Cipher Suites")
+	allowed, err := cipherSuiteSet(cfg.Limit.AllowedCipherSuites, "allowed_cipher_suites")
+	if err != nil {
+		return nil, err
+	}
+	denied, err := cipherSuiteSet(cfg.Limit.DeniedCipherSuites, "denied_cipher_suites")
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+	for _, version := range tls_probe.Versions {
+		suites, err := tls_probe.Enumerate(ctx, address, l.Host, version)
+		if err != nil {
+			return nil, fmt.Errorf("enumerate the %s cipher suites: %w", tls_probe.VersionName(version), err)
+		}
+		if len(suites) == 0 {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s: not supported", tls_probe.VersionName(version)))
+			continue
+		}
+
+		names := make([]string, len(suites))
+		for i, suite := range suites {
+			name := tls_probe.SuiteName(suite)
+			names[i] = name
+			switch weaknesses := tls_probe.Weaknesses(suite); {
+			case denied[suite]:
+				si.Message = append(si.Message, fmt.Sprintf("%s accepts the denied cipher suite %s", tls_probe.VersionName(version), name))
+			case len(allowed) > 0 && !allowed[suite]:
+				si.Message = append(si.Message, fmt.Sprintf("%s accepts the cipher suite %s which is not allowed", tls_probe.VersionName(version), name))
+			case len(weaknesses) > 0 && !allowed[suite]:
+				si.Message = append(si.Message, fmt.Sprintf("%s accepts the weak cipher suite %s (%s)",
+					tls_probe.VersionName(version), name, strings.Join(weaknesses, ", ")))
+			}
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s: %s", tls_probe.VersionName(version), strings.Join(names, ", ")))
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// cipherSuiteSet returns the cipher suites of the IANA names configured under the limit key
+func cipherSuiteSet(names []string, key string) (map[uint16]bool, error) {
+	set := make(map[uint16]bool, len(names))
+	for _, name := range names {
+		suite, ok := tls_probe.SuiteByName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s in limit.%s, use the IANA name, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", name, key)
+		}
+		set[suite] = true
+	}
+	return set, nil
+}
+
+// CertificateScanner performs a TLS handshake with the listener and audits the certificate chain it presents
+func CertificateScanner(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("TLS Certificate")
+	roots, rootsName, err := certificateRoots(cfg.BrokerInfo.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	expiryDays := cfg.Limit.CertExpiryDays
+	if expiryDays <= 0 {
+		expiryDays = defaultCertExpiryDays
+	}
+
+	chain, err := peerCertificates(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	leaf := chain[0]
+	now := time.Now()
+
+	for i, cert := range chain {
+		name := cert.Subject.String()
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Certificate %d: subject %q, issuer %q, SANs [%s], valid from %s to %s, %s key, %s signature",
+			i, name, cert.Issuer.String(), strings.Join(subjectAltNames(cert), ", "),
+			cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), keyDescription(cert), cert.SignatureAlgorithm))
+
+		switch days := int(cert.NotAfter.Sub(now).Hours() / 24); {
+		case now.After(cert.NotAfter):
+			si.Message = append(si.Message, fmt.Sprintf("Certificate %q expired on %s", name, cert.NotAfter.Format(time.DateOnly)))
+		case now.Before(cert.NotBefore):
+			si.Message = append(si.Message, fmt.Sprintf("Certificate %q is not valid before %s", name, cert.NotBefore.Format(time.DateOnly)))
+		case days < expiryDays:
+			si.Message = append(si.Message, fmt.Sprintf("Certificate %q expires in %d days on %s, within %d days", name, days, cert.NotAfter.Format(time.DateOnly), expiryDays))
+		}
+
+		if weak := weakKey(cert); weak != "" {
+			si.Message = append(si.Message, fmt.Sprintf("Certificate %q has a weak key: %s", name, weak))
+		}
+		// Clients do not verify the signature of a self-signed certificate, only the one of issued certificates matters
+		if weakSignatures[cert.SignatureAlgorithm] && !selfSigned(cert) {
+			si.Message = append(si.Message, fmt.Sprintf("Certificate %q is signed with the weak algorithm %s", name, cert.SignatureAlgorithm))
+		}
+	}
+
+	if len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 {
+		si.Message = append(si.Message, "Certificate has no subject alternative names, clients ignore the common name for host name verification")
+	}
+	if err := leaf.VerifyHostname(l.Host); err != nil {
+		si.Message = append(si.Message, fmt.Sprintf("Certificate does not match the host %s: %v", l.Host, err))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	var invalid x509.CertificateInvalidError
+	switch {
+	case err == nil:
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Chain trusted by the %s", rootsName))
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		// Already reported with the validity of the certificates
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Chain not verified by the %s: %v", rootsName, err))
+	case selfSigned(leaf):
+		si.Message = append(si.Message, fmt.Sprintf("Certificate is self-signed and not trusted by the %s", rootsName))
+	default:
+		si.Message = append(si.Message, fmt.Sprintf("Certificate chain is not trusted by the %s: %v", rootsName, err))
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// peerCertificates returns the certificate chain the TLS listener presents, the leaf first
+func peerCertificates(ctx context.Context, l config.Listener) ([]*x509.Certificate, error) {
+	conn, err := dial(ctx, l.Host, l.Port)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// The chain is verified by the caller, so that an untrusted chain is a finding rather than an error
+	c := l.TLSConfig()
+	c.MinVersion = tls.VersionTLS10
+	client := tls.Client(conn, c)
+	defer client.Close()
+	if err := client.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+	chain := client.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("TLS handshake: no certificate presented")
+	}
+	return chain, nil
+}
+
+// certificateRoots returns the CA certificates of the PEM bundle, the system roots if the path is empty,
+// together with a description of them
+func certificateRoots(path string) (*x509.CertPool, string, error) {
+	if path == "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, "", fmt.Errorf("load the system CA certificates: %w", err)
+		}
+		return roots, "system CA certificates", nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read broker.ca_file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b) {
+		return nil, "", fmt.Errorf("no PEM certificate in broker.ca_file %s", path)
+	}
+	return roots, "CA certificates of " + path, nil
+}
+
+// selfSigned reports whether the certificate is issued by itself. The signature is not checked since crypto/x509
+// refuses to verify SHA-1 and MD5 signatures.
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		(len(cert.AuthorityKeyId) == 0 || bytes.Equal(cert.AuthorityKeyId, cert.SubjectKeyId))
+}
+
+// weakKey describes why the public key of the certificate is too weak, empty if it is not
+func weakKey(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeySize {
+			return fmt.Sprintf("RSA key of %d bits, less than %d bits", key.N.BitLen(), minRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < minECDSAKeySize {
+			return fmt.Sprintf("ECDSA key of %d bits, less than %d bits", key.Curve.Params().BitSize, minECDSAKeySize)
+		}
+	case *dsa.PublicKey:
+		return "DSA key"
+	}
+	return ""
+}
+
+// keyDescription describes the algorithm and size of the public key of the certificate, e.g. "RSA 2048 bit"
+func keyDescription(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bit", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// subjectAltNames returns the DNS names and IP addresses of the certificate
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
@@ -0,0 +1,233 @@
+package mqtt_scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/config"
+)
+
+const (
+	// wsSubprotocol is the WebSocket subprotocol MQTT clients must offer and brokers must select
+	wsSubprotocol = "mqtt"
+	// wsForeignOrigin is the Origin of a cross-site page connecting to the broker
+	wsForeignOrigin = "https://mqtt-security-scanner.invalid"
+	// wsReplyTimeout is how long the broker is given to answer or close the connection after a frame
+	wsReplyTimeout = 5 * time.Second
+)
+
+// wsHandshake describes a WebSocket handshake the broker should refuse
+type wsHandshake struct {
+	name         string
+	path         string // Overrides the path of the listener if not empty
+	subprotocols []string
+	origin       string
+}
+
+// wsFrame describes a WebSocket frame carrying a MQTT packet which the broker should close the connection for
+type wsFrame struct {
+	name string
+	// send writes the packet in the invalid frame
+	send func(ws *websocket.Conn, packet []byte) error
+}
+
+// WebSocketScanner performs WebSocket handshakes with the WS or WSS listener and sends invalid frames to check that
+// the broker enforces the mqtt subprotocol, the Origin, the path, the frame size, binary frames and masked frames
+func WebSocketScanner(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("Invalid Websocket Protocol")
+	version := mqtt_packet.ProtocolLevel(l.Protocol)
+	connect := credentialsConnect(version, clientID(l, "websocket"), cfg)
+
+	// A valid handshake and CONNECT show that the listener is reachable and establish what a refusal looks like
+	ws, status, err := wsDial(ctx, l, l.Path, []string{wsSubprotocol}, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ws == nil {
+		return nil, fmt.Errorf("WebSocket handshake with subprotocol %s refused with HTTP status %d", wsSubprotocol, status)
+	}
+	connack, err := wsConnect(ws, connect)
+	if err != nil {
+		return nil, fmt.Errorf("CONNECT over WebSocket: %w", err)
+	}
+	selected := ws.Subprotocol()
+	ws.Close()
+	si.Evidence = append(si.Evidence, fmt.Sprintf("Handshake with subprotocol %s accepted, broker selected %q, CONNACK reason code %s",
+		wsSubprotocol, selected, connackReason(version, connack.ReasonCode)))
+	if selected != wsSubprotocol {
+		si.Message = append(si.Message, fmt.Sprintf("Broker selected the subprotocol %q instead of %s", selected, wsSubprotocol))
+	}
+
+	handshakes := []wsHandshake{
+		{name: "without a subprotocol"},
+		{name: "with the subprotocol mqtt-security-scanner only", subprotocols: []string{"mqtt-security-scanner"}},
+		{name: "with the Origin " + wsForeignOrigin, subprotocols: []string{wsSubprotocol}, origin: wsForeignOrigin},
+		{name: "on an unknown path", path: "/mqtt-security-scanner-" + RandomString(8), subprotocols: []string{wsSubprotocol}},
+	}
+	for _, h := range handshakes {
+		path := l.Path
+		if h.path != "" {
+			path = h.path
+		}
+		header := http.Header{}
+		if h.origin != "" {
+			header.Set("Origin", h.origin)
+		}
+
+		ws, status, err := wsDial(ctx, l, path, h.subprotocols, header, 0)
+		if err != nil {
+			return nil, err
+		}
+		if ws == nil {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Handshake %s refused with HTTP status %d", h.name, status))
+			continue
+		}
+		ws.Close()
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Handshake %s accepted", h.name))
+		si.Message = append(si.Message, fmt.Sprintf("Broker accepted a WebSocket handshake %s", h.name))
+	}
+
+	// The invalid frames are sent on connections accepted with the configured credentials
+	if connack.ReasonCode != 0 {
+		si.Evidence = append(si.Evidence, "Frame checks skipped, the broker refused the CONNECT with the configured credentials")
+		si.SetPass(len(si.Message) == 0)
+		return si, nil
+	}
+
+	frames := []wsFrame{
+		{
+			name: "PINGREQ in a text frame",
+			send: func(ws *websocket.Conn, packet []byte) error {
+				return ws.WriteMessage(websocket.TextMessage, packet)
+			},
+		},
+		{
+			name: "PINGREQ in an unmasked binary frame",
+			send: func(ws *websocket.Conn, packet []byte) error {
+				// Client frames must be masked, the WebSocket library always masks them so the frame is written raw
+				frame := append([]byte{0x82, byte(len(packet))}, packet...)
+				_, err := ws.NetConn().Write(frame)
+				return err
+			},
+		},
+	}
+	for _, f := range frames {
+		ok, observed, err := wsFrameRefused(ctx, l, connect, 0, f, mqtt_packet.Encode(&mqtt_packet.Pingreq{}, version))
+		if err != nil {
+			return nil, err
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s: %s", f.name, observed))
+		if !ok {
+			si.Message = append(si.Message, fmt.Sprintf("Broker accepted a %s", f.name))
+		}
+	}
+
+	if size := cfg.Limit.WSFrameSize; size > 0 {
+		// A QoS 1 PUBLISH packet of one byte more than the limit, the broker acknowledges it if it accepts the frame
+		publish := &mqtt_packet.Publish{QoS: 1, PacketID: 1, Topic: "mqtt-security-scanner/" + RandomString(8)}
+		publish.Payload = make([]byte, size)
+		packet := mqtt_packet.Encode(publish, version)
+		publish.Payload = publish.Payload[:max(0, size-(len(packet)-size-1))]
+		packet = mqtt_packet.Encode(publish, version)
+
+		f := wsFrame{
+			name: fmt.Sprintf("binary frame of %d bytes", len(packet)),
+			send: func(ws *websocket.Conn, packet []byte) error {
+				return ws.WriteMessage(websocket.BinaryMessage, packet)
+			},
+		}
+		ok, observed, err := wsFrameRefused(ctx, l, connect, len(packet), f, packet)
+		if err != nil {
+			return nil, err
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s: %s", f.name, observed))
+		if !ok {
+			si.Message = append(si.Message, fmt.Sprintf("Broker accepted a %s, larger than the limit of %d bytes", f.name, size))
+		}
+	} else {
+		si.Evidence = append(si.Evidence, "Oversized frame check skipped, limit.ws_frame_size is not configured")
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// wsDial performs a WebSocket handshake with the listener on the path, offering the subprotocols.
+// A refused handshake returns a nil connection and the HTTP status code of the refusal.
+// The write buffer is large enough for a single frame of writeBuffer bytes, the library default if 0.
+func wsDial(ctx context.Context, l config.Listener, path string, subprotocols []string, header http.Header, writeBuffer int) (*websocket.Conn, int, error) {
+	if writeBuffer > 0 {
+		// Room for the frame header, so that the message is sent in a single frame
+		writeBuffer += 14
+	}
+	d := websocket.Dialer{
+		Subprotocols:     subprotocols,
+		TLSClientConfig:  l.TLSConfig(),
+		HandshakeTimeout: connectTimeout,
+		WriteBufferSize:  writeBuffer,
+	}
+	target := l
+	target.Path = path
+
+	ws, resp, err := d.DialContext(ctx, target.URL(), header)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		return nil, resp.StatusCode, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return ws, http.StatusSwitchingProtocols, nil
+}
+
+// wsConnect sends the CONNECT packet over the WebSocket connection and waits for the CONNACK packet
+func wsConnect(ws *websocket.Conn, connect *mqtt_packet.Connect) (*mqtt_packet.Connack, error) {
+	session := mqtt_packet.NewSession(mqtt_packet.WebSocketConn(ws), connect.ProtocolLevel)
+	return session.Connect(connect, connectTimeout)
+}
+
+// wsFrameRefused connects over a valid WebSocket connection and sends the packet in the invalid frame.
+// It reports whether the broker closed the connection, and describes what the broker did.
+func wsFrameRefused(ctx context.Context, l config.Listener, connect *mqtt_packet.Connect, writeBuffer int, f wsFrame, packet []byte) (bool, string, error) {
+	ws, status, err := wsDial(ctx, l, l.Path, []string{wsSubprotocol}, nil, writeBuffer)
+	if err != nil {
+		return false, "", err
+	}
+	if ws == nil {
+		return false, "", fmt.Errorf("WebSocket handshake refused with HTTP status %d", status)
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	session := mqtt_packet.NewSession(mqtt_packet.WebSocketConn(ws), connect.ProtocolLevel)
+	connack, err := session.Connect(connect, connectTimeout)
+	if err != nil {
+		return false, "", fmt.Errorf("CONNECT before the %s: %w", f.name, err)
+	}
+	if connack.ReasonCode != 0 {
+		return false, "", fmt.Errorf("CONNECT before the %s refused with reason code %s", f.name, connackReason(connect.ProtocolLevel, connack.ReasonCode))
+	}
+
+	if err := f.send(ws, packet); err != nil {
+		return true, fmt.Sprintf("Broker closed the connection: %v", err), nil
+	}
+	reply, err := session.Receive(wsReplyTimeout)
+	switch {
+	case ctx.Err() != nil:
+		return false, "", ctx.Err()
+	case isTimeout(err):
+		return false, fmt.Sprintf("Connection still open after %v", wsReplyTimeout), nil
+	case err != nil:
+		return true, fmt.Sprintf("Broker closed the connection: %v", err), nil
+	}
+	return false, describePacket(reply, connect.ProtocolLevel), nil
+}
@@ -0,0 +1,387 @@
+package mqtt_scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "message.will-acl",
+		Name:     "MQTT Will ACL",
+		Category: scanner.CategoryMessage,
+		Description: "Check that the broker neither publishes nor retains the will message of a dropped connection on a topic " +
+			"the identity is denied publishing to",
+		Severity: config.SeverityHigh,
+		Remediation: "Deny publishing to the topics in `authorization.sources`, EMQX authorizes the will message as a publish of the client when the connection is lost. " +
+			"Upgrade brokers which publish wills without authorization.",
+		References: []string{
+			"MQTT 5.0 section 3.1.2.5 Will Flag",
+			"MQTT 5.0 section 3.1.2.7 Will Retain",
+			"MQTT 5.0 section 5.4.2 Authorization of Clients by the Server",
+		},
+		Exclusive: true, // The instances publish retained wills to the same topics
+		Skip: func(cfg *config.Config) string {
+			if !cfg.ACL.Will.Enabled() {
+				return "No will identity is configured"
+			}
+			return ""
+		},
+		RunListener: MQTTWillACL,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "message.will-payload-length",
+		Name:        "MQTT Will Payload Length",
+		Category:    scanner.CategoryMessage,
+		Description: "Check if the broker publishes a will message with a payload larger than the limit",
+		Severity:    config.SeverityMedium,
+		Remediation: "Set `mqtt.max_packet_size` to the largest CONNECT packet, will message included, your clients send.",
+		References:  []string{"MQTT 5.0 section 3.1.3.4 Will Payload", "MQTT 5.0 section 3.1.2.11.4 Maximum Packet Size"},
+		Requires:    []string{authenticatedConnectID},
+		Skip: func(cfg *config.Config) string {
+			if cfg.Limit.WillPayloadLen <= 0 {
+				return "limit.will_payload_len is not set"
+			}
+			return ""
+		},
+		RunListener: MQTTWillPayloadLength,
+	}))
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:       "message.will-delay",
+		Name:     "MQTT Will Delay",
+		Category: scanner.CategoryMessage,
+		Description: "Check that the broker delays the will message by its will delay interval, " +
+			"and does not publish it if the client resumes its session within the interval",
+		Severity:    config.SeverityLow,
+		Remediation: "Upgrade to a broker version which implements the MQTT 5.0 Will Delay Interval.",
+		References:  []string{"MQTT 5.0 section 3.1.3.2.2 Will Delay Interval"},
+		Requires:    []string{authenticatedConnectID},
+		Listeners:   func(l config.Listener) bool { return l.Protocol == config.ProtocolMQTT5 },
+		RunListener: MQTTWillDelay,
+	}))
+}
+
+// willDelay is the will delay interval of the will delay check
+const willDelay = 3 * time.Second
+
+// MQTTWillACL connects the identity with a will message, then with a retained will message, on every topic it is denied
+// publishing to and drops the connection. The observer checks that the will is neither delivered nor retained.
+// A topic the identity can publish to directly is reported in the evidence and not checked.
+func MQTTWillACL(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Will ACL")
+	w := cfg.ACL.Will
+	id, _ := cfg.ACL.Identity(w.Identity)
+	observer := aclObserver(cfg)
+	var retained []string
+	defer func() {
+		clearRetainedWills(ctx, si, l, id, observer, retained)
+	}()
+
+	for _, t := range w.Topics {
+		allowed, observed, err := checkPublish(ctx, l, observer, id, t)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s can publish to %s directly (%s), its will is not verified", id.Name, t, observed))
+			continue
+		}
+		si.Evidence = append(si.Evidence, fmt.Sprintf("%s publish to %s: %s", id.Name, t, observed))
+
+		for _, retain := range []bool{false, true} {
+			kind := "will"
+			if retain {
+				kind = "retained will"
+			}
+			obs, err := observe(ctx, l, observer, "will-observer", t)
+			if err != nil {
+				return nil, err
+			}
+			payload := willPayload()
+			accepted, result, err := dropWithWill(ctx, l, id, "will-acl", will(t, payload, retain))
+			if err != nil || !accepted {
+				obs.Close()
+				if err != nil {
+					return nil, err
+				}
+				si.Evidence = append(si.Evidence, fmt.Sprintf("%s CONNECT with a %s on %s: %s", id.Name, kind, t, result))
+				continue
+			}
+			delivered, err := awaitMessage(obs, t, payload, deliveryTimeout)
+			obs.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("observer %s waiting for the will on %s: %w", observer.Name, t, err)
+			}
+			stored := false
+			if retain {
+				p, err := retainedPayload(ctx, l, observer, "will-retained-read", t)
+				if err != nil {
+					return nil, err
+				}
+				stored = bytes.Equal(p, payload)
+			}
+			if stored {
+				retained = append(retained, t)
+			}
+
+			si.Evidence = append(si.Evidence, fmt.Sprintf("%s connection with a %s on %s dropped: delivered to the observer %t, retained %t", id.Name, kind, t, delivered, stored))
+			if delivered {
+				si.Message = append(si.Message, fmt.Sprintf("Broker published the %s of %s to %s although %s is denied publishing to it", kind, id.Name, t, id.Name))
+			}
+			if stored {
+				si.Message = append(si.Message, fmt.Sprintf("Broker retained the will of %s on %s although %s is denied publishing to it", id.Name, t, id.Name))
+			}
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// MQTTWillPayloadLength connects with a will payload one byte larger than the limit and drops the connection,
+// and checks that the broker refuses the CONNECT or does not publish the will
+func MQTTWillPayloadLength(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Will Payload Length")
+	id := brokerIdentity(cfg)
+	topic := "mqtt-security-scanner/will/" + RandomString(16)
+	size := cfg.Limit.WillPayloadLen + 1
+
+	obs, err := observe(ctx, l, id, "will-payload-observer", topic)
+	if err != nil {
+		return nil, err
+	}
+	defer obs.Close()
+	payload := bytes.Repeat([]byte{'x'}, size)
+	accepted, result, err := dropWithWill(ctx, l, id, "will-payload", will(topic, payload, false))
+	if err != nil {
+		return nil, err
+	}
+	si.Evidence = append(si.Evidence, fmt.Sprintf("CONNECT with a will of %d bytes: %s", size, result))
+	if !accepted {
+		si.SetPass(true)
+		return si, nil
+	}
+
+	delivered, err := awaitMessage(obs, topic, payload, deliveryTimeout)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("observer waiting for the will on %s: %w", topic, err)
+	}
+	if delivered {
+		si.Message = append(si.Message, fmt.Sprintf("Broker published a will payload of %d bytes, larger than the limit of %d bytes", size, cfg.Limit.WillPayloadLen))
+	} else {
+		si.Evidence = append(si.Evidence, fmt.Sprintf("Will not published within %v", deliveryTimeout))
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// MQTTWillDelay drops a connection with a delayed will and checks that the will is not published before the interval,
+// then drops another one and resumes its session right away, and checks that its will is not published at all
+func MQTTWillDelay(ctx context.Context, cfg *config.Config, l config.Listener) (*config.ScanItem, error) {
+	si := config.NewScanItem("MQTT Will Delay")
+	id := brokerIdentity(cfg)
+	// The session must outlive the will delay, the broker publishes the will when the session ends otherwise
+	delayed := func(c *mqtt_packet.Connect) {
+		c.Properties = append(c.Properties, mqtt_packet.Property{ID: mqtt_packet.PropSessionExpiry, Value: uint32(60)})
+		c.WillProperties = append(c.WillProperties, mqtt_packet.Property{ID: mqtt_packet.PropWillDelayInterval, Value: uint32(willDelay / time.Second)})
+	}
+
+	for _, resume := range []bool{false, true} {
+		name := "will-delay"
+		if resume {
+			name = "will-delay-resume"
+		}
+		topic := "mqtt-security-scanner/will/" + RandomString(16)
+		obs, err := observe(ctx, l, id, name+"-observer", topic)
+		if err != nil {
+			return nil, err
+		}
+		payload := willPayload()
+		accepted, result, err := dropWithWill(ctx, l, id, name, will(topic, payload, false), delayed)
+		if err != nil || !accepted {
+			obs.Close()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("CONNECT with a delayed will: %s", result)
+		}
+		dropped := time.Now()
+
+		var session *mqtt_packet.Session
+		if resume {
+			session, err = identitySession(ctx, l, id, name, func(c *mqtt_packet.Connect) {
+				c.CleanStart = false
+				c.Properties = append(c.Properties, mqtt_packet.Property{ID: mqtt_packet.PropSessionExpiry, Value: uint32(60)})
+			})
+			if err != nil {
+				obs.Close()
+				return nil, err
+			}
+		}
+		delivered, err := awaitMessage(obs, topic, payload, willDelay+deliveryTimeout)
+		elapsed := time.Since(dropped).Round(100 * time.Millisecond)
+		obs.Close()
+		endSession(ctx, l, id, name, session)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("observer waiting for the will on %s: %w", topic, err)
+		}
+
+		switch {
+		case resume && delivered:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Will published %v after the connection was lost, although the session was resumed", elapsed))
+			si.Message = append(si.Message, fmt.Sprintf("Broker published the will although the client resumed its session within the will delay interval of %v", willDelay))
+		case resume:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Will not published after the session was resumed within the will delay interval of %v", willDelay))
+		case !delivered:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Will with a will delay interval of %v not published within %v", willDelay, elapsed))
+		case elapsed < willDelay:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Will published %v after the connection was lost", elapsed))
+			si.Message = append(si.Message, fmt.Sprintf("Broker published the will %v after the connection was lost, before its will delay interval of %v", elapsed, willDelay))
+		default:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Will published %v after the connection was lost, will delay interval %v", elapsed, willDelay))
+		}
+	}
+
+	si.SetPass(len(si.Message) == 0)
+	return si, nil
+}
+
+// willPayload returns a new payload of a will message
+func willPayload() []byte {
+	return []byte("mqtt-security-scanner will " + RandomString(16))
+}
+
+// will configures a CONNECT packet with a QoS 1 will message
+func will(topic string, payload []byte, retain bool) func(*mqtt_packet.Connect) {
+	return func(c *mqtt_packet.Connect) {
+		c.WillFlag, c.WillQoS, c.WillRetain, c.WillTopic, c.WillPayload = true, 1, retain, topic, payload
+	}
+}
+
+// observe opens a session of the identity subscribed to the topic, which it must be allowed to subscribe to
+func observe(ctx context.Context, l config.Listener, id config.Identity, name, topic string) (*mqtt_packet.Session, error) {
+	s, err := identitySession(ctx, l, id, name)
+	if err != nil {
+		return nil, err
+	}
+	if code, err := rawSubscribe(s, topic); err != nil || code >= ReasonUnspecifiedError {
+		s.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("observer %s cannot subscribe to %s: %s", id.Name, topic, describeResult("SUBACK", code, err))
+	}
+	return s, nil
+}
+
+// dropWithWill connects a new session of the identity with the configured will and closes the connection without
+// DISCONNECT, so that the broker publishes the will. It reports whether the broker accepted the CONNECT and describes
+// what the broker did. A CONNECT the broker refuses with a reason code or by closing the connection is not an error,
+// failing to connect to the listener, a CONNECT without answer and an invalid answer are.
+func dropWithWill(ctx context.Context, l config.Listener, id config.Identity, name string, configure ...func(*mqtt_packet.Connect)) (bool, string, error) {
+	s, err := mqtt_packet.DialSession(ctx, l)
+	if err != nil {
+		return false, "", err
+	}
+	connack, err := s.Connect(identityConnect(l, s.Version, id, name, configure...), connectTimeout)
+	s.Close()
+	switch {
+	case ctx.Err() != nil:
+		return false, "", ctx.Err()
+	case isTimeout(err):
+		return false, "", fmt.Errorf("connect as %s: %w", id.Name, errTimeout)
+	case errors.Is(err, mqtt_packet.ErrMalformed):
+		return false, "", fmt.Errorf("connect as %s: %w", id.Name, err)
+	case err != nil:
+		return false, fmt.Sprintf("CONNECT refused, %v: %v", errConnectionClosed, err), nil
+	case connack.ReasonCode != 0:
+		return false, fmt.Sprintf("CONNECT refused with CONNACK reason code %s", connackReason(s.Version, connack.ReasonCode)), nil
+	}
+	return true, "CONNECT accepted", nil
+}
+
+// clearRetainedWills deletes the retained wills of the topics as the identity which set them, also after the scanner was
+// canceled, and adds a will which may still be retained to the evidence of the scan item
+func clearRetainedWills(ctx context.Context, si *config.ScanItem, l config.Listener, id, observer config.Identity, topics []string) {
+	for _, t := range topics {
+		deleted, attempts, err := clearRetainedWill(ctx, l, id, observer, t)
+		switch {
+		case err != nil:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Retained will of %s not deleted: %v", t, err))
+		case !deleted:
+			si.Evidence = append(si.Evidence, fmt.Sprintf("Retained will of %s not deleted: %s", t, strings.Join(attempts, ", ")))
+		}
+	}
+}
+
+// clearRetainedWill publishes an empty retained payload to the topic as the identity, and since the identity may be denied
+// publishing to it, drops a connection with an empty retained will next if the observer did not receive the empty payload.
+// It reports whether the observer received it, and describes the attempts otherwise.
+func clearRetainedWill(ctx context.Context, l config.Listener, id, observer config.Identity, topic string) (bool, []string, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 4*connectTimeout+2*deliveryTimeout)
+	defer cancel()
+
+	publish := func() (string, error) {
+		result, err := retain(ctx, l, id, "will-clear", topic, nil)
+		return "empty retained publish " + result, err
+	}
+	emptyWill := func() (string, error) {
+		_, result, err := dropWithWill(ctx, l, id, "will-clear", will(topic, nil, true))
+		return "empty retained will " + result, err
+	}
+
+	var attempts []string
+	for _, clear := range []func() (string, error){publish, emptyWill} {
+		// A new observer per attempt, a WebSocket session is unusable after the previous one timed out
+		obs, err := observe(ctx, l, observer, "will-clear-observer", topic)
+		if err != nil {
+			return false, nil, err
+		}
+		result, err := clear()
+		deleted := false
+		if err == nil {
+			deleted, err = awaitMessage(obs, topic, nil, deliveryTimeout)
+		}
+		obs.Close()
+		if err != nil || deleted {
+			return deleted, nil, err
+		}
+		attempts = append(attempts, fmt.Sprintf("%s, not received by the observer", result))
+	}
+	return false, attempts, nil
+}
+
+// endSession ends the session of the client ID the name makes, disconnecting the resumed session if any,
+// so that no session of the will delay check outlives it
+func endSession(ctx context.Context, l config.Listener, id config.Identity, name string, resumed *mqtt_packet.Session) {
+	if resumed != nil {
+		resumed.Send(&mqtt_packet.Disconnect{Properties: mqtt_packet.Properties{{ID: mqtt_packet.PropSessionExpiry, Value: uint32(0)}}})
+		resumed.Close()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), connectTimeout)
+	defer cancel()
+	// A clean start discards the session, which expires when the connection closes
+	if s, err := identitySession(ctx, l, id, name); err == nil {
+		s.Send(&mqtt_packet.Disconnect{})
+		s.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package mqtt_scanner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"mqtt-security-scanner/app/mqtt_packet"
+)
+
+// ReasonCode is a MQTT 5.0 reason code, MQTT 3.1.1 return codes are mapped to their MQTT 5.0 equivalents
+type ReasonCode = mqtt_packet.ReasonCode
+
+// Reason codes the scanners interpret, see MQTT 5.0 section 2.4 Reason Code
+const (
+	ReasonSuccess                  = mqtt_packet.ReasonSuccess
+	ReasonUnspecifiedError         = mqtt_packet.ReasonUnspecifiedError
+	ReasonMalformedPacket          = mqtt_packet.ReasonMalformedPacket
+	ReasonProtocolError            = mqtt_packet.ReasonProtocolError
+	ReasonImplementationError      = mqtt_packet.ReasonImplementationError
+	ReasonUnsupportedProtocol      = mqtt_packet.ReasonUnsupportedProtocol
+	ReasonClientIDNotValid         = mqtt_packet.ReasonClientIDNotValid
+	ReasonBadUsernameOrPassword    = mqtt_packet.ReasonBadUsernameOrPassword
+	ReasonNotAuthorized            = mqtt_packet.ReasonNotAuthorized
+	ReasonServerUnavailable        = mqtt_packet.ReasonServerUnavailable
+	ReasonServerBusy               = mqtt_packet.ReasonServerBusy
+	ReasonBanned                   = mqtt_packet.ReasonBanned
+	ReasonTopicFilterInvalid       = mqtt_packet.ReasonTopicFilterInvalid
+	ReasonTopicNameInvalid         = mqtt_packet.ReasonTopicNameInvalid
+	ReasonPacketTooLarge           = mqtt_packet.ReasonPacketTooLarge
+	ReasonQuotaExceeded            = mqtt_packet.ReasonQuotaExceeded
+	ReasonPayloadFormatInvalid     = mqtt_packet.ReasonPayloadFormatInvalid
+	ReasonConnectionRateExceeded   = mqtt_packet.ReasonConnectionRateExceeded
+	ReasonMaximumConnectTime       = mqtt_packet.ReasonMaximumConnectTime
+	ReasonWildcardSubsNotSupported = mqtt_packet.ReasonWildcardSubsNotSupported
+)
+
+// connackReason returns the reason code of a CONNACK packet received over a raw session of the protocol version
+func connackReason(version, code byte) ReasonCode {
+	return mqtt_packet.ConnackReason(version, code)
+}
+
+// ReasonError is returned when the broker rejects an operation with a reason code
+type ReasonError struct {
+	Packet string     // The packet which carried the reason code, e.g. "CONNACK" or "DISCONNECT"
+	Code   ReasonCode // The reason code, always 0x80 or above
+}
+
+func (e *ReasonError) Error() string {
+	return fmt.Sprintf("%s reason code %s", e.Packet, e.Code)
+}
+
+// errConnectionClosed is returned when the broker closes the connection before acknowledging an operation
+var errConnectionClosed = errors.New("connection closed by the broker")
+
+// reasonCode returns the reason code the broker rejected an operation with
+func reasonCode(err error) (ReasonCode, bool) {
+	var re *ReasonError
+	if errors.As(err, &re) {
+		return re.Code, true
+	}
+	return 0, false
+}
+
+// rejected reports whether the broker rejected an operation with one of the given reason codes,
+// or by closing the connection without a reason code as MQTT 3.1.1 brokers do
+func rejected(err error, codes ...ReasonCode) bool {
+	if errors.Is(err, errConnectionClosed) || errors.Is(err, io.EOF) {
+		return true
+	}
+	code, ok := reasonCode(err)
+	if !ok {
+		return false
+	}
+	for _, c := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
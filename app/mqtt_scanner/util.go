@@ -1,6 +1,7 @@
 package mqtt_scanner
 
 import (
+	"context"
 	"math/rand"
 	"strings"
 	"time"
@@ -29,3 +30,16 @@ func RandomString(length int) string {
 	}
 	return string(b)
 }
+
+// sleep pauses for the given duration, it returns the context error if the context is done earlier
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
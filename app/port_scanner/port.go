@@ -1,30 +1,47 @@
 package port_scanner
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"mqtt-security-scanner/app/scanner"
 	"mqtt-security-scanner/config"
 )
 
 // Known MQTT ports
 var mqttPort = map[int]bool{1883: true, 8883: true, 8083: true, 8084: true, 8443: true}
 
+func init() {
+	scanner.Register(scanner.New(scanner.Spec{
+		ID:          "port.host-scan",
+		Name:        "Host Port Scan",
+		Category:    scanner.CategoryPort,
+		Description: "Scan all TCP ports of the broker and agent hosts for unwanted open ports",
+		Severity:    config.SeverityMedium,
+		Remediation: "Close or firewall the unexpected ports, e.g. bind `dashboard.listeners.http.bind` to an internal address.",
+		References:  []string{"MQTT 3.1.1 section 5.4.8 Other security considerations"},
+		Run:         HostPortScan,
+	}))
+}
+
 // HostPortScan scans the broker for any additional open ports
-func HostPortScan(cfg *config.Config) (*config.ScanItem, error) {
+func HostPortScan(ctx context.Context, cfg *config.Config) (*config.ScanItem, error) {
 	si := config.NewScanItem("Host Port Scan")
-	si.Pass = true
+	si.Outcome = config.OutcomePass
 	scan := func(host string, isBroker bool) {
-		result := PortScanner(host, isBroker)
+		result := PortScanner(ctx, host, isBroker)
 		if len(result) == 0 {
 			return
 		}
 
-		si.Pass = false
+		si.Outcome = config.OutcomeFail
 		for _, port := range result {
 			si.Message = append(si.Message, fmt.Sprintf("TCP port %d in host %s is open", port, host))
+			si.Evidence = append(si.Evidence, net.JoinHostPort(host, strconv.Itoa(port)))
 		}
 	}
 
@@ -35,11 +52,15 @@ func HostPortScan(cfg *config.Config) (*config.ScanItem, error) {
 		scan(host, false)
 	}
 
+	// An interrupted scan has not checked all ports
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	return si, nil
 }
 
-// PortScanner scans the host for open ports
-func PortScanner(host string, isBroker bool) []int {
+// PortScanner scans the host for open ports, it stops early when the context is done
+func PortScanner(ctx context.Context, host string, isBroker bool) []int {
 	numWorkers := 1000
 	ports := make(chan int, numWorkers)
 
@@ -51,14 +72,15 @@ func PortScanner(host string, isBroker bool) []int {
 		go func() {
 			defer wg.Done()
 
+			d := net.Dialer{Timeout: 1 * time.Second}
 			openPorts := []int{}
 			for port := range ports {
 				_, ok := mqttPort[port]
 				if isBroker && ok {
 					continue
 				}
-				address := fmt.Sprintf("%s:%d", host, port)
-				conn, err := net.DialTimeout("tcp", address, 1*time.Second)
+				address := net.JoinHostPort(host, strconv.Itoa(port))
+				conn, err := d.DialContext(ctx, "tcp", address)
 				if err != nil {
 					// the port is closed or filtered.
 					continue
@@ -75,8 +97,13 @@ func PortScanner(host string, isBroker bool) []int {
 		}()
 	}
 
+feed:
 	for port := 1; port <= 65536; port++ {
-		ports <- port
+		select {
+		case ports <- port:
+		case <-ctx.Done():
+			break feed
+		}
 	}
 
 	close(ports)
@@ -0,0 +1,116 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+//go:embed templates/report.html
+var htmlTemplate string
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"code": inlineCode,
+}).Parse(htmlTemplate))
+
+// htmlData is the view model of the html template
+type htmlData struct {
+	Report     *Report
+	Elapsed    time.Duration
+	Broker     config.BrokerInfo
+	ConfigJSON string
+	Total      htmlCount
+	Categories []htmlCount
+	Items      []htmlItem
+}
+
+type htmlCount struct {
+	Name                              string
+	Pass, Fail, Error, Skipped, Total int
+}
+
+type htmlItem struct {
+	Item        *config.ScanItem
+	Description string
+}
+
+// add counts the outcome of a scan item
+func (c *htmlCount) add(o config.Outcome) {
+	c.Total++
+	switch o {
+	case config.OutcomePass:
+		c.Pass++
+	case config.OutcomeFail:
+		c.Fail++
+	case config.OutcomeError:
+		c.Error++
+	case config.OutcomeSkipped:
+		c.Skipped++
+	}
+}
+
+// writeHTML writes a self-contained HTML report with a summary, a broker overview and a section per scan item.
+// Secrets of the configuration are masked.
+func writeHTML(w io.Writer, r *Report) error {
+	redacted := r.Config.Redacted()
+	cfgJSON, err := json.MarshalIndent(redacted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data := htmlData{
+		Report:     r,
+		Elapsed:    r.EndTime.Sub(r.StartTime).Round(time.Millisecond),
+		Broker:     redacted.BrokerInfo,
+		ConfigJSON: string(cfgJSON),
+		Total:      htmlCount{Name: "Total"},
+	}
+
+	categories := make(map[string]*htmlCount)
+	var order []string
+	for _, si := range r.Items {
+		c, ok := categories[si.Category]
+		if !ok {
+			c = &htmlCount{Name: si.Category}
+			categories[si.Category] = c
+			order = append(order, si.Category)
+		}
+		c.add(si.Outcome)
+		data.Total.add(si.Outcome)
+
+		item := htmlItem{Item: si}
+		if s, ok := scanner.Get(si.ID); ok {
+			item.Description = s.Description()
+		}
+		data.Items = append(data.Items, item)
+	}
+	for _, name := range order {
+		data.Categories = append(data.Categories, *categories[name])
+	}
+
+	return htmlReport.Execute(w, data)
+}
+
+// inlineCode escapes the text and renders `backtick` spans as code elements
+func inlineCode(text string) template.HTML {
+	parts := strings.Split(text, "`")
+	var b strings.Builder
+	for i, part := range parts {
+		// Odd parts are enclosed by backticks, an unterminated backtick is kept as text
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString("<code>" + template.HTMLEscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`")
+		}
+		b.WriteString(template.HTMLEscapeString(part))
+	}
+	return template.HTML(b.String())
+}
@@ -0,0 +1,100 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"mqtt-security-scanner/config"
+)
+
+// jsonReport is the machine-readable document written by the json format
+type jsonReport struct {
+	Scan    jsonScan    `json:"scan"`
+	Summary jsonSummary `json:"summary"`
+	Items   []jsonItem  `json:"items"`
+}
+
+type jsonScan struct {
+	Target     string    `json:"target"`
+	Version    string    `json:"version"`
+	ConfigHash string    `json:"config_hash"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+type jsonSummary struct {
+	Total   int `json:"total"`
+	Pass    int `json:"pass"`
+	Fail    int `json:"fail"`
+	Error   int `json:"error"`
+	Skipped int `json:"skipped"`
+}
+
+type jsonItem struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Listener    string          `json:"listener,omitempty"`
+	Protocol    string          `json:"protocol,omitempty"`
+	Category    string          `json:"category"`
+	Severity    config.Severity `json:"severity"`
+	Outcome     config.Outcome  `json:"outcome"`
+	Error       string          `json:"error,omitempty"`
+	Messages    []string        `json:"messages"`
+	Evidence    []string        `json:"evidence"`
+	Remediation string          `json:"remediation"`
+	References  []string        `json:"references"`
+	DurationMS  int64           `json:"duration_ms"`
+}
+
+// writeJSON writes the report as an indented JSON document
+func writeJSON(w io.Writer, r *Report) error {
+	doc := jsonReport{
+		Scan: jsonScan{
+			Target:     r.Target,
+			Version:    r.Version,
+			ConfigHash: r.ConfigHash,
+			StartTime:  r.StartTime,
+			EndTime:    r.EndTime,
+			DurationMS: r.EndTime.Sub(r.StartTime).Milliseconds(),
+		},
+		Summary: jsonSummary{
+			Total:   len(r.Items),
+			Pass:    r.Count(config.OutcomePass),
+			Fail:    r.Count(config.OutcomeFail),
+			Error:   r.Count(config.OutcomeError),
+			Skipped: r.Count(config.OutcomeSkipped),
+		},
+		Items: make([]jsonItem, 0, len(r.Items)),
+	}
+	for _, si := range r.Items {
+		doc.Items = append(doc.Items, jsonItem{
+			ID:          si.ID,
+			Name:        si.Name,
+			Listener:    si.Listener,
+			Protocol:    si.Protocol,
+			Category:    si.Category,
+			Severity:    si.Severity,
+			Outcome:     si.Outcome,
+			Error:       si.Error,
+			Messages:    nonNil(si.Message),
+			Evidence:    nonNil(si.Evidence),
+			Remediation: si.Remediation,
+			References:  nonNil(si.References),
+			DurationMS:  si.Duration.Milliseconds(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// nonNil returns an empty slice for nil, so that it is encoded as [] instead of null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
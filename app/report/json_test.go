@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	r := testReport()
+	r.ConfigHash = "0123456789abcdef"
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Scan struct {
+			Target     string    `json:"target"`
+			Version    string    `json:"version"`
+			ConfigHash string    `json:"config_hash"`
+			StartTime  time.Time `json:"start_time"`
+			EndTime    time.Time `json:"end_time"`
+			DurationMS int64     `json:"duration_ms"`
+		} `json:"scan"`
+		Summary map[string]int `json:"summary"`
+		Items   []struct {
+			ID          string   `json:"id"`
+			Name        string   `json:"name"`
+			Listener    string   `json:"listener"`
+			Protocol    string   `json:"protocol"`
+			Category    string   `json:"category"`
+			Severity    string   `json:"severity"`
+			Outcome     string   `json:"outcome"`
+			Error       string   `json:"error"`
+			Messages    []string `json:"messages"`
+			Evidence    []string `json:"evidence"`
+			Remediation string   `json:"remediation"`
+			References  []string `json:"references"`
+			DurationMS  int64    `json:"duration_ms"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := doc.Scan
+	if scan.Target != "broker.example.com" || scan.Version != "test" || scan.ConfigHash != "0123456789abcdef" {
+		t.Errorf("scan target %s version %s config hash %s", scan.Target, scan.Version, scan.ConfigHash)
+	}
+	if !scan.StartTime.Equal(r.StartTime) || !scan.EndTime.Equal(r.EndTime) || scan.DurationMS != 3000 {
+		t.Errorf("scan from %v to %v in %d ms, want %v to %v in 3000 ms", scan.StartTime, scan.EndTime, scan.DurationMS, r.StartTime, r.EndTime)
+	}
+	wantSummary := map[string]int{"total": 4, "pass": 1, "fail": 1, "error": 1, "skipped": 1}
+	if !reflect.DeepEqual(doc.Summary, wantSummary) {
+		t.Errorf("summary %v, want %v", doc.Summary, wantSummary)
+	}
+
+	// The items keep the order of the report
+	if len(doc.Items) != 4 {
+		t.Fatalf("%d items, want 4", len(doc.Items))
+	}
+	for i, want := range []struct{ id, outcome string }{
+		{"client.connect", "pass"}, {"message.acl", "fail"}, {"message.sys", "error"}, {"client.flapping", "skipped"},
+	} {
+		if item := doc.Items[i]; item.ID != want.id || item.Outcome != want.outcome {
+			t.Errorf("item %d = %s %s, want %s %s", i, item.ID, item.Outcome, want.id, want.outcome)
+		}
+	}
+
+	fail := doc.Items[1]
+	if fail.Listener != "tcp://broker.example.com:1883" || fail.Protocol != "3.1.1" || fail.Severity != "high" || fail.DurationMS != 1500 {
+		t.Errorf("failed item listener %s protocol %s severity %s duration %d ms", fail.Listener, fail.Protocol, fail.Severity, fail.DurationMS)
+	}
+	if len(fail.Messages) != 1 || len(fail.Evidence) != 1 || fail.Remediation == "" {
+		t.Errorf("failed item messages %q evidence %q remediation %q", fail.Messages, fail.Evidence, fail.Remediation)
+	}
+	if errored := doc.Items[2]; errored.Error != "scanner timed out after 2m0s" {
+		t.Errorf("errored item error %q", errored.Error)
+	}
+	if skipped := doc.Items[3]; skipped.Error != "limit.flapping is not set" {
+		t.Errorf("skipped item reason %q", skipped.Error)
+	}
+
+	// Empty lists are encoded as [] rather than null
+	if bytes.Contains(buf.Bytes(), []byte("null")) {
+		t.Errorf("report contains null:\n%s", buf.String())
+	}
+}
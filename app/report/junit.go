@@ -0,0 +1,153 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"mqtt-security-scanner/config"
+)
+
+// The JUnit XML schema understood by common CI systems
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Hostname   string          `xml:"hostname,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitSkipped `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes one test suite per scanner category and one test case per scan item
+func writeJUnit(w io.Writer, r *Report) error {
+	doc := junitTestSuites{
+		Name: toolName,
+		Time: seconds(r.EndTime.Sub(r.StartTime)),
+	}
+
+	suites := make(map[string]*junitTestSuite)
+	var order []string
+	for _, si := range r.Items {
+		suite, ok := suites[si.Category]
+		if !ok {
+			suite = &junitTestSuite{
+				Name:      si.Category,
+				Timestamp: r.StartTime.UTC().Format("2006-01-02T15:04:05"),
+				Hostname:  r.Target,
+				Properties: []junitProperty{
+					{Name: "target", Value: r.Target},
+					{Name: "version", Value: r.Version},
+					{Name: "config_hash", Value: r.ConfigHash},
+				},
+			}
+			suites[si.Category] = suite
+			order = append(order, si.Category)
+		}
+
+		tc := junitTestCase{
+			Name:      title(si),
+			ClassName: toolName + "." + si.ID,
+			Time:      seconds(si.Duration),
+		}
+		switch si.Outcome {
+		case config.OutcomeFail:
+			text := strings.Join(si.Message, "\n")
+			if si.Remediation != "" {
+				text += "\n\nRemediation: " + si.Remediation
+			}
+			tc.Failure = &junitProblem{
+				Message: strings.Join(si.Message, ", "),
+				Type:    string(si.Severity),
+				Text:    text,
+			}
+			suite.Failures++
+		case config.OutcomeError:
+			tc.Error = &junitProblem{Message: si.Error, Type: string(si.Outcome), Text: si.Error}
+			suite.Errors++
+		case config.OutcomeSkipped:
+			tc.Skipped = &junitSkipped{Message: si.Error}
+			suite.Skipped++
+		}
+		if len(si.Evidence) > 0 {
+			tc.SystemOut = strings.Join(si.Evidence, "\n")
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for _, name := range order {
+		suite := suites[name]
+		var elapsed time.Duration
+		for _, si := range r.Items {
+			if si.Category == name {
+				elapsed += si.Duration
+			}
+		}
+		suite.Time = seconds(elapsed)
+
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Errors += suite.Errors
+		doc.Skipped += suite.Skipped
+		doc.Suites = append(doc.Suites, *suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// seconds formats a duration as seconds with millisecond precision
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,101 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Error("report without XML header")
+	}
+
+	type problem struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type counts struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Errors   int `xml:"errors,attr"`
+		Skipped  int `xml:"skipped,attr"`
+	}
+	var doc struct {
+		XMLName xml.Name `xml:"testsuites"`
+		counts
+		Suites []struct {
+			Name string `xml:"name,attr"`
+			Time string `xml:"time,attr"`
+			counts
+			Properties []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value,attr"`
+			} `xml:"properties>property"`
+			Cases []struct {
+				Name      string   `xml:"name,attr"`
+				ClassName string   `xml:"classname,attr"`
+				Failure   *problem `xml:"failure"`
+				Error     *problem `xml:"error"`
+				Skipped   *problem `xml:"skipped"`
+				SystemOut string   `xml:"system-out"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (counts{Tests: 4, Failures: 1, Errors: 1, Skipped: 1}); doc.counts != want {
+		t.Errorf("testsuites counts %+v, want %+v", doc.counts, want)
+	}
+	// One suite per category in the order of the items
+	if len(doc.Suites) != 2 || doc.Suites[0].Name != "client" || doc.Suites[1].Name != "message" {
+		t.Fatalf("suites %+v, want client and message", doc.Suites)
+	}
+	client, message := doc.Suites[0], doc.Suites[1]
+	if want := (counts{Tests: 2, Skipped: 1}); client.counts != want {
+		t.Errorf("client suite counts %+v, want %+v", client.counts, want)
+	}
+	if want := (counts{Tests: 2, Failures: 1, Errors: 1}); message.counts != want {
+		t.Errorf("message suite counts %+v, want %+v", message.counts, want)
+	}
+	if message.Time != "1.500" {
+		t.Errorf("message suite time %s, want 1.500", message.Time)
+	}
+	if len(client.Properties) == 0 || client.Properties[0].Name != "target" || client.Properties[0].Value != "broker.example.com" {
+		t.Errorf("client suite properties %+v", client.Properties)
+	}
+
+	// Passed cases have no element, the others the element of their outcome only
+	pass, skipped := client.Cases[0], client.Cases[1]
+	if pass.Failure != nil || pass.Error != nil || pass.Skipped != nil {
+		t.Errorf("passed case %+v has an outcome element", pass)
+	}
+	if skipped.Skipped == nil || skipped.Skipped.Message != "limit.flapping is not set" || skipped.Failure != nil || skipped.Error != nil {
+		t.Errorf("skipped case %+v, want <skipped> with the reason", skipped)
+	}
+
+	fail, errored := message.Cases[0], message.Cases[1]
+	if fail.Name != "MQTT ACL Matrix (tcp://broker.example.com:1883 MQTT 3.1.1)" || fail.ClassName != "mqtt-security-scanner.message.acl" {
+		t.Errorf("failed case name %q classname %q", fail.Name, fail.ClassName)
+	}
+	if fail.Failure == nil || fail.Error != nil || fail.Skipped != nil {
+		t.Fatalf("failed case %+v, want <failure> only", fail)
+	}
+	if fail.Failure.Type != "high" || !strings.Contains(fail.Failure.Text, "Remediation: ") {
+		t.Errorf("failure type %q text %q, want the severity and remediation", fail.Failure.Type, fail.Failure.Text)
+	}
+	if !strings.Contains(fail.SystemOut, "SUBACK reason code 0x00 Success") {
+		t.Errorf("failed case system-out %q, want the evidence", fail.SystemOut)
+	}
+	if errored.Error == nil || errored.Error.Message != "scanner timed out after 2m0s" || errored.Failure != nil || errored.Skipped != nil {
+		t.Errorf("errored case %+v, want <error> with the error", errored)
+	}
+}
@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"mqtt-security-scanner/config"
+)
+
+// Report is the result model of a scan, all output formats are generated from it
+type Report struct {
+	Target     string             // The scanned broker host
+	Version    string             // Version of the scanner tool
+	ConfigHash string             // SHA-256 of the configuration file
+	StartTime  time.Time          // Start time of the scan
+	EndTime    time.Time          // End time of the scan
+	Items      []*config.ScanItem // Results of the executed scanners
+	Config     *config.Config     // Configuration of the scan
+}
+
+// New returns a report of the scan items for the given configuration
+func New(cfg *config.Config, version string, start, end time.Time, items []*config.ScanItem) *Report {
+	return &Report{
+		Target:     cfg.BrokerInfo.Host,
+		Version:    version,
+		ConfigHash: cfg.Hash(),
+		StartTime:  start,
+		EndTime:    end,
+		Items:      items,
+		Config:     cfg,
+	}
+}
+
+// Count returns the number of scan items with the given outcome
+func (r *Report) Count(outcome config.Outcome) int {
+	n := 0
+	for _, si := range r.Items {
+		if si.Outcome == outcome {
+			n++
+		}
+	}
+	return n
+}
+
+// title returns the name of the scan item qualified with its listener and protocol version
+func title(si *config.ScanItem) string {
+	if si.Listener == "" {
+		return si.Name
+	}
+	if si.Protocol == "" {
+		return fmt.Sprintf("%s (%s)", si.Name, si.Listener)
+	}
+	return fmt.Sprintf("%s (%s MQTT %s)", si.Name, si.Listener, si.Protocol)
+}
+
+// FormatFunc writes the report in a specific format
+type FormatFunc func(w io.Writer, r *Report) error
+
+var formats = map[string]FormatFunc{
+	"text":  writeText,
+	"json":  writeJSON,
+	"sarif": writeSARIF,
+	"junit": writeJUnit,
+	"html":  writeHTML,
+}
+
+// Supported reports whether the format is a supported output format
+func Supported(format string) bool {
+	_, ok := formats[format]
+	return ok
+}
+
+// Formats returns the names of the supported output formats
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write writes the report in the given format to the file at path, or to stdout if path is empty
+func Write(r *Report, format, path string) error {
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unsupported report format %s", format)
+	}
+
+	var buf bytes.Buffer
+	if err := f(&buf, r); err != nil {
+		return err
+	}
+
+	if path == "" {
+		_, err := buf.WriteTo(os.Stdout)
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create scan result file, %v", err)
+	}
+	defer file.Close()
+
+	if _, err = buf.WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write scan result, %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+// The scanners of the testReport items, which the sarif rules and html descriptions are built from
+func init() {
+	for _, spec := range []scanner.Spec{
+		{ID: "client.connect", Name: "MQTT Client Connect", Category: "client", Severity: config.SeverityInfo},
+		{ID: "client.flapping", Name: "MQTT Client Flapping", Category: "client", Severity: config.SeverityLow},
+		{ID: "message.acl", Name: "MQTT ACL Matrix", Category: "message", Severity: config.SeverityHigh,
+			Description: "Check the ACL matrix", Remediation: "Add the rules of the matrix to `authorization.sources`."},
+		{ID: "message.sys", Name: "MQTT System Topic Exposure", Category: "message", Severity: config.SeverityMedium},
+	} {
+		spec.Run = func(context.Context, *config.Config) (*config.ScanItem, error) { return nil, nil }
+		scanner.Register(scanner.New(spec))
+	}
+}
+
+// testPasswords are the secrets of testReport, which no output format may contain
+var testPasswords = []string{"broker-secret-1", "observer-secret-2", "tenant-a-secret-3", "tenant-b-secret-4"}
+
+func testReport() *Report {
+	cfg := &config.Config{
+		BrokerInfo: config.BrokerInfo{Host: "broker.example.com", MQTTPort: 1883, Username: "admin", Password: testPasswords[0]},
+		ACL: config.ACL{
+			Observer: config.Identity{Name: "observer", Username: "observer", Password: testPasswords[1]},
+			Identities: []config.Identity{
+				{Name: "tenant-a", Username: "a", Password: testPasswords[2]},
+				{Name: "tenant-b", Username: "b", Password: testPasswords[3]},
+			},
+			Rules: []config.ACLRule{{Identity: "tenant-a", Topic: "tenants/b/state", Action: config.ActionSubscribe}},
+		},
+	}
+
+	pass := config.NewScanItem("MQTT Client Connect")
+	pass.ID, pass.Category, pass.Severity = "client.connect", "client", config.SeverityInfo
+	pass.SetPass(true)
+	fail := config.NewScanItem("MQTT ACL Matrix")
+	fail.ID, fail.Category, fail.Severity = "message.acl", "message", config.SeverityHigh
+	fail.Listener, fail.Protocol = "tcp://broker.example.com:1883", config.ProtocolMQTT311
+	fail.Message = append(fail.Message, "tenant-a can subscribe tenants/b/state although it is denied")
+	fail.Evidence = append(fail.Evidence, "tenant-a subscribe tenants/b/state (expected deny): SUBACK reason code 0x00 Success")
+	fail.Remediation = "Add the rules of the matrix to `authorization.sources`."
+	fail.Duration = 1500 * time.Millisecond
+	errored := config.NewErrorItem("MQTT System Topic Exposure", errors.New("scanner timed out after 2m0s"))
+	errored.ID, errored.Category, errored.Severity = "message.sys", "message", config.SeverityMedium
+	skipped := config.NewSkippedItem("MQTT Client Flapping", "limit.flapping is not set")
+	skipped.ID, skipped.Category, skipped.Severity = "client.flapping", "client", config.SeverityLow
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	return New(cfg, "test", start, start.Add(3*time.Second), []*config.ScanItem{pass, fail, errored, skipped})
+}
+
+func TestSecretsRedacted(t *testing.T) {
+	for _, format := range Formats() {
+		t.Run(format, func(t *testing.T) {
+			r := testReport()
+			var buf bytes.Buffer
+			if err := formats[format](&buf, r); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() == 0 {
+				t.Fatal("empty report")
+			}
+			for _, password := range testPasswords {
+				if strings.Contains(buf.String(), password) {
+					t.Errorf("report contains the password %s", password)
+				}
+			}
+
+			// Rendering must not mask the secrets of the configuration itself
+			if r.Config.BrokerInfo.Password != testPasswords[0] || r.Config.ACL.Identities[0].Password != testPasswords[2] {
+				t.Error("rendering the report modified the configuration")
+			}
+		})
+	}
+}
+
+func TestHTMLConfigMasked(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeHTML(&buf, testReport()); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration is included with its secrets masked rather than left out
+	if !strings.Contains(buf.String(), "broker.example.com") || !strings.Contains(buf.String(), "******") {
+		t.Error("HTML report does not include the masked configuration")
+	}
+}
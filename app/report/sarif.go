@@ -0,0 +1,252 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mqtt-security-scanner/app/scanner"
+	"mqtt-security-scanner/config"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "mqtt-security-scanner"
+	toolURI      = "https://github.com/emqx/mqtt-security-scanner"
+)
+
+// The subset of the SARIF 2.1.0 object model written by the sarif format
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	Help                 sarifMessage       `json:"help"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifProperties    `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifProperties struct {
+	Tags             []string `json:"tags,omitempty"`
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	StartTimeUTC               string              `json:"startTimeUtc"`
+	EndTimeUTC                 string              `json:"endTimeUtc"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level          string             `json:"level"`
+	Message        sarifMessage       `json:"message"`
+	AssociatedRule sarifDescriptorRef `json:"associatedRule"` // The rule of the scanner which failed to execute
+}
+
+// sarifDescriptorRef is a reportingDescriptorReference to a rule of the driver, the index is -1 if it is unknown
+type sarifDescriptorRef struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// writeSARIF writes the failed scan items as SARIF 2.1.0 results, with one rule per registered scanner.
+// Scanners which failed to execute are reported as tool execution notifications.
+func writeSARIF(w io.Writer, r *Report) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           toolName,
+			Version:        r.Version,
+			InformationURI: toolURI,
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	ruleIndex := make(map[string]int)
+	for _, s := range scanner.All() {
+		ruleIndex[s.ID()] = len(run.Tool.Driver.Rules)
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:                   s.ID(),
+			Name:                 ruleName(s.Name()),
+			ShortDescription:     sarifMessage{Text: s.Name()},
+			FullDescription:      sarifMessage{Text: s.Description()},
+			Help:                 sarifMessage{Text: ruleHelp(s)},
+			DefaultConfiguration: sarifConfiguration{Level: sarifLevel(s.Severity())},
+			Properties: sarifProperties{
+				Tags:             []string{"security", s.Category(), string(s.Severity())},
+				SecuritySeverity: securitySeverity[s.Severity()],
+			},
+		})
+	}
+
+	invocation := sarifInvocation{
+		ExecutionSuccessful: true,
+		StartTimeUTC:        r.StartTime.UTC().Format(time.RFC3339),
+		EndTimeUTC:          r.EndTime.UTC().Format(time.RFC3339),
+	}
+	for _, si := range r.Items {
+		switch si.Outcome {
+		case config.OutcomeError:
+			index, ok := ruleIndex[si.ID]
+			if !ok {
+				index = -1
+			}
+			invocation.ExecutionSuccessful = false
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarifNotification{
+				Level:          "error",
+				Message:        sarifMessage{Text: fmt.Sprintf("%s failed to execute: %s", title(si), si.Error)},
+				AssociatedRule: sarifDescriptorRef{ID: si.ID, Index: index},
+			})
+		case config.OutcomeFail:
+			message := strings.Join(si.Message, "; ")
+			if message == "" {
+				message = fmt.Sprintf("%s did not pass", title(si))
+			}
+			// Scan items of scanners running per listener are located at their listener
+			// and told apart by the protocol version they ran with
+			uri := si.Listener
+			if uri == "" {
+				uri = endpoint(r.Config)
+			}
+			identity := []string{si.ID, uri}
+			if si.Protocol != "" {
+				identity = append(identity, si.Protocol)
+				message = fmt.Sprintf("MQTT %s: %s", si.Protocol, message)
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    si.ID,
+				RuleIndex: ruleIndex[si.ID],
+				Level:     sarifLevel(si.Severity),
+				Message:   sarifMessage{Text: message},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: uri},
+				}}},
+				PartialFingerprints: map[string]string{
+					"mqttScanItem/v1": fingerprint(identity...),
+				},
+			})
+		}
+	}
+	run.Invocations = []sarifInvocation{invocation}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{run},
+	})
+}
+
+// securitySeverity maps severities to the CVSS-like scores code scanning dashboards use to rank rules
+var securitySeverity = map[config.Severity]string{
+	config.SeverityInfo:     "0.0",
+	config.SeverityLow:      "3.0",
+	config.SeverityMedium:   "5.5",
+	config.SeverityHigh:     "8.0",
+	config.SeverityCritical: "9.5",
+}
+
+// sarifLevel maps a severity to a SARIF result level
+func sarifLevel(severity config.Severity) string {
+	switch {
+	case severity.AtLeast(config.SeverityHigh):
+		return "error"
+	case severity.AtLeast(config.SeverityMedium):
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// ruleHelp returns the help text of a rule with the description, remediation and references of the scanner
+func ruleHelp(s scanner.Scanner) string {
+	help := s.Description()
+	if s.Remediation() != "" {
+		help += "\n\nRemediation: " + s.Remediation()
+	}
+	if len(s.References()) > 0 {
+		help += "\n\nReferences:\n- " + strings.Join(s.References(), "\n- ")
+	}
+	return help
+}
+
+// endpoint returns the URI of the scanned broker listener
+func endpoint(cfg *config.Config) string {
+	return "mqtt://" + net.JoinHostPort(cfg.BrokerInfo.Host, strconv.Itoa(cfg.BrokerInfo.MQTTPort))
+}
+
+// ruleName converts a scanner name to the PascalCase form SARIF recommends for rule names
+func ruleName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Fields(name) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// fingerprint returns a stable identifier of a finding, used by SARIF consumers to track it across scans
+func fingerprint(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
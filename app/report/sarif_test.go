@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// The SARIF 2.1.0 properties the test checks, decoded independently of the types the report is written with
+type testSARIF struct {
+	Version string `json:"version"`
+	Runs    []struct {
+		Tool struct {
+			Driver struct {
+				Name  string `json:"name"`
+				Rules []struct {
+					ID                   string `json:"id"`
+					Name                 string `json:"name"`
+					DefaultConfiguration struct {
+						Level string `json:"level"`
+					} `json:"defaultConfiguration"`
+				} `json:"rules"`
+			} `json:"driver"`
+		} `json:"tool"`
+		Invocations []struct {
+			ExecutionSuccessful        bool `json:"executionSuccessful"`
+			ToolExecutionNotifications []struct {
+				Level          string          `json:"level"`
+				Descriptor     json.RawMessage `json:"descriptor"`
+				AssociatedRule struct {
+					ID    string `json:"id"`
+					Index int    `json:"index"`
+				} `json:"associatedRule"`
+			} `json:"toolExecutionNotifications"`
+		} `json:"invocations"`
+		Results []struct {
+			RuleID    string `json:"ruleId"`
+			RuleIndex int    `json:"ruleIndex"`
+			Level     string `json:"level"`
+			Message   struct {
+				Text string `json:"text"`
+			} `json:"message"`
+			Locations []struct {
+				PhysicalLocation struct {
+					ArtifactLocation struct {
+						URI string `json:"uri"`
+					} `json:"artifactLocation"`
+				} `json:"physicalLocation"`
+			} `json:"locations"`
+			PartialFingerprints map[string]string `json:"partialFingerprints"`
+		} `json:"results"`
+	} `json:"runs"`
+}
+
+func TestSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, testReport()); err != nil {
+		t.Fatal(err)
+	}
+	var doc testSARIF
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.1.0" || len(doc.Runs) != 1 {
+		t.Fatalf("version %s with %d runs, want 2.1.0 with 1 run", doc.Version, len(doc.Runs))
+	}
+	run := doc.Runs[0]
+
+	// One rule per registered scanner, sorted by ID
+	rules := run.Tool.Driver.Rules
+	wantRules := []struct{ id, name, level string }{
+		{"client.connect", "MQTTClientConnect", "note"},
+		{"client.flapping", "MQTTClientFlapping", "note"},
+		{"message.acl", "MQTTACLMatrix", "error"},
+		{"message.sys", "MQTTSystemTopicExposure", "warning"},
+	}
+	if len(rules) != len(wantRules) {
+		t.Fatalf("%d rules, want %d", len(rules), len(wantRules))
+	}
+	for i, want := range wantRules {
+		if r := rules[i]; r.ID != want.id || r.Name != want.name || r.DefaultConfiguration.Level != want.level {
+			t.Errorf("rule %d = %s %s %s, want %s %s %s", i, r.ID, r.Name, r.DefaultConfiguration.Level, want.id, want.name, want.level)
+		}
+	}
+
+	// Only the failed item is a result, located at its listener
+	if len(run.Results) != 1 {
+		t.Fatalf("%d results, want 1", len(run.Results))
+	}
+	res := run.Results[0]
+	if res.RuleID != "message.acl" || res.RuleIndex != 2 || res.Level != "error" {
+		t.Errorf("result rule %s index %d level %s, want message.acl index 2 level error", res.RuleID, res.RuleIndex, res.Level)
+	}
+	if want := "MQTT 3.1.1: tenant-a can subscribe tenants/b/state although it is denied"; res.Message.Text != want {
+		t.Errorf("result message %q, want %q", res.Message.Text, want)
+	}
+	if len(res.Locations) != 1 || res.Locations[0].PhysicalLocation.ArtifactLocation.URI != "tcp://broker.example.com:1883" {
+		t.Errorf("result locations %+v, want the listener", res.Locations)
+	}
+	if res.PartialFingerprints["mqttScanItem/v1"] == "" {
+		t.Error("result without fingerprint")
+	}
+
+	// The errored scanner is a notification associated with its rule
+	if len(run.Invocations) != 1 {
+		t.Fatalf("%d invocations, want 1", len(run.Invocations))
+	}
+	inv := run.Invocations[0]
+	if inv.ExecutionSuccessful {
+		t.Error("execution successful although a scanner failed to execute")
+	}
+	if len(inv.ToolExecutionNotifications) != 1 {
+		t.Fatalf("%d notifications, want 1", len(inv.ToolExecutionNotifications))
+	}
+	n := inv.ToolExecutionNotifications[0]
+	if n.Level != "error" || n.AssociatedRule.ID != "message.sys" || n.AssociatedRule.Index != 3 {
+		t.Errorf("notification %s associated with %s index %d, want error associated with message.sys index 3", n.Level, n.AssociatedRule.ID, n.AssociatedRule.Index)
+	}
+	if n.Descriptor != nil {
+		t.Errorf("notification descriptor %s, the rule belongs in associatedRule", n.Descriptor)
+	}
+}
@@ -0,0 +1,30 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"mqtt-security-scanner/config"
+)
+
+// writeText writes one tab-separated line per scan item
+func writeText(w io.Writer, r *Report) error {
+	for _, si := range r.Items {
+		var err error
+		switch si.Outcome {
+		case config.OutcomePass:
+			_, err = fmt.Fprintf(w, "[%s]\t pass\n", title(si))
+		case config.OutcomeSkipped:
+			_, err = fmt.Fprintf(w, "[%s]\t skipped: %s\n", title(si), si.Error)
+		case config.OutcomeError:
+			_, err = fmt.Fprintf(w, "[%s]\t error: %s\n", title(si), si.Error)
+		default:
+			_, err = fmt.Fprintf(w, "[%s]\t do not pass (%s): %s\n", title(si), si.Severity, strings.Join(si.Message, ", "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"mqtt-security-scanner/config"
+)
+
+// Phase orders the execution of scanners, all scanners of a phase complete before the next phase starts
+// The zero value is not a valid phase, scanners built by New without a phase run in PhaseDefault
+type Phase int
+
+const (
+	PhasePreflight  Phase = iota + 1 // Checks other scanners depend on, e.g. authenticated connect
+	PhaseDefault                     // Regular checks
+	PhaseDisruptive                  // Checks which change the broker state for other scanners, e.g. load tests and bans
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhasePreflight:
+		return "preflight"
+	case PhaseDefault:
+		return "default"
+	case PhaseDisruptive:
+		return "disruptive"
+	default:
+		return fmt.Sprintf("phase-%d", int(p))
+	}
+}
+
+// Engine runs scanners according to their phases, dependencies and exclusivity
+type Engine struct {
+	Config   *config.Config
+	OnStart  func(s Scanner)                      // Optional, called before a scanner starts
+	OnFinish func(s Scanner, si *config.ScanItem) // Optional, called with the result of every scanner, including skipped ones
+}
+
+// Run executes the scanners and returns their scan items in the order of the given scanners,
+// scanners which run per listener are expanded to one instance and scan item per enabled listener.
+// Within a phase, scanners whose prerequisites have completed run concurrently, exclusive scanners run alone afterwards.
+// A scanner whose prerequisite did not pass is skipped. Prerequisites which are not part of the run are ignored.
+func (e *Engine) Run(ctx context.Context, scanners []Scanner) []*config.ScanItem {
+	var instances []Scanner
+	byID := make(map[string][]Scanner, len(scanners))
+	for _, s := range scanners {
+		expanded := s.Expand(e.Config)
+		instances = append(instances, expanded...)
+		byID[s.ID()] = expanded
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]*config.ScanItem, len(instances))
+	)
+	finish := func(s Scanner, si *config.ScanItem) {
+		describe(s, si)
+		mu.Lock()
+		results[Key(s)] = si
+		mu.Unlock()
+		if e.OnFinish != nil {
+			e.OnFinish(s, si)
+		}
+	}
+	run := func(s Scanner) {
+		if e.OnStart != nil && s.Skip(e.Config) == "" && ctx.Err() == nil {
+			e.OnStart(s)
+		}
+		finish(s, Execute(ctx, e.Config, s))
+	}
+
+	for _, phase := range phases(instances) {
+		pending := phase
+		for len(pending) > 0 {
+			// Pick the scanners whose prerequisites have all completed
+			var ready, waiting []Scanner
+			for _, s := range pending {
+				if resolved(s, byID, results) {
+					ready = append(ready, s)
+				} else {
+					waiting = append(waiting, s)
+				}
+			}
+			if len(ready) == 0 {
+				for _, s := range waiting {
+					finish(s, config.NewErrorItem(s.Name(), fmt.Errorf("unresolvable prerequisites %s", strings.Join(s.Requires(), ", "))))
+				}
+				break
+			}
+			pending = waiting
+
+			var concurrent, exclusive []Scanner
+			for _, s := range ready {
+				if reason := blocked(s, byID, results); reason != "" {
+					finish(s, config.NewSkippedItem(s.Name(), reason))
+					continue
+				}
+				if s.Exclusive() {
+					exclusive = append(exclusive, s)
+				} else {
+					concurrent = append(concurrent, s)
+				}
+			}
+
+			var wg sync.WaitGroup
+			wg.Add(len(concurrent))
+			for _, s := range concurrent {
+				go func(s Scanner) {
+					defer wg.Done()
+					run(s)
+				}(s)
+			}
+			wg.Wait()
+
+			for _, s := range exclusive {
+				run(s)
+			}
+		}
+	}
+
+	items := make([]*config.ScanItem, 0, len(instances))
+	for _, s := range instances {
+		items = append(items, results[Key(s)])
+	}
+	return items
+}
+
+// prerequisites returns the instances of the prerequisite scanner with the given ID which the scanner waits for.
+// A scanner running per listener only waits for the prerequisite instance of the same listener if there is one.
+func prerequisites(s Scanner, id string, byID map[string][]Scanner) []Scanner {
+	all := byID[id]
+	if s.Listener() == nil {
+		return all
+	}
+	for _, p := range all {
+		if p.Listener() != nil && *p.Listener() == *s.Listener() {
+			return []Scanner{p}
+		}
+	}
+	return all
+}
+
+// resolved reports whether all selected prerequisites of the scanner have a result
+func resolved(s Scanner, byID map[string][]Scanner, results map[string]*config.ScanItem) bool {
+	for _, id := range s.Requires() {
+		for _, p := range prerequisites(s, id, byID) {
+			if _, ok := results[Key(p)]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// blocked returns the reason why the scanner cannot run because of a prerequisite, empty if it can run
+func blocked(s Scanner, byID map[string][]Scanner, results map[string]*config.ScanItem) string {
+	for _, id := range s.Requires() {
+		for _, p := range prerequisites(s, id, byID) {
+			if si := results[Key(p)]; si.Outcome != config.OutcomePass {
+				return fmt.Sprintf("Prerequisite %s did not pass (%s)", Key(p), si.Outcome)
+			}
+		}
+	}
+	return ""
+}
+
+// phases groups the scanners by phase in execution order
+func phases(scanners []Scanner) [][]Scanner {
+	byPhase := make(map[Phase][]Scanner)
+	var order []Phase
+	for _, s := range scanners {
+		if _, ok := byPhase[s.Phase()]; !ok {
+			order = append(order, s.Phase())
+		}
+		byPhase[s.Phase()] = append(byPhase[s.Phase()], s)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	groups := make([][]Scanner, 0, len(order))
+	for _, p := range order {
+		groups = append(groups, byPhase[p])
+	}
+	return groups
+}
@@ -0,0 +1,180 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"mqtt-security-scanner/config"
+)
+
+// recorder records the start and end of the stub scanners and how many run at the same time
+type recorder struct {
+	mu      sync.Mutex
+	events  []string
+	running int
+	peak    map[string]int // Most scanners running at the same time while the scanner ran
+}
+
+func newRecorder() *recorder {
+	return &recorder{peak: make(map[string]int)}
+}
+
+// stub returns a scanner which passes, fails or errors as given, and holds for the duration
+func (r *recorder) stub(spec Spec, outcome config.Outcome, hold time.Duration) Scanner {
+	spec.Name = spec.ID
+	spec.Run = func(ctx context.Context, cfg *config.Config) (*config.ScanItem, error) {
+		r.mu.Lock()
+		r.events = append(r.events, "start "+spec.ID)
+		r.running++
+		r.mu.Unlock()
+
+		deadline := time.Now().Add(hold)
+		for time.Now().Before(deadline) {
+			r.mu.Lock()
+			r.peak[spec.ID] = max(r.peak[spec.ID], r.running)
+			r.mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+
+		r.mu.Lock()
+		r.peak[spec.ID] = max(r.peak[spec.ID], r.running)
+		r.running--
+		r.events = append(r.events, "end "+spec.ID)
+		r.mu.Unlock()
+
+		if outcome == config.OutcomeError {
+			return nil, errors.New("stub error")
+		}
+		si := config.NewScanItem(spec.ID)
+		si.SetPass(outcome == config.OutcomePass)
+		return si, nil
+	}
+	return New(spec)
+}
+
+// index returns the index of the event, -1 if it was not recorded
+func (r *recorder) index(event string) int {
+	return slices.Index(r.events, event)
+}
+
+func TestEnginePhases(t *testing.T) {
+	rec := newRecorder()
+	scanners := []Scanner{
+		rec.stub(Spec{ID: "disruptive", Phase: PhaseDisruptive}, config.OutcomePass, 0),
+		rec.stub(Spec{ID: "default-1"}, config.OutcomePass, 50*time.Millisecond),
+		rec.stub(Spec{ID: "preflight", Phase: PhasePreflight}, config.OutcomePass, 50*time.Millisecond),
+		rec.stub(Spec{ID: "default-2"}, config.OutcomePass, 50*time.Millisecond),
+	}
+
+	items := (&Engine{Config: &config.Config{}}).Run(context.Background(), scanners)
+
+	// Every phase completes before the next one starts
+	for _, order := range [][2]string{
+		{"end preflight", "start default-1"},
+		{"end preflight", "start default-2"},
+		{"end default-1", "start disruptive"},
+		{"end default-2", "start disruptive"},
+	} {
+		if before, after := rec.index(order[0]), rec.index(order[1]); before < 0 || after < 0 || before > after {
+			t.Errorf("%s is not before %s: %q", order[0], order[1], rec.events)
+		}
+	}
+	// The scanners of a phase run concurrently
+	if rec.peak["default-1"] != 2 || rec.peak["default-2"] != 2 {
+		t.Errorf("default scanners ran with at most %d and %d scanners, want 2", rec.peak["default-1"], rec.peak["default-2"])
+	}
+	// The items keep the order of the given scanners
+	for i, want := range []string{"disruptive", "default-1", "preflight", "default-2"} {
+		if items[i].ID != want || items[i].Outcome != config.OutcomePass {
+			t.Errorf("item %d = %s %s, want %s pass", i, items[i].ID, items[i].Outcome, want)
+		}
+	}
+}
+
+func TestEnginePrerequisites(t *testing.T) {
+	tests := []struct {
+		name    string
+		prereq  Spec
+		outcome config.Outcome // Outcome of the prerequisite if it runs
+		want    config.Outcome // Outcome of the dependent scanner
+		reason  string         // Skip reason of the dependent scanner
+	}{
+		{"passed", Spec{ID: "prereq", Phase: PhasePreflight}, config.OutcomePass, config.OutcomePass, ""},
+		{"failed", Spec{ID: "prereq", Phase: PhasePreflight}, config.OutcomeFail, config.OutcomeSkipped, "Prerequisite prereq did not pass (fail)"},
+		{"errored", Spec{ID: "prereq", Phase: PhasePreflight}, config.OutcomeError, config.OutcomeSkipped, "Prerequisite prereq did not pass (error)"},
+		{"skipped", Spec{ID: "prereq", Phase: PhasePreflight, Skip: func(*config.Config) string { return "not configured" }},
+			config.OutcomePass, config.OutcomeSkipped, "Prerequisite prereq did not pass (skipped)"},
+		{"same phase", Spec{ID: "prereq"}, config.OutcomeFail, config.OutcomeSkipped, "Prerequisite prereq did not pass (fail)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newRecorder()
+			dependent := rec.stub(Spec{ID: "dependent", Requires: []string{"prereq"}}, config.OutcomePass, 0)
+			// The dependent scanner is given first, it waits for its prerequisite nevertheless
+			items := (&Engine{Config: &config.Config{}}).Run(context.Background(), []Scanner{dependent, rec.stub(tt.prereq, tt.outcome, 0)})
+
+			si := items[0]
+			if si.Outcome != tt.want || si.Error != tt.reason {
+				t.Errorf("dependent scanner %s %q, want %s %q", si.Outcome, si.Error, tt.want, tt.reason)
+			}
+			if ran := rec.index("start dependent") >= 0; ran != (tt.want == config.OutcomePass) {
+				t.Errorf("dependent scanner ran: %t, events %q", ran, rec.events)
+			}
+		})
+	}
+}
+
+func TestEnginePrerequisiteNotSelected(t *testing.T) {
+	rec := newRecorder()
+	// A prerequisite which is not part of the run is ignored
+	items := (&Engine{Config: &config.Config{}}).Run(context.Background(), []Scanner{
+		rec.stub(Spec{ID: "dependent", Requires: []string{"prereq"}}, config.OutcomePass, 0),
+	})
+	if items[0].Outcome != config.OutcomePass {
+		t.Errorf("dependent scanner %s %q, want pass", items[0].Outcome, items[0].Error)
+	}
+}
+
+func TestEngineUnresolvablePrerequisite(t *testing.T) {
+	rec := newRecorder()
+	// The prerequisite runs in a later phase, so the dependent scanner can never run
+	items := (&Engine{Config: &config.Config{}}).Run(context.Background(), []Scanner{
+		rec.stub(Spec{ID: "dependent", Requires: []string{"prereq"}}, config.OutcomePass, 0),
+		rec.stub(Spec{ID: "prereq", Phase: PhaseDisruptive}, config.OutcomePass, 0),
+	})
+	if si := items[0]; si.Outcome != config.OutcomeError || si.Error != "unresolvable prerequisites prereq" {
+		t.Errorf("dependent scanner %s %q, want error unresolvable prerequisites prereq", si.Outcome, si.Error)
+	}
+	if items[1].Outcome != config.OutcomePass {
+		t.Errorf("prerequisite %s, want pass", items[1].Outcome)
+	}
+}
+
+func TestEngineExclusive(t *testing.T) {
+	rec := newRecorder()
+	scanners := []Scanner{
+		rec.stub(Spec{ID: "exclusive-1", Exclusive: true}, config.OutcomePass, 50*time.Millisecond),
+		rec.stub(Spec{ID: "concurrent-1"}, config.OutcomePass, 50*time.Millisecond),
+		rec.stub(Spec{ID: "exclusive-2", Exclusive: true}, config.OutcomePass, 50*time.Millisecond),
+		rec.stub(Spec{ID: "concurrent-2"}, config.OutcomePass, 50*time.Millisecond),
+	}
+
+	(&Engine{Config: &config.Config{}}).Run(context.Background(), scanners)
+
+	for _, id := range []string{"exclusive-1", "exclusive-2"} {
+		if rec.peak[id] != 1 {
+			t.Errorf("%s ran with %d scanners, want alone", id, rec.peak[id])
+		}
+	}
+	if rec.peak["concurrent-1"] != 2 {
+		t.Errorf("concurrent-1 ran with at most %d scanners, want 2", rec.peak["concurrent-1"])
+	}
+	// Exclusive scanners run after the concurrent ones of their phase
+	if rec.index("start exclusive-1") < rec.index("end concurrent-1") || rec.index("start exclusive-1") < rec.index("end concurrent-2") {
+		t.Errorf("exclusive scanner started before the concurrent ones ended: %q", rec.events)
+	}
+}
@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Scanner)
+)
+
+// Register adds a scanner to the registry, it is meant to be called from the init function of scanner packages.
+// It panics if the scanner has no ID, not exactly one run function or if the ID is already registered.
+func Register(s Scanner) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := s.ID()
+	if id == "" {
+		panic(fmt.Sprintf("scanner %q registered without ID", s.Name()))
+	}
+	if ss, ok := s.(*specScanner); ok && (ss.spec.Run == nil) == (ss.spec.RunListener == nil) {
+		panic(fmt.Sprintf("scanner %s must be registered with exactly one of Run and RunListener", id))
+	}
+	if _, dup := registry[id]; dup {
+		panic(fmt.Sprintf("scanner %s registered twice", id))
+	}
+	registry[id] = s
+}
+
+// Get returns the registered scanner with the given ID
+func Get(id string) (Scanner, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	s, ok := registry[id]
+	return s, ok
+}
+
+// All returns all registered scanners sorted by ID
+func All() []Scanner {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	scanners := make([]Scanner, 0, len(registry))
+	for _, s := range registry {
+		scanners = append(scanners, s)
+	}
+	sort.Slice(scanners, func(i, j int) bool {
+		return scanners[i].ID() < scanners[j].ID()
+	})
+	return scanners
+}
@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mqtt-security-scanner/config"
+)
+
+func runNothing(context.Context, *config.Config) (*config.ScanItem, error) { return nil, nil }
+
+func TestRegister(t *testing.T) {
+	Register(New(Spec{ID: "test.registered", Name: "Registered", Run: runNothing}))
+	if s, ok := Get("test.registered"); !ok || s.Name() != "Registered" {
+		t.Fatalf("Get(test.registered) = %v, %t", s, ok)
+	}
+
+	tests := []struct {
+		name  string
+		spec  Spec
+		panic string
+	}{
+		{"duplicate ID", Spec{ID: "test.registered", Run: runNothing}, "registered twice"},
+		{"empty ID", Spec{Name: "Nameless", Run: runNothing}, "without ID"},
+		{"no run function", Spec{ID: "test.no-run"}, "exactly one of Run and RunListener"},
+		{"both run functions", Spec{ID: "test.both-runs", Run: runNothing,
+			RunListener: func(context.Context, *config.Config, config.Listener) (*config.ScanItem, error) { return nil, nil }},
+			"exactly one of Run and RunListener"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if msg, _ := r.(string); !strings.Contains(msg, tt.panic) {
+					t.Errorf("Register() panic = %v, want %q", r, tt.panic)
+				}
+			}()
+			Register(New(tt.spec))
+		})
+	}
+	if _, ok := Get("test.no-run"); ok {
+		t.Error("invalid scanner was registered")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var scanners []Scanner
+	for _, spec := range []Spec{
+		{ID: "client.connect", Category: CategoryClient},
+		{ID: "client.username-length", Category: CategoryClient},
+		{ID: "client.password-length", Category: CategoryClient},
+		{ID: "message.acl", Category: CategoryMessage},
+		{ID: "tls.version", Category: CategoryTLS},
+	} {
+		spec.Run = runNothing
+		scanners = append(scanners, New(spec))
+	}
+
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       []string
+		err        string
+	}{
+		{"all", nil, nil, []string{"client.connect", "client.username-length", "client.password-length", "message.acl", "tls.version"}, ""},
+		{"only ID", []string{"message.acl"}, nil, []string{"message.acl"}, ""},
+		{"only category", []string{"client"}, nil, []string{"client.connect", "client.username-length", "client.password-length"}, ""},
+		{"only glob", []string{"client.*-length"}, nil, []string{"client.username-length", "client.password-length"}, ""},
+		{"only several", []string{"tls", "message.acl"}, nil, []string{"message.acl", "tls.version"}, ""},
+		{"skip ID", nil, []string{"client.connect"}, []string{"client.username-length", "client.password-length", "message.acl", "tls.version"}, ""},
+		{"skip category", nil, []string{"client", "tls"}, []string{"message.acl"}, ""},
+		{"skip wins over only", []string{"client"}, []string{"client.*-length"}, []string{"client.connect"}, ""},
+		{"skip everything selected", []string{"tls"}, []string{"tls.*"}, nil, ""},
+		{"unknown only", []string{"client.unknown"}, nil, nil, `"client.unknown" matches no scanner`},
+		{"unknown skip", nil, []string{"unknown"}, nil, `"unknown" matches no scanner`},
+		{"bad pattern", []string{"client.[a"}, nil, nil, `invalid scanner pattern "client.[a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := Select(scanners, tt.only, tt.skip)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Select() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, s := range selected {
+				ids = append(ids, s.ID())
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Select() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	got := ParsePatterns(" client , ,tls.*,")
+	if strings.Join(got, "|") != "client|tls.*" {
+		t.Errorf("ParsePatterns() = %q, want [client tls.*]", got)
+	}
+	if got := ParsePatterns(""); got != nil {
+		t.Errorf("ParsePatterns(\"\") = %q, want nil", got)
+	}
+}
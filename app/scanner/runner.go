@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mqtt-security-scanner/config"
+)
+
+// DefaultTimeout is the timeout of a single scanner when none is configured
+const DefaultTimeout = 2 * time.Minute
+
+// CancelGrace is how long a scanner is given to clean up and return once it is cancelled.
+// A scanner which takes longer is reported as not completed and left running in the background.
+const CancelGrace = 15 * time.Second
+
+// Timeout returns the timeout configured for the given scanner
+func Timeout(cfg *config.Config, s Scanner) time.Duration {
+	if sec, ok := cfg.Timeout.Scanners[s.ID()]; ok && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if cfg.Timeout.Scanner > 0 {
+		return time.Duration(cfg.Timeout.Scanner) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// Execute runs the scanner with its configured timeout and always returns a scan item.
+// When the scanner times out or the context is cancelled, the context of the scanner is cancelled and Execute returns
+// once the scanner does, so that its cleanup does not overlap the next scanners, e.g. those after an exclusive scanner.
+// A scanner which does not return within CancelGrace is no longer waited for, so that it cannot stall the scan.
+// Scanners which do not apply to the configuration or are not started before the scan is interrupted
+// are reported as skipped, scanners which fail, panic or do not complete are reported as errors.
+func Execute(ctx context.Context, cfg *config.Config, s Scanner) *config.ScanItem {
+	start := time.Now()
+	si := execute(ctx, cfg, s)
+	describe(s, si)
+	si.Duration = time.Since(start)
+	return si
+}
+
+// describe copies the metadata of the scanner to its scan item
+func describe(s Scanner, si *config.ScanItem) {
+	si.ID = s.ID()
+	if l := s.Listener(); l != nil {
+		si.Listener = l.URL()
+		si.Protocol = l.Protocol
+	}
+	si.Category = s.Category()
+	si.Severity = s.Severity()
+	si.Remediation = s.Remediation()
+	si.References = s.References()
+}
+
+func execute(ctx context.Context, cfg *config.Config, s Scanner) *config.ScanItem {
+	if reason := s.Skip(cfg); reason != "" {
+		return config.NewSkippedItem(s.Name(), reason)
+	}
+	if ctx.Err() != nil {
+		return config.NewSkippedItem(s.Name(), "Scan was interrupted before the scanner started")
+	}
+
+	timeout := Timeout(cfg, s)
+	scanCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		si  *config.ScanItem
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("scanner panicked: %v", r)}
+			}
+		}()
+		si, err := s.Run(ctx, cfg)
+		done <- result{si, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		// The scanner returns once it notices the cancellation, after its cleanup
+		si := interrupted(scanCtx, s, timeout)
+		grace := time.NewTimer(CancelGrace)
+		defer grace.Stop()
+		select {
+		case <-done:
+		case <-grace.C:
+			si.Error += fmt.Sprintf(", and did not return within %v of its cancellation", CancelGrace)
+		}
+		return si
+	}
+
+	switch {
+	// A scanner may notice the cancellation itself and fail because of it
+	case r.err != nil && ctx.Err() != nil:
+		return interrupted(scanCtx, s, timeout)
+	case r.err != nil:
+		return config.NewErrorItem(s.Name(), r.err)
+	case r.si == nil:
+		return config.NewErrorItem(s.Name(), errors.New("scanner returned no result"))
+	}
+	return r.si
+}
+
+// interrupted returns the scan item of a scanner which did not complete,
+// either because the whole scan was interrupted or because the scanner itself timed out
+func interrupted(scanCtx context.Context, s Scanner, timeout time.Duration) *config.ScanItem {
+	switch err := scanCtx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		return config.NewErrorItem(s.Name(), errors.New("scanner did not complete before the scan deadline"))
+	case err != nil:
+		return config.NewErrorItem(s.Name(), errors.New("scanner was cancelled before completion"))
+	default:
+		return config.NewErrorItem(s.Name(), fmt.Errorf("scanner timed out after %v", timeout))
+	}
+}
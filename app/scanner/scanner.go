@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+
+	"mqtt-security-scanner/config"
+)
+
+// Categories of the scanners
+const (
+	CategoryProtocol = "protocol"
+	CategoryTLS      = "tls"
+	CategoryClient   = "client"
+	CategoryMessage  = "message"
+	CategoryPort     = "port"
+)
+
+// RunFunc is the function that performs a single scan item
+// The context is cancelled when the scanner times out or the scan is interrupted
+type RunFunc func(context.Context, *config.Config) (*config.ScanItem, error)
+
+// ListenerFunc is the function that performs a single scan item against one broker listener
+type ListenerFunc func(context.Context, *config.Config, config.Listener) (*config.ScanItem, error)
+
+// Scanner is a single security check that can be run against a MQTT deployment
+type Scanner interface {
+	// ID returns the stable identifier of the scanner, e.g. "client.authentication"
+	ID() string
+	// Name returns the human-readable name shown in reports
+	Name() string
+	// Category returns one of the Category* constants
+	Category() string
+	// Description returns a short description of what is checked
+	Description() string
+	// Severity returns the severity of a finding of the scanner
+	Severity() config.Severity
+	// Remediation returns the guidance on how to fix a finding of the scanner
+	Remediation() string
+	// References returns links or citations of the specifications and documentation the check is based on
+	References() []string
+	// Phase returns the phase in which the scanner runs
+	Phase() Phase
+	// Requires returns the IDs of the scanners which must pass before this scanner runs
+	Requires() []string
+	// Exclusive reports whether the scanner must run without any other scanner running concurrently
+	Exclusive() bool
+	// Skip returns the reason why the scanner does not apply to the configuration, empty if it applies
+	Skip(cfg *config.Config) string
+	// Expand returns the scanner instances to run for the configuration,
+	// one per enabled listener and protocol version for scanners which run per listener, the scanner itself otherwise
+	Expand(cfg *config.Config) []Scanner
+	// Listener returns the listener of an instance returned by Expand, nil if the scanner does not run per listener
+	Listener() *config.Listener
+	// Run performs the check
+	Run(ctx context.Context, cfg *config.Config) (*config.ScanItem, error)
+}
+
+// Spec describes a scanner which is backed by a plain RunFunc, or by a ListenerFunc to run once per enabled listener
+type Spec struct {
+	ID          string
+	Name        string
+	Category    string
+	Description string
+	Severity    config.Severity // Optional, SeverityMedium is used when unset
+	Remediation string
+	References  []string
+	Phase       Phase                       // Optional, PhaseDefault is used when unset
+	Requires    []string                    // Optional, IDs of the scanners which must pass first
+	Exclusive   bool                        // Optional, run without any other scanner running concurrently
+	Skip        func(*config.Config) string // Optional, the scanner always applies when nil
+	Run         RunFunc
+	RunListener ListenerFunc // Set instead of Run for scanners which run once per enabled listener
+	// Listeners optionally restricts a RunListener scanner to the listeners it returns true for,
+	// the scanner is skipped when no enabled listener applies
+	Listeners func(config.Listener) bool
+	// ListenerSource optionally replaces the enabled listeners a RunListener scanner is expanded over,
+	// e.g. with config.BrokerInfo.TLSListeners to audit the TLS listeners whatever the configured transports
+	ListenerSource func(config.BrokerInfo) []config.Listener
+}
+
+// New returns a Scanner built from the given spec
+func New(spec Spec) Scanner {
+	if spec.Phase == 0 {
+		spec.Phase = PhaseDefault
+	}
+	if spec.Severity == "" {
+		spec.Severity = config.SeverityMedium
+	}
+	return &specScanner{spec: spec}
+}
+
+type specScanner struct {
+	spec     Spec
+	listener *config.Listener // The listener of an expanded instance
+}
+
+func (s *specScanner) ID() string                { return s.spec.ID }
+func (s *specScanner) Name() string              { return s.spec.Name }
+func (s *specScanner) Category() string          { return s.spec.Category }
+func (s *specScanner) Description() string       { return s.spec.Description }
+func (s *specScanner) Severity() config.Severity { return s.spec.Severity }
+func (s *specScanner) Remediation() string       { return s.spec.Remediation }
+func (s *specScanner) References() []string      { return s.spec.References }
+func (s *specScanner) Phase() Phase              { return s.spec.Phase }
+func (s *specScanner) Requires() []string        { return s.spec.Requires }
+func (s *specScanner) Exclusive() bool           { return s.spec.Exclusive }
+
+func (s *specScanner) Skip(cfg *config.Config) string {
+	if s.spec.Skip != nil {
+		if reason := s.spec.Skip(cfg); reason != "" {
+			return reason
+		}
+	}
+	if s.spec.RunListener != nil && s.listener == nil {
+		return "None of the enabled listeners applies to the scanner"
+	}
+	return ""
+}
+
+func (s *specScanner) Expand(cfg *config.Config) []Scanner {
+	if s.spec.RunListener == nil {
+		return []Scanner{s}
+	}
+
+	var instances []Scanner
+	listeners := cfg.BrokerInfo.Listeners()
+	if s.spec.ListenerSource != nil {
+		listeners = s.spec.ListenerSource(cfg.BrokerInfo)
+	}
+	for _, l := range listeners {
+		if s.spec.Listeners == nil || s.spec.Listeners(l) {
+			instances = append(instances, &specScanner{spec: s.spec, listener: &l})
+		}
+	}
+	if len(instances) == 0 {
+		// The unexpanded scanner is reported as skipped
+		return []Scanner{s}
+	}
+	return instances
+}
+
+func (s *specScanner) Listener() *config.Listener { return s.listener }
+
+func (s *specScanner) Run(ctx context.Context, cfg *config.Config) (*config.ScanItem, error) {
+	if s.spec.RunListener == nil {
+		return s.spec.Run(ctx, cfg)
+	}
+	if s.listener == nil {
+		return nil, fmt.Errorf("scanner %s runs per listener and must be expanded", s.spec.ID)
+	}
+	return s.spec.RunListener(ctx, cfg, *s.listener)
+}
+
+// DisplayName returns the name of a scanner instance qualified with its listener
+func DisplayName(s Scanner) string {
+	if l := s.Listener(); l != nil {
+		return fmt.Sprintf("%s (%s)", s.Name(), l)
+	}
+	return s.Name()
+}
+
+// Key returns the identifier of a scanner instance, the scanner ID qualified with the listener if it runs per listener
+func Key(s Scanner) string {
+	if l := s.Listener(); l != nil {
+		return s.ID() + "@" + l.String()
+	}
+	return s.ID()
+}
@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Match reports whether the scanner matches the pattern.
+// A pattern matches a scanner ID or category exactly, or the scanner ID as a glob pattern, e.g. "client.*-length".
+func Match(s Scanner, pattern string) bool {
+	if pattern == s.ID() || pattern == s.Category() {
+		return true
+	}
+	ok, _ := path.Match(pattern, s.ID())
+	return ok
+}
+
+// Select returns the scanners which match any of the only patterns (all scanners if empty)
+// and none of the skip patterns. An error is returned for malformed patterns or patterns that match no scanner.
+func Select(scanners []Scanner, only, skip []string) ([]Scanner, error) {
+	for _, pattern := range append(append([]string{}, only...), skip...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid scanner pattern %q, %v", pattern, err)
+		}
+		if !matchAny(scanners, pattern) {
+			return nil, fmt.Errorf("scanner pattern %q matches no scanner", pattern)
+		}
+	}
+
+	var selected []Scanner
+	for _, s := range scanners {
+		if len(only) > 0 && !matchPatterns(s, only) {
+			continue
+		}
+		if matchPatterns(s, skip) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// ParsePatterns splits a comma-separated list of scanner patterns
+func ParsePatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func matchPatterns(s Scanner, patterns []string) bool {
+	for _, pattern := range patterns {
+		if Match(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(scanners []Scanner, pattern string) bool {
+	for _, s := range scanners {
+		if Match(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
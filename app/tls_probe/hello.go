@@ -0,0 +1,315 @@
+// Package tls_probe sends raw TLS ClientHello messages to find out which protocol versions and cipher suites
+// a server accepts, including the legacy ones crypto/tls no longer offers. No handshake is ever completed.
+package tls_probe
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// Protocol versions as sent on the wire
+const (
+	VersionSSL30 uint16 = 0x0300
+	VersionTLS10 uint16 = 0x0301
+	VersionTLS11 uint16 = 0x0302
+	VersionTLS12 uint16 = 0x0303
+	VersionTLS13 uint16 = 0x0304
+)
+
+// Versions are the protocol versions in ascending order
+var Versions = []uint16{VersionSSL30, VersionTLS10, VersionTLS11, VersionTLS12, VersionTLS13}
+
+// VersionName returns the name of the protocol version as written in the configuration, e.g. "TLS1.2"
+func VersionName(version uint16) string {
+	switch version {
+	case VersionSSL30:
+		return "SSL3.0"
+	case VersionTLS10:
+		return "TLS1.0"
+	case VersionTLS11:
+		return "TLS1.1"
+	case VersionTLS12:
+		return "TLS1.2"
+	case VersionTLS13:
+		return "TLS1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// ErrRefused is returned when the server answers the ClientHello with an alert or closes the connection
+var ErrRefused = errors.New("handshake refused")
+
+const (
+	dialTimeout  = 3 * time.Second
+	replyTimeout = 5 * time.Second
+
+	recordHandshake = 22
+	recordAlert     = 21
+
+	handshakeClientHello = 1
+	handshakeServerHello = 2
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extSupportedVersions   = 0x002b
+	extKeyShare            = 0x0033
+	extRenegotiationInfo   = 0xff01
+
+	groupX25519 = 0x001d
+)
+
+// supportedGroups are the groups offered for ECDHE, x25519 is the only one with a TLS 1.3 key share
+var supportedGroups = []uint16{groupX25519, 0x0017, 0x0018, 0x0019}
+
+// signatureAlgorithms are the signature algorithms offered from TLS 1.2 on, SHA-1 ones included for old servers
+var signatureAlgorithms = []uint16{0x0403, 0x0503, 0x0603, 0x0807, 0x0804, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0203, 0x0201}
+
+// ServerHello is the part of the server answer to a ClientHello the probes need
+type ServerHello struct {
+	Version     uint16 // Negotiated version, taken from the supported_versions extension for TLS 1.3
+	CipherSuite uint16
+}
+
+// Probe connects to the address and sends a ClientHello for the version offering the cipher suites.
+// The server name is sent in the SNI extension unless it is an IP address.
+// ErrRefused is returned if the server refuses the handshake.
+func Probe(ctx context.Context, address, serverName string, version uint16, suites []uint16) (*ServerHello, error) {
+	hello, err := clientHello(serverName, version, suites)
+	if err != nil {
+		return nil, err
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := conn.SetDeadline(time.Now().Add(replyTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(hello); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRefused, err)
+	}
+	sh, err := readServerHello(conn)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return sh, err
+}
+
+// Supports reports whether the server negotiates the version when offered all the known cipher suites of the version
+func Supports(ctx context.Context, address, serverName string, version uint16) (bool, error) {
+	sh, err := Probe(ctx, address, serverName, version, Suites(version))
+	if errors.Is(err, ErrRefused) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sh.Version == version, nil
+}
+
+// Enumerate returns the cipher suites the server accepts for the version, in the order the server chose them.
+// Every probe offers the suites not found yet until the server refuses the rest. No suite is returned if the server
+// does not support the version.
+func Enumerate(ctx context.Context, address, serverName string, version uint16) ([]uint16, error) {
+	remaining := Suites(version)
+	var accepted []uint16
+	for len(remaining) > 0 {
+		sh, err := Probe(ctx, address, serverName, version, remaining)
+		if errors.Is(err, ErrRefused) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sh.Version != version {
+			// The server fell back to the version it supports
+			break
+		}
+
+		i := slices.Index(remaining, sh.CipherSuite)
+		if i < 0 {
+			return nil, fmt.Errorf("server selected the cipher suite %s which was not offered", SuiteName(sh.CipherSuite))
+		}
+		accepted = append(accepted, sh.CipherSuite)
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return accepted, nil
+}
+
+// clientHello returns the TLS record of a ClientHello for the version offering the cipher suites.
+// SSL 3.0 hellos have no extension, TLS 1.3 hellos announce the version in the supported_versions extension.
+func clientHello(serverName string, version uint16, suites []uint16) ([]byte, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	clientVersion := min(version, VersionTLS12)
+	body := appendUint16(nil, clientVersion)
+	body = append(body, random...)
+	body = append(body, 0) // No session ID
+	body = appendUint16(body, uint16(2*len(suites)))
+	for _, s := range suites {
+		body = appendUint16(body, s)
+	}
+	body = append(body, 1, 0) // Null compression only
+
+	if version > VersionSSL30 {
+		extensions, err := helloExtensions(serverName, version)
+		if err != nil {
+			return nil, err
+		}
+		body = appendUint16(body, uint16(len(extensions)))
+		body = append(body, extensions...)
+	}
+
+	handshake := []byte{handshakeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+
+	// Servers expect the version of the first record to be at most TLS 1.0
+	record := []byte{recordHandshake}
+	record = appendUint16(record, min(version, VersionTLS10))
+	record = appendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...), nil
+}
+
+// helloExtensions returns the extensions of a TLS ClientHello for the version
+func helloExtensions(serverName string, version uint16) ([]byte, error) {
+	var b []byte
+	if serverName != "" && net.ParseIP(serverName) == nil {
+		name := appendUint16([]byte{0}, uint16(len(serverName)))
+		name = append(name, serverName...)
+		b = appendExtension(b, extServerName, appendVector16(nil, name))
+	}
+
+	groups := make([]byte, 0, 2*len(supportedGroups))
+	for _, g := range supportedGroups {
+		groups = appendUint16(groups, g)
+	}
+	b = appendExtension(b, extSupportedGroups, appendVector16(nil, groups))
+	b = appendExtension(b, extECPointFormats, []byte{1, 0})
+	b = appendExtension(b, extRenegotiationInfo, []byte{0})
+
+	if version >= VersionTLS12 {
+		algorithms := make([]byte, 0, 2*len(signatureAlgorithms))
+		for _, a := range signatureAlgorithms {
+			algorithms = appendUint16(algorithms, a)
+		}
+		b = appendExtension(b, extSignatureAlgorithms, appendVector16(nil, algorithms))
+	}
+
+	if version >= VersionTLS13 {
+		b = appendExtension(b, extSupportedVersions, appendUint16([]byte{2}, version))
+
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		share := appendUint16(nil, groupX25519)
+		share = appendVector16(share, key.PublicKey().Bytes())
+		b = appendExtension(b, extKeyShare, appendVector16(nil, share))
+	}
+	return b, nil
+}
+
+// readServerHello reads the records sent by the server until the ServerHello is complete
+func readServerHello(r io.Reader) (*ServerHello, error) {
+	var handshake []byte
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRefused, err)
+		}
+		fragment := make([]byte, int(header[3])<<8|int(header[4]))
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRefused, err)
+		}
+
+		switch header[0] {
+		case recordAlert:
+			if len(fragment) < 2 {
+				return nil, fmt.Errorf("%w: truncated alert", ErrRefused)
+			}
+			return nil, fmt.Errorf("%w: alert %d", ErrRefused, fragment[1])
+		case recordHandshake:
+			handshake = append(handshake, fragment...)
+		default:
+			return nil, fmt.Errorf("unexpected TLS record type %d before the ServerHello", header[0])
+		}
+
+		if len(handshake) < 4 {
+			continue
+		}
+		if handshake[0] != handshakeServerHello {
+			return nil, fmt.Errorf("unexpected TLS handshake message type %d instead of the ServerHello", handshake[0])
+		}
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) >= 4+length {
+			return parseServerHello(handshake[4 : 4+length])
+		}
+	}
+}
+
+// parseServerHello parses the body of a ServerHello message
+func parseServerHello(b []byte) (*ServerHello, error) {
+	malformed := errors.New("malformed ServerHello")
+	// Version, random and the length of the session ID
+	if len(b) < 35 {
+		return nil, malformed
+	}
+	sh := &ServerHello{Version: uint16(b[0])<<8 | uint16(b[1])}
+	b = b[34:]
+	sessionID := int(b[0])
+	if len(b) < 1+sessionID+3 {
+		return nil, malformed
+	}
+	b = b[1+sessionID:]
+	sh.CipherSuite = uint16(b[0])<<8 | uint16(b[1])
+	b = b[3:]
+
+	// Extensions are optional before TLS 1.3
+	if len(b) < 2 {
+		return sh, nil
+	}
+	b = b[2:]
+	for len(b) >= 4 {
+		typ := uint16(b[0])<<8 | uint16(b[1])
+		length := int(b[2])<<8 | int(b[3])
+		if len(b) < 4+length {
+			return nil, malformed
+		}
+		if typ == extSupportedVersions && length == 2 {
+			sh.Version = uint16(b[4])<<8 | uint16(b[5])
+		}
+		b = b[4+length:]
+	}
+	return sh, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendVector16 appends the data prefixed with its 16 bit length
+func appendVector16(b, data []byte) []byte {
+	return append(appendUint16(b, uint16(len(data))), data...)
+}
+
+func appendExtension(b []byte, typ uint16, data []byte) []byte {
+	return appendVector16(appendUint16(b, typ), data)
+}
@@ -0,0 +1,261 @@
+package tls_probe
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// hello is a ClientHello as parsed from its record
+type hello struct {
+	recordVersion uint16
+	clientVersion uint16
+	suites        []uint16
+	extensions    map[uint16][]byte // nil if the hello has no extension block
+}
+
+// parseClientHello parses the TLS record of a ClientHello and checks its lengths
+func parseClientHello(t *testing.T, b []byte) hello {
+	t.Helper()
+	u16 := func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+
+	if len(b) < 9 || b[0] != recordHandshake {
+		t.Fatalf("not a handshake record: % x", b)
+	}
+	h := hello{recordVersion: u16(b[1:])}
+	if n := int(u16(b[3:])); n != len(b)-5 {
+		t.Fatalf("record length = %d, want %d", n, len(b)-5)
+	}
+	b = b[5:]
+	if b[0] != handshakeClientHello {
+		t.Fatalf("handshake type = %d, want ClientHello", b[0])
+	}
+	if n := int(b[1])<<16 | int(b[2])<<8 | int(b[3]); n != len(b)-4 {
+		t.Fatalf("handshake length = %d, want %d", n, len(b)-4)
+	}
+	b = b[4:]
+
+	h.clientVersion = u16(b)
+	b = b[2+32:] // Random
+	if b[0] != 0 {
+		t.Fatalf("session ID length = %d, want 0", b[0])
+	}
+	n := int(u16(b[1:]))
+	for i := 0; i < n; i += 2 {
+		h.suites = append(h.suites, u16(b[3+i:]))
+	}
+	b = b[3+n:]
+	if !bytes.Equal(b[:2], []byte{1, 0}) {
+		t.Fatalf("compression methods = % x, want null only", b[:2])
+	}
+	b = b[2:]
+	if len(b) == 0 {
+		return h
+	}
+
+	if n := int(u16(b)); n != len(b)-2 {
+		t.Fatalf("extensions length = %d, want %d", n, len(b)-2)
+	}
+	h.extensions = make(map[uint16][]byte)
+	for b = b[2:]; len(b) > 0; {
+		typ, n := u16(b), int(u16(b[2:]))
+		h.extensions[typ] = b[4 : 4+n]
+		b = b[4+n:]
+	}
+	return h
+}
+
+func TestClientHello(t *testing.T) {
+	suites := []uint16{0xC02F, 0x0005, 0x000A}
+	tests := []struct {
+		name          string
+		serverName    string
+		version       uint16
+		suites        []uint16
+		recordVersion uint16
+		clientVersion uint16
+		extensions    []uint16 // Extensions the hello must have, nil for no extension block
+		absent        []uint16 // Extensions the hello must not have
+	}{
+		{"SSL 3.0", "broker.example.com", VersionSSL30, suites, VersionSSL30, VersionSSL30, nil, nil},
+		{"TLS 1.0", "broker.example.com", VersionTLS10, suites, VersionTLS10, VersionTLS10,
+			[]uint16{extServerName, extSupportedGroups, extECPointFormats, extRenegotiationInfo},
+			[]uint16{extSignatureAlgorithms, extSupportedVersions, extKeyShare}},
+		{"TLS 1.2", "broker.example.com", VersionTLS12, suites, VersionTLS10, VersionTLS12,
+			[]uint16{extServerName, extSupportedGroups, extSignatureAlgorithms},
+			[]uint16{extSupportedVersions, extKeyShare}},
+		{"TLS 1.2 to an IP address", "127.0.0.1", VersionTLS12, suites, VersionTLS10, VersionTLS12,
+			[]uint16{extSupportedGroups, extSignatureAlgorithms},
+			[]uint16{extServerName}},
+		{"TLS 1.3", "broker.example.com", VersionTLS13, Suites(VersionTLS13), VersionTLS10, VersionTLS12,
+			[]uint16{extServerName, extSignatureAlgorithms, extSupportedVersions, extKeyShare}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := clientHello(tt.serverName, tt.version, tt.suites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := parseClientHello(t, b)
+			if h.recordVersion != tt.recordVersion {
+				t.Errorf("record version = %s, want %s", VersionName(h.recordVersion), VersionName(tt.recordVersion))
+			}
+			if h.clientVersion != tt.clientVersion {
+				t.Errorf("client version = %s, want %s", VersionName(h.clientVersion), VersionName(tt.clientVersion))
+			}
+			if !slices.Equal(h.suites, tt.suites) {
+				t.Errorf("cipher suites = %04x, want %04x", h.suites, tt.suites)
+			}
+			if tt.extensions == nil && h.extensions != nil {
+				t.Errorf("extensions = %v, want none", h.extensions)
+			}
+			for _, e := range tt.extensions {
+				if _, ok := h.extensions[e]; !ok {
+					t.Errorf("extension 0x%04x missing", e)
+				}
+			}
+			for _, e := range tt.absent {
+				if _, ok := h.extensions[e]; ok {
+					t.Errorf("extension 0x%04x present", e)
+				}
+			}
+		})
+	}
+}
+
+func TestClientHelloExtensions(t *testing.T) {
+	b, err := clientHello("broker.example.com", VersionTLS13, Suites(VersionTLS13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := parseClientHello(t, b)
+
+	wantName := append([]byte{0x00, 0x15, 0x00, 0x00, 0x12}, "broker.example.com"...)
+	if got := h.extensions[extServerName]; !bytes.Equal(got, wantName) {
+		t.Errorf("server_name = % x, want % x", got, wantName)
+	}
+	if got, want := h.extensions[extSupportedVersions], []byte{0x02, 0x03, 0x04}; !bytes.Equal(got, want) {
+		t.Errorf("supported_versions = % x, want % x", got, want)
+	}
+	// A x25519 key share of 32 bytes
+	share := h.extensions[extKeyShare]
+	if len(share) != 2+2+2+32 || !bytes.Equal(share[:6], []byte{0x00, 0x24, 0x00, 0x1d, 0x00, 0x20}) {
+		t.Errorf("key_share = % x, want a x25519 key share", share)
+	}
+}
+
+// serverHello returns the body of a ServerHello message with a session ID of the length and the extension block
+func serverHello(version, suite uint16, sessionID int, extensions []byte) []byte {
+	b := appendUint16(nil, version)
+	b = append(b, make([]byte, 32)...)
+	b = append(b, byte(sessionID))
+	b = append(b, make([]byte, sessionID)...)
+	b = appendUint16(b, suite)
+	b = append(b, 0) // Null compression
+	if extensions != nil {
+		b = appendVector16(b, extensions)
+	}
+	return b
+}
+
+// handshakeMessage returns the ServerHello body wrapped in a handshake message
+func handshakeMessage(typ byte, body []byte) []byte {
+	return append([]byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// record returns the fragment wrapped in a TLS record of the type
+func record(typ byte, fragment []byte) []byte {
+	return appendVector16(appendUint16([]byte{typ}, VersionTLS12), fragment)
+}
+
+func TestParseServerHello(t *testing.T) {
+	renegotiation := appendExtension(nil, extRenegotiationInfo, []byte{0})
+	tls13 := appendExtension(appendExtension(nil, extKeyShare, make([]byte, 36)), extSupportedVersions, appendUint16(nil, VersionTLS13))
+	tests := []struct {
+		name string
+		b    []byte
+		want *ServerHello
+	}{
+		{"TLS 1.2 without extensions", serverHello(VersionTLS12, 0xC02F, 0, nil), &ServerHello{VersionTLS12, 0xC02F}},
+		{"TLS 1.0 with a session ID", serverHello(VersionTLS10, 0x0005, 32, nil), &ServerHello{VersionTLS10, 0x0005}},
+		{"TLS 1.2 with extensions", serverHello(VersionTLS12, 0x009C, 32, renegotiation), &ServerHello{VersionTLS12, 0x009C}},
+		{"TLS 1.3 in supported_versions", serverHello(VersionTLS12, 0x1301, 32, tls13), &ServerHello{VersionTLS13, 0x1301}},
+		{"empty extension block", serverHello(VersionTLS12, 0xC030, 0, []byte{}), &ServerHello{VersionTLS12, 0xC030}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseServerHello(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("parseServerHello() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServerHelloMalformed(t *testing.T) {
+	valid := serverHello(VersionTLS12, 0x1301, 32, appendExtension(nil, extSupportedVersions, appendUint16(nil, VersionTLS13)))
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"truncated random", valid[:20]},
+		{"session ID longer than the message", valid[:40]},
+		{"truncated cipher suite", valid[:2+32+1+32+1]},
+		{"extension longer than the message", valid[:len(valid)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sh, err := parseServerHello(tt.b); err == nil {
+				t.Errorf("parseServerHello(% x) = %+v, want an error", tt.b, sh)
+			}
+		})
+	}
+}
+
+func TestReadServerHello(t *testing.T) {
+	message := handshakeMessage(handshakeServerHello, serverHello(VersionTLS12, 0xC02F, 32, nil))
+	want := &ServerHello{VersionTLS12, 0xC02F}
+	certificate := handshakeMessage(11, []byte{0, 0, 0})
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *ServerHello
+		refused bool
+	}{
+		{"single record", record(recordHandshake, message), want, false},
+		{"followed by the certificate", record(recordHandshake, append(slices.Clone(message), certificate...)), want, false},
+		{"fragmented over records", append(record(recordHandshake, message[:3]), record(recordHandshake, message[3:])...), want, false},
+		{"alert", record(recordAlert, []byte{2, 40}), nil, true},
+		{"truncated alert", record(recordAlert, []byte{2}), nil, true},
+		{"connection closed", nil, nil, true},
+		{"connection closed in the ServerHello", record(recordHandshake, message)[:20], nil, true},
+		{"application data", record(23, []byte{1, 2, 3}), nil, false},
+		{"other handshake message first", record(recordHandshake, certificate), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readServerHello(bytes.NewReader(tt.b))
+			if tt.want != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *got != *tt.want {
+					t.Errorf("readServerHello() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("readServerHello() = %+v, want an error", got)
+			}
+			if errors.Is(err, ErrRefused) != tt.refused {
+				t.Errorf("readServerHello() error = %v, refused %t, want refused %t", err, !tt.refused, tt.refused)
+			}
+		})
+	}
+}
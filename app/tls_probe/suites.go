@@ -0,0 +1,266 @@
+package tls_probe
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// suiteNames are the IANA names of the cipher suites the probes offer, including the ones crypto/tls does not implement
+var suiteNames = map[uint16]string{
+	0x0000: "TLS_NULL_WITH_NULL_NULL",
+	0x0001: "TLS_RSA_WITH_NULL_MD5",
+	0x0002: "TLS_RSA_WITH_NULL_SHA",
+	0x0003: "TLS_RSA_EXPORT_WITH_RC4_40_MD5",
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x0006: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5",
+	0x0007: "TLS_RSA_WITH_IDEA_CBC_SHA",
+	0x0008: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x000A: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x000B: "TLS_DH_DSS_EXPORT_WITH_DES40_CBC_SHA",
+	0x000C: "TLS_DH_DSS_WITH_DES_CBC_SHA",
+	0x000D: "TLS_DH_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x000E: "TLS_DH_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x000F: "TLS_DH_RSA_WITH_DES_CBC_SHA",
+	0x0010: "TLS_DH_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0011: "TLS_DHE_DSS_EXPORT_WITH_DES40_CBC_SHA",
+	0x0012: "TLS_DHE_DSS_WITH_DES_CBC_SHA",
+	0x0013: "TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x0014: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0015: "TLS_DHE_RSA_WITH_DES_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0017: "TLS_DH_anon_EXPORT_WITH_RC4_40_MD5",
+	0x0018: "TLS_DH_anon_WITH_RC4_128_MD5",
+	0x0019: "TLS_DH_anon_EXPORT_WITH_DES40_CBC_SHA",
+	0x001A: "TLS_DH_anon_WITH_DES_CBC_SHA",
+	0x001B: "TLS_DH_anon_WITH_3DES_EDE_CBC_SHA",
+	0x002C: "TLS_PSK_WITH_NULL_SHA",
+	0x002D: "TLS_DHE_PSK_WITH_NULL_SHA",
+	0x002E: "TLS_RSA_PSK_WITH_NULL_SHA",
+	0x002F: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0030: "TLS_DH_DSS_WITH_AES_128_CBC_SHA",
+	0x0031: "TLS_DH_RSA_WITH_AES_128_CBC_SHA",
+	0x0032: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0034: "TLS_DH_anon_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0036: "TLS_DH_DSS_WITH_AES_256_CBC_SHA",
+	0x0037: "TLS_DH_RSA_WITH_AES_256_CBC_SHA",
+	0x0038: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003A: "TLS_DH_anon_WITH_AES_256_CBC_SHA",
+	0x003B: "TLS_RSA_WITH_NULL_SHA256",
+	0x003C: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003D: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x003E: "TLS_DH_DSS_WITH_AES_128_CBC_SHA256",
+	0x003F: "TLS_DH_RSA_WITH_AES_128_CBC_SHA256",
+	0x0040: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA256",
+	0x0041: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0042: "TLS_DH_DSS_WITH_CAMELLIA_128_CBC_SHA",
+	0x0043: "TLS_DH_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0044: "TLS_DHE_DSS_WITH_CAMELLIA_128_CBC_SHA",
+	0x0045: "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0046: "TLS_DH_anon_WITH_CAMELLIA_128_CBC_SHA",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x0068: "TLS_DH_DSS_WITH_AES_256_CBC_SHA256",
+	0x0069: "TLS_DH_RSA_WITH_AES_256_CBC_SHA256",
+	0x006A: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA256",
+	0x006B: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x006C: "TLS_DH_anon_WITH_AES_128_CBC_SHA256",
+	0x006D: "TLS_DH_anon_WITH_AES_256_CBC_SHA256",
+	0x0084: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0085: "TLS_DH_DSS_WITH_CAMELLIA_256_CBC_SHA",
+	0x0086: "TLS_DH_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0087: "TLS_DHE_DSS_WITH_CAMELLIA_256_CBC_SHA",
+	0x0088: "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0089: "TLS_DH_anon_WITH_CAMELLIA_256_CBC_SHA",
+	0x008A: "TLS_PSK_WITH_RC4_128_SHA",
+	0x008B: "TLS_PSK_WITH_3DES_EDE_CBC_SHA",
+	0x008C: "TLS_PSK_WITH_AES_128_CBC_SHA",
+	0x008D: "TLS_PSK_WITH_AES_256_CBC_SHA",
+	0x008E: "TLS_DHE_PSK_WITH_RC4_128_SHA",
+	0x008F: "TLS_DHE_PSK_WITH_3DES_EDE_CBC_SHA",
+	0x0090: "TLS_DHE_PSK_WITH_AES_128_CBC_SHA",
+	0x0091: "TLS_DHE_PSK_WITH_AES_256_CBC_SHA",
+	0x0092: "TLS_RSA_PSK_WITH_RC4_128_SHA",
+	0x0093: "TLS_RSA_PSK_WITH_3DES_EDE_CBC_SHA",
+	0x0094: "TLS_RSA_PSK_WITH_AES_128_CBC_SHA",
+	0x0095: "TLS_RSA_PSK_WITH_AES_256_CBC_SHA",
+	0x0096: "TLS_RSA_WITH_SEED_CBC_SHA",
+	0x0097: "TLS_DH_DSS_WITH_SEED_CBC_SHA",
+	0x0098: "TLS_DH_RSA_WITH_SEED_CBC_SHA",
+	0x0099: "TLS_DHE_DSS_WITH_SEED_CBC_SHA",
+	0x009A: "TLS_DHE_RSA_WITH_SEED_CBC_SHA",
+	0x009B: "TLS_DH_anon_WITH_SEED_CBC_SHA",
+	0x009C: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009D: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009E: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009F: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0x00A0: "TLS_DH_RSA_WITH_AES_128_GCM_SHA256",
+	0x00A1: "TLS_DH_RSA_WITH_AES_256_GCM_SHA384",
+	0x00A2: "TLS_DHE_DSS_WITH_AES_128_GCM_SHA256",
+	0x00A3: "TLS_DHE_DSS_WITH_AES_256_GCM_SHA384",
+	0x00A4: "TLS_DH_DSS_WITH_AES_128_GCM_SHA256",
+	0x00A5: "TLS_DH_DSS_WITH_AES_256_GCM_SHA384",
+	0x00A6: "TLS_DH_anon_WITH_AES_128_GCM_SHA256",
+	0x00A7: "TLS_DH_anon_WITH_AES_256_GCM_SHA384",
+	0x00A8: "TLS_PSK_WITH_AES_128_GCM_SHA256",
+	0x00A9: "TLS_PSK_WITH_AES_256_GCM_SHA384",
+	0x00AA: "TLS_DHE_PSK_WITH_AES_128_GCM_SHA256",
+	0x00AB: "TLS_DHE_PSK_WITH_AES_256_GCM_SHA384",
+	0x00AC: "TLS_RSA_PSK_WITH_AES_128_GCM_SHA256",
+	0x00AD: "TLS_RSA_PSK_WITH_AES_256_GCM_SHA384",
+	0x00AE: "TLS_PSK_WITH_AES_128_CBC_SHA256",
+	0x00AF: "TLS_PSK_WITH_AES_256_CBC_SHA384",
+	0x00B0: "TLS_PSK_WITH_NULL_SHA256",
+	0x00B1: "TLS_PSK_WITH_NULL_SHA384",
+	0x00BA: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA256",
+	0x00BE: "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA256",
+	0x00C0: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA256",
+	0x00C4: "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA256",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0x1304: "TLS_AES_128_CCM_SHA256",
+	0x1305: "TLS_AES_128_CCM_8_SHA256",
+	0xC001: "TLS_ECDH_ECDSA_WITH_NULL_SHA",
+	0xC002: "TLS_ECDH_ECDSA_WITH_RC4_128_SHA",
+	0xC003: "TLS_ECDH_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xC004: "TLS_ECDH_ECDSA_WITH_AES_128_CBC_SHA",
+	0xC005: "TLS_ECDH_ECDSA_WITH_AES_256_CBC_SHA",
+	0xC006: "TLS_ECDHE_ECDSA_WITH_NULL_SHA",
+	0xC007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xC008: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xC009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xC00A: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xC00B: "TLS_ECDH_RSA_WITH_NULL_SHA",
+	0xC00C: "TLS_ECDH_RSA_WITH_RC4_128_SHA",
+	0xC00D: "TLS_ECDH_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xC00E: "TLS_ECDH_RSA_WITH_AES_128_CBC_SHA",
+	0xC00F: "TLS_ECDH_RSA_WITH_AES_256_CBC_SHA",
+	0xC010: "TLS_ECDHE_RSA_WITH_NULL_SHA",
+	0xC011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xC012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xC013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xC014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xC015: "TLS_ECDH_anon_WITH_NULL_SHA",
+	0xC016: "TLS_ECDH_anon_WITH_RC4_128_SHA",
+	0xC017: "TLS_ECDH_anon_WITH_3DES_EDE_CBC_SHA",
+	0xC018: "TLS_ECDH_anon_WITH_AES_128_CBC_SHA",
+	0xC019: "TLS_ECDH_anon_WITH_AES_256_CBC_SHA",
+	0xC01A: "TLS_SRP_SHA_WITH_3DES_EDE_CBC_SHA",
+	0xC01D: "TLS_SRP_SHA_WITH_AES_128_CBC_SHA",
+	0xC020: "TLS_SRP_SHA_WITH_AES_256_CBC_SHA",
+	0xC023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xC024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xC025: "TLS_ECDH_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xC026: "TLS_ECDH_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xC027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xC028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+	0xC029: "TLS_ECDH_RSA_WITH_AES_128_CBC_SHA256",
+	0xC02A: "TLS_ECDH_RSA_WITH_AES_256_CBC_SHA384",
+	0xC02B: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xC02C: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xC02D: "TLS_ECDH_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xC02E: "TLS_ECDH_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xC02F: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xC030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xC031: "TLS_ECDH_RSA_WITH_AES_128_GCM_SHA256",
+	0xC032: "TLS_ECDH_RSA_WITH_AES_256_GCM_SHA384",
+	0xC035: "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA",
+	0xC036: "TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA",
+	0xC037: "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256",
+	0xC038: "TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA384",
+	0xC072: "TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_CBC_SHA256",
+	0xC073: "TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_CBC_SHA384",
+	0xC076: "TLS_ECDHE_RSA_WITH_CAMELLIA_128_CBC_SHA256",
+	0xC077: "TLS_ECDHE_RSA_WITH_CAMELLIA_256_CBC_SHA384",
+	0xC09C: "TLS_RSA_WITH_AES_128_CCM",
+	0xC09D: "TLS_RSA_WITH_AES_256_CCM",
+	0xC09E: "TLS_DHE_RSA_WITH_AES_128_CCM",
+	0xC09F: "TLS_DHE_RSA_WITH_AES_256_CCM",
+	0xC0A0: "TLS_RSA_WITH_AES_128_CCM_8",
+	0xC0A1: "TLS_RSA_WITH_AES_256_CCM_8",
+	0xC0A2: "TLS_DHE_RSA_WITH_AES_128_CCM_8",
+	0xC0A3: "TLS_DHE_RSA_WITH_AES_256_CCM_8",
+	0xC0AC: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM",
+	0xC0AD: "TLS_ECDHE_ECDSA_WITH_AES_256_CCM",
+	0xC0AE: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8",
+	0xC0AF: "TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8",
+	0xCCA8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCA9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAA: "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAB: "TLS_PSK_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAC: "TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAD: "TLS_DHE_PSK_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// SuiteName returns the IANA name of the cipher suite, e.g. "TLS_RSA_WITH_RC4_128_SHA"
+func SuiteName(id uint16) string {
+	if name, ok := suiteNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// SuiteByName returns the cipher suite of the IANA name, the name is case-insensitive
+func SuiteByName(name string) (uint16, bool) {
+	for id, n := range suiteNames {
+		if strings.EqualFold(n, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// tls13Suite reports whether the cipher suite is a TLS 1.3 suite, which names no key exchange
+func tls13Suite(id uint16) bool {
+	return id>>8 == 0x13
+}
+
+// Suites returns the known cipher suites of the TLS version in ascending order
+func Suites(version uint16) []uint16 {
+	var suites []uint16
+	for id := range suiteNames {
+		if tls13Suite(id) == (version == VersionTLS13) {
+			suites = append(suites, id)
+		}
+	}
+	slices.Sort(suites)
+	return suites
+}
+
+// Weaknesses returns the weaknesses of the cipher suite derived from its IANA name, empty for a strong suite:
+// NULL encryption, anonymous or non forward secret key exchange, export grade, RC4, RC2, DES, 3DES, IDEA,
+// MD5 and CBC mode with SHA-1
+func Weaknesses(id uint16) []string {
+	name, ok := suiteNames[id]
+	if !ok {
+		return []string{"unknown cipher suite"}
+	}
+	if tls13Suite(id) {
+		return nil
+	}
+
+	kex, cipher, _ := strings.Cut(strings.TrimPrefix(name, "TLS_"), "_WITH_")
+	var weaknesses []string
+	add := func(weak bool, weakness string) {
+		if weak {
+			weaknesses = append(weaknesses, weakness)
+		}
+	}
+	add(strings.HasPrefix(cipher, "NULL"), "NULL encryption")
+	add(strings.Contains(kex, "anon"), "anonymous key exchange")
+	add(strings.Contains(kex, "EXPORT"), "export grade")
+	add(strings.HasPrefix(cipher, "RC4"), "RC4")
+	add(strings.HasPrefix(cipher, "RC2"), "RC2")
+	add(strings.HasPrefix(cipher, "DES"), "DES")
+	add(strings.HasPrefix(cipher, "3DES"), "3DES")
+	add(strings.HasPrefix(cipher, "IDEA"), "IDEA")
+	add(strings.HasSuffix(cipher, "_MD5"), "MD5")
+	add(strings.Contains(cipher, "_CBC_") && strings.HasSuffix(cipher, "_SHA"), "CBC with SHA-1")
+	add(!strings.Contains(kex, "anon") && !strings.HasPrefix(kex, "DHE_") && !strings.HasPrefix(kex, "ECDHE_"), "no forward secrecy")
+	return weaknesses
+}
@@ -0,0 +1,70 @@
+package tls_probe
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWeaknesses(t *testing.T) {
+	tests := []struct {
+		suite string
+		want  []string
+	}{
+		{"TLS_AES_128_GCM_SHA256", nil},
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", nil},
+		{"TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256", nil},
+		{"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", nil},
+		{"TLS_RSA_WITH_RC4_128_SHA", []string{"RC4", "no forward secrecy"}},
+		{"TLS_RSA_WITH_3DES_EDE_CBC_SHA", []string{"3DES", "CBC with SHA-1", "no forward secrecy"}},
+		{"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA", []string{"3DES", "CBC with SHA-1"}},
+		{"TLS_RSA_WITH_DES_CBC_SHA", []string{"DES", "CBC with SHA-1", "no forward secrecy"}},
+		{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", []string{"CBC with SHA-1"}},
+		{"TLS_RSA_EXPORT_WITH_RC4_40_MD5", []string{"export grade", "RC4", "MD5", "no forward secrecy"}},
+		{"TLS_RSA_WITH_NULL_SHA256", []string{"NULL encryption", "no forward secrecy"}},
+		{"TLS_ECDHE_RSA_WITH_NULL_SHA", []string{"NULL encryption"}},
+		{"TLS_DH_anon_WITH_AES_128_GCM_SHA256", []string{"anonymous key exchange"}},
+		{"TLS_DH_anon_EXPORT_WITH_RC4_40_MD5", []string{"anonymous key exchange", "export grade", "RC4", "MD5"}},
+		{"TLS_RSA_WITH_AES_128_GCM_SHA256", []string{"no forward secrecy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.suite, func(t *testing.T) {
+			id, ok := SuiteByName(tt.suite)
+			if !ok {
+				t.Fatalf("unknown cipher suite %s", tt.suite)
+			}
+			if got := Weaknesses(id); !slices.Equal(got, tt.want) {
+				t.Errorf("Weaknesses(%s) = %q, want %q", tt.suite, got, tt.want)
+			}
+		})
+	}
+
+	if got := Weaknesses(0xFFFF); !slices.Equal(got, []string{"unknown cipher suite"}) {
+		t.Errorf("Weaknesses(0xFFFF) = %q, want unknown cipher suite", got)
+	}
+}
+
+func TestSuites(t *testing.T) {
+	for _, version := range Versions {
+		suites := Suites(version)
+		if len(suites) == 0 || !slices.IsSorted(suites) {
+			t.Errorf("Suites(%s) = %04x, want sorted suites", VersionName(version), suites)
+		}
+		for _, s := range suites {
+			if tls13Suite(s) != (version == VersionTLS13) {
+				t.Errorf("Suites(%s) has %s", VersionName(version), SuiteName(s))
+			}
+		}
+	}
+}
+
+func TestSuiteByName(t *testing.T) {
+	if id, ok := SuiteByName("tls_ecdhe_rsa_with_aes_128_gcm_sha256"); !ok || id != 0xC02F {
+		t.Errorf("SuiteByName() = 0x%04X, %t, want 0xC02F", id, ok)
+	}
+	if _, ok := SuiteByName("TLS_UNKNOWN"); ok {
+		t.Error("SuiteByName(TLS_UNKNOWN) found a suite")
+	}
+	if got := SuiteName(0xFFFF); got != "0xFFFF" {
+		t.Errorf("SuiteName(0xFFFF) = %s, want 0xFFFF", got)
+	}
+}
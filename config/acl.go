@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Actions of the ACL rules
+const (
+	ActionPublish   = "publish"
+	ActionSubscribe = "subscribe"
+	ActionBoth      = "both"
+)
+
+// Identity is a set of credentials the broker authenticates a client with
+type Identity struct {
+	Name     string `json:"name"`     // Name the ACL rules refer to the identity by
+	Username string `json:"username"` // Username of the identity
+	Password string `json:"password"` // Password of the identity
+	Admin    bool   `json:"admin"`    // Whether the identity may read the system topics of the broker
+}
+
+// ACL is the authorization matrix the ACL scanner verifies
+type ACL struct {
+	// Observer subscribes to the topics of the publish rules to confirm whether the messages are delivered,
+	// the broker username and password are used if it has no username
+	Observer   Identity   `json:"observer"`
+	Identities []Identity `json:"identities"` // Identities the rules refer to
+	Rules      []ACLRule  `json:"rules"`      // Expected authorization of the identities
+	Leakage    Leakage    `json:"leakage"`    // Cross-tenant leakage check between two of the identities
+	Retained   Retained   `json:"retained"`   // Retained message checks between two of the identities
+	Will       Will       `json:"will"`       // Will message check of one of the identities
+}
+
+// Leakage is a victim identity publishing canary messages on its private topics and an attacker identity
+// subscribing with wildcard topic filters, which must not receive them
+type Leakage struct {
+	Victim   string   `json:"victim"`   // Name of the victim identity
+	Attacker string   `json:"attacker"` // Name of the attacker identity
+	Topics   []string `json:"topics"`   // Private topic names of the victim
+	Filters  []string `json:"filters"`  // Topic filters the attacker subscribes to in addition to the built-in ones
+}
+
+// Enabled reports whether the leakage check is configured
+func (l Leakage) Enabled() bool {
+	return l.Victim != "" || l.Attacker != ""
+}
+
+// Retained is an owner identity publishing retained messages on its restricted topics and an intruder identity
+// which must not read, overwrite or delete them
+type Retained struct {
+	Owner    string   `json:"owner"`    // Name of the owner identity
+	Intruder string   `json:"intruder"` // Name of the intruder identity
+	Topics   []string `json:"topics"`   // Restricted topic names of the owner
+}
+
+// Enabled reports whether the retained message checks between the identities are configured
+func (r Retained) Enabled() bool {
+	return r.Owner != "" || r.Intruder != ""
+}
+
+// Will is an identity connecting with will messages on topics it is denied publishing to
+type Will struct {
+	Identity string   `json:"identity"` // Name of the identity
+	Topics   []string `json:"topics"`   // Topic names the identity is denied publishing to
+}
+
+// Enabled reports whether the will message check is configured
+func (w Will) Enabled() bool {
+	return w.Identity != ""
+}
+
+// ACLRule is the expected authorization of an identity for an action on a topic
+type ACLRule struct {
+	Identity string `json:"identity"` // Name of the identity
+	Topic    string `json:"topic"`    // Topic name, or topic filter for subscribe rules
+	Action   string `json:"action"`   // One of the Action* constants
+	Allow    bool   `json:"allow"`    // Whether the broker should allow the action
+}
+
+// Publishes reports whether the rule covers publishing
+func (r ACLRule) Publishes() bool {
+	return r.Action == ActionPublish || r.Action == ActionBoth
+}
+
+// Subscribes reports whether the rule covers subscribing
+func (r ACLRule) Subscribes() bool {
+	return r.Action == ActionSubscribe || r.Action == ActionBoth
+}
+
+// Identity returns the identity of the name
+func (a ACL) Identity(name string) (Identity, bool) {
+	for _, id := range a.Identities {
+		if id.Name == name {
+			return id, true
+		}
+	}
+	return Identity{}, false
+}
+
+// redacted returns a copy of the ACL with the passwords masked
+func (a ACL) redacted() ACL {
+	a.Observer.Password = mask(a.Observer.Password)
+	identities := make([]Identity, len(a.Identities))
+	for i, id := range a.Identities {
+		id.Password = mask(id.Password)
+		identities[i] = id
+	}
+	a.Identities = identities
+	return a
+}
+
+// validateACL checks that the rules refer to known identities with known actions and publish to topic names,
+// and validates the leakage, retained and will checks
+func validateACL(a ACL) error {
+	for i, r := range a.Rules {
+		if _, ok := a.Identity(r.Identity); !ok {
+			return fmt.Errorf("acl rule %d refers to the unknown identity %q", i, r.Identity)
+		}
+		switch r.Action {
+		case ActionPublish, ActionSubscribe, ActionBoth:
+		default:
+			return fmt.Errorf("acl rule %d has the unknown action %q, must be one of publish/subscribe/both", i, r.Action)
+		}
+		if r.Topic == "" {
+			return fmt.Errorf("acl rule %d has no topic", i)
+		}
+		if r.Publishes() && strings.ContainsAny(r.Topic, "+#") {
+			return fmt.Errorf("acl rule %d publishes to the topic filter %s, publish rules need a topic name", i, r.Topic)
+		}
+	}
+	if l := a.Leakage; l.Enabled() {
+		if err := validateCanaries(a, "leakage", l.Victim, l.Attacker, l.Topics); err != nil {
+			return err
+		}
+	}
+	if r := a.Retained; r.Enabled() {
+		if err := validateCanaries(a, "retained", r.Owner, r.Intruder, r.Topics); err != nil {
+			return err
+		}
+	}
+	if w := a.Will; w.Enabled() {
+		if _, ok := a.Identity(w.Identity); !ok {
+			return fmt.Errorf("acl will refers to the unknown identity %q", w.Identity)
+		}
+		if len(w.Topics) == 0 {
+			return fmt.Errorf("acl will has no topics")
+		}
+		for _, t := range w.Topics {
+			if t == "" || strings.ContainsAny(t, "+#") {
+				return fmt.Errorf("acl will topic %q must be a topic name", t)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCanaries checks that a check in which an identity publishes canary messages on its topics for another identity
+// refers to two different known identities, and that the topics are topic names
+func validateCanaries(a ACL, check, owner, other string, topics []string) error {
+	for _, name := range []string{owner, other} {
+		if _, ok := a.Identity(name); !ok {
+			return fmt.Errorf("acl %s refers to the unknown identity %q", check, name)
+		}
+	}
+	if owner == other {
+		return fmt.Errorf("acl %s needs two different identities", check)
+	}
+	if len(topics) == 0 {
+		return fmt.Errorf("acl %s has no topics", check)
+	}
+	for _, t := range topics {
+		if t == "" || strings.ContainsAny(t, "+#") {
+			return fmt.Errorf("acl %s topic %q must be a topic name", check, t)
+		}
+	}
+	return nil
+}
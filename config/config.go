@@ -1,32 +1,125 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"strings"
+	"time"
 )
 
+// Outcome is the result state of a scan item
+type Outcome string
+
+const (
+	OutcomePass    Outcome = "pass"    // The scan item has passed the scan
+	OutcomeFail    Outcome = "fail"    // The scan item has findings
+	OutcomeError   Outcome = "error"   // The scanner failed to execute
+	OutcomeSkipped Outcome = "skipped" // The scanner was not executed
+)
+
+// Severity is the importance of a finding
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// severityRanks orders the severities from the least to the most important
+var severityRanks = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// ParseSeverity parses the string representation of a severity
+func ParseSeverity(s string) (Severity, error) {
+	severity := Severity(strings.ToLower(s))
+	if _, ok := severityRanks[severity]; !ok {
+		return "", fmt.Errorf("unknown severity %s, must be one of info/low/medium/high/critical", s)
+	}
+	return severity, nil
+}
+
+// AtLeast reports whether the severity is at or above the threshold
+func (s Severity) AtLeast(threshold Severity) bool {
+	return severityRanks[s] >= severityRanks[threshold]
+}
+
 // ScanItem struct represents a single item to be scanned.
 type ScanItem struct {
-	Name    string   // The name of the scan item
-	Pass    bool     // Whether the scan item has passed the scan
-	Message []string // Message stores the reason why the scan failed
+	ID          string        // The ID of the scanner which produced the scan item, set by the scan engine
+	Name        string        // The name of the scan item
+	Listener    string        // The listener URL the scan item ran against, empty if it does not run per listener
+	Protocol    string        // The MQTT protocol version the scan item ran with, empty if it does not run per listener
+	Category    string        // The category of the scanner, set by the scan engine
+	Severity    Severity      // The severity of a finding of the scanner, set by the scan engine
+	Outcome     Outcome       // The result state of the scan item
+	Error       string        // Error stores why the scanner failed to execute or was skipped
+	Message     []string      // Message stores the reason why the scan failed
+	Evidence    []string      // Evidence stores the raw observations the result is based on, e.g. broker errors
+	Remediation string        // Remediation is the guidance on how to fix a finding, set by the scan engine
+	References  []string      // References to specifications and documentation, set by the scan engine
+	Duration    time.Duration // Duration of the scanner execution, set by the scan engine
 }
 
 // NewScanItem function initializes a new ScanItem with the given name
 func NewScanItem(name string) *ScanItem {
 	return &ScanItem{
-		Name:    name,
-		Pass:    false,
-		Message: make([]string, 0),
+		Name:     name,
+		Outcome:  OutcomeFail,
+		Message:  make([]string, 0),
+		Evidence: make([]string, 0),
+	}
+}
+
+// NewErrorItem function initializes a new ScanItem for a scanner which failed to execute
+func NewErrorItem(name string, err error) *ScanItem {
+	si := NewScanItem(name)
+	si.Outcome = OutcomeError
+	si.Error = err.Error()
+	return si
+}
+
+// NewSkippedItem function initializes a new ScanItem for a scanner which was not executed
+func NewSkippedItem(name, reason string) *ScanItem {
+	si := NewScanItem(name)
+	si.Outcome = OutcomeSkipped
+	si.Error = reason
+	return si
+}
+
+// SetPass sets the outcome to pass or fail
+func (si *ScanItem) SetPass(pass bool) {
+	if pass {
+		si.Outcome = OutcomePass
+		return
 	}
+	si.Outcome = OutcomeFail
 }
 
 type Config struct {
-	BrokerInfo BrokerInfo `json:"broker"` // Broker-specific configurations
-	Hosts      []string   `json:"hosts"`  // The list of hosts to be scanned
-	Limit      Limit      `json:"limit"`  // Limit includes the various limitations and restrictions for the scan
+	BrokerInfo BrokerInfo `json:"broker"`  // Broker-specific configurations
+	Hosts      []string   `json:"hosts"`   // The list of hosts to be scanned
+	Limit      Limit      `json:"limit"`   // Limit includes the various limitations and restrictions for the scan
+	Timeout    Timeout    `json:"timeout"` // Timeout includes the deadlines of the scan and of single scanners
+	ACL        ACL        `json:"acl"`     // ACL is the authorization matrix verified by the ACL scanner
+
+	hash string // SHA-256 of the configuration file
+}
+
+// Hash returns the hex encoded SHA-256 of the configuration file the config was read from
+func (c *Config) Hash() string {
+	return c.hash
 }
 
 type BrokerInfo struct {
@@ -36,9 +129,21 @@ type BrokerInfo struct {
 	MQTTSPort  int      `json:"mqtts_port"`  // Port for MQTT over SSL protocol
 	WSPort     int      `json:"ws_port"`     // Port for WebSocket protocol
 	WSSPort    int      `json:"wss_port"`    // Port for WebSocket over SSL protocol
+	WSPath     string   `json:"ws_path"`     // Path of the WebSocket listeners, "/mqtt" by default
+	Transports []string `json:"transports"`  // Transports(tcp/ssl/ws/wss) the MQTT level scanners run over, tcp only by default
+	Protocols  []string `json:"protocols"`   // MQTT protocol versions(3.1.1/5.0) the MQTT level scanners run with, 3.1.1 only by default
 	Username   string   `json:"username"`    // Username used for the broker
 	Password   string   `json:"password"`    // Password used for the broker
 	DenyTopics []string `json:"deny_topics"` // DenyTopics is a list of topics that are denied access
+	CAFile     string   `json:"ca_file"`     // PEM bundle of the CA certificates the TLS listener certificates are verified against, the system roots if empty
+	ClientCert string   `json:"client_cert"` // PEM file of the client certificate presented to the TLS listeners, for brokers requiring mutual TLS
+	ClientKey  string   `json:"client_key"`  // PEM file of the private key of the client certificate
+	// PEM files of the CA which issues the client certificates, optional. The mutual TLS checks issue an expired certificate
+	// and a certificate of another identity with it.
+	ClientCACert string `json:"client_ca_cert"`
+	ClientCAKey  string `json:"client_ca_key"`
+
+	clientCertificate *tls.Certificate // The loaded client certificate, nil if none is configured
 }
 
 type Limit struct {
@@ -52,15 +157,45 @@ type Limit struct {
 	PayloadLen             int      `json:"payload_len"`              // Length limit for MQTT payload
 	Connection             int      `json:"connection"`               // Limit for the number of connections
 	Flapping               int      `json:"flapping"`                 // Limit for the number of flapping connections
+	WSFrameSize            int      `json:"ws_frame_size"`            // Largest WebSocket frame in bytes the WS listeners should accept, 0 skips the check
+	AllowedCipherSuites    []string `json:"allowed_cipher_suites"`    // IANA names of the only cipher suites the TLS listeners may accept, any strong suite if empty
+	DeniedCipherSuites     []string `json:"denied_cipher_suites"`     // IANA names of the cipher suites the TLS listeners must not accept, in addition to the weak ones
+	CertExpiryDays         int      `json:"cert_expiry_days"`         // Days before expiry a TLS listener certificate is reported, 30 by default
+	SysWindow              int      `json:"sys_window"`               // Seconds the system topic check listens for messages, 10 by default
+	RetainedPayloadLen     int      `json:"retained_payload_len"`     // Largest retained payload in bytes the broker should store, 0 skips the check
+	RetainedCount          int      `json:"retained_count"`           // Most retained messages a client should be able to create, 0 skips the check
+	WillPayloadLen         int      `json:"will_payload_len"`         // Largest will payload in bytes the broker should publish, 0 skips the check
 }
 
-// InitConfig function initializes the configuration by reading from the configuration file
-func InitConfig(configPath string) *Config {
-	config, err := initConfigFile(configPath)
-	if err != nil {
-		log.Panicln(err)
+type Timeout struct {
+	Scan     int            `json:"scan"`     // Deadline in seconds for the whole scan, 0 means no deadline
+	Scanner  int            `json:"scanner"`  // Default timeout in seconds for a single scanner
+	Scanners map[string]int `json:"scanners"` // Timeout in seconds per scanner ID, overrides the default timeout
+}
+
+// secretMask replaces secrets in a redacted configuration
+const secretMask = "******"
+
+// Redacted returns a copy of the configuration with all secrets masked, it is safe to include in reports
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.BrokerInfo.Password = mask(c.BrokerInfo.Password)
+	r.ACL = c.ACL.redacted()
+	return &r
+}
+
+// mask masks a non-empty secret
+func mask(secret string) string {
+	if secret == "" {
+		return ""
 	}
-	return config
+	return secretMask
+}
+
+// InitConfig function initializes the configuration by reading from the configuration file,
+// it returns an error if the file cannot be read or the configuration is invalid
+func InitConfig(configPath string) (*Config, error) {
+	return initConfigFile(configPath)
 }
 
 func initConfigFile(configPath string) (*Config, error) {
@@ -76,5 +211,19 @@ func initConfigFile(configPath string) (*Config, error) {
 	if err = json.Unmarshal(configData, &cf); err != nil {
 		return nil, err
 	}
+	if err = validateTransports(cf.BrokerInfo.Transports); err != nil {
+		return nil, err
+	}
+	if err = validateProtocols(cf.BrokerInfo.Protocols); err != nil {
+		return nil, err
+	}
+	if err = cf.BrokerInfo.loadClientCertificate(); err != nil {
+		return nil, err
+	}
+	if err = validateACL(cf.ACL); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(configData)
+	cf.hash = hex.EncodeToString(sum[:])
 	return &cf, nil
 }
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Transports of the broker listeners
+const (
+	TransportTCP = "tcp" // MQTT over TCP
+	TransportSSL = "ssl" // MQTT over TLS
+	TransportWS  = "ws"  // MQTT over WebSocket
+	TransportWSS = "wss" // MQTT over WebSocket over TLS
+)
+
+// MQTT protocol versions of the broker connections
+const (
+	ProtocolMQTT311 = "3.1.1"
+	ProtocolMQTT5   = "5.0"
+)
+
+// defaultWSPath is the WebSocket path of the EMQX listeners
+const defaultWSPath = "/mqtt"
+
+// Listener is a broker listener the MQTT level scanners connect to
+type Listener struct {
+	Transport string // One of the Transport* constants
+	Host      string // Broker's host address
+	Port      int    // Port of the listener
+	Path      string // WebSocket path, empty for tcp and ssl
+	Protocol  string // MQTT protocol version, one of the ProtocolMQTT* constants
+
+	Certificate *tls.Certificate // Client certificate presented to a TLS listener, nil if none
+}
+
+// URL returns the connect address of the listener, e.g. "ws://broker.emqx.io:8083/mqtt"
+func (l Listener) URL() string {
+	return fmt.Sprintf("%s://%s%s", l.Transport, net.JoinHostPort(l.Host, strconv.Itoa(l.Port)), l.Path)
+}
+
+// String returns the URL of the listener qualified with the protocol version, e.g. "ws://broker.emqx.io:8083/mqtt MQTT 5.0",
+// or the URL only for a listener without protocol version
+func (l Listener) String() string {
+	if l.Protocol == "" {
+		return l.URL()
+	}
+	return fmt.Sprintf("%s MQTT %s", l.URL(), l.Protocol)
+}
+
+// TLS reports whether the listener uses TLS
+func (l Listener) TLS() bool {
+	return l.Transport == TransportSSL || l.Transport == TransportWSS
+}
+
+// TLSConfig returns the TLS configuration of the connections to the listener, presenting the client certificate if any.
+// The server certificate is not verified, it is audited by the TLS scanners.
+func (l Listener) TLSConfig() *tls.Config {
+	c := &tls.Config{InsecureSkipVerify: true, ServerName: l.Host}
+	if cert := l.Certificate; cert != nil {
+		// The certificate is presented even if the server does not list its CA as acceptable,
+		// crypto/tls sends no certificate then
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+	return c
+}
+
+// ClientCertificate returns the configured client certificate, nil if none is configured
+func (b BrokerInfo) ClientCertificate() *tls.Certificate {
+	return b.clientCertificate
+}
+
+// loadClientCertificate loads the configured client certificate and its key
+func (b *BrokerInfo) loadClientCertificate() error {
+	if b.ClientCert == "" && b.ClientKey == "" {
+		return nil
+	}
+	if b.ClientCert == "" || b.ClientKey == "" {
+		return errors.New("client_cert and client_key must be configured together")
+	}
+	cert, err := tls.LoadX509KeyPair(b.ClientCert, b.ClientKey)
+	if err != nil {
+		return fmt.Errorf("load the client certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("parse the client certificate: %w", err)
+		}
+	}
+	b.clientCertificate = &cert
+	return nil
+}
+
+// Listeners returns the enabled listeners in the order tcp, ssl, ws, wss, once per configured protocol version.
+// Only the tcp listener is enabled if no transports are configured, and MQTT 3.1.1 is used if no protocols are configured.
+// The ssl and wss listeners are only enabled if TLS is enabled.
+func (b BrokerInfo) Listeners() []Listener {
+	protocols := b.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{ProtocolMQTT311}
+	}
+
+	enabled := map[string]bool{TransportTCP: len(b.Transports) == 0}
+	for _, t := range b.Transports {
+		enabled[t] = true
+	}
+
+	wsPath := b.WSPath
+	if wsPath == "" {
+		wsPath = defaultWSPath
+	}
+
+	var listeners []Listener
+	for _, l := range []Listener{
+		{Transport: TransportTCP, Host: b.Host, Port: b.MQTTPort},
+		{Transport: TransportSSL, Host: b.Host, Port: b.MQTTSPort, Certificate: b.clientCertificate},
+		{Transport: TransportWS, Host: b.Host, Port: b.WSPort, Path: wsPath},
+		{Transport: TransportWSS, Host: b.Host, Port: b.WSSPort, Path: wsPath, Certificate: b.clientCertificate},
+	} {
+		if !enabled[l.Transport] || l.TLS() && !b.TLS {
+			continue
+		}
+		for _, p := range protocols {
+			l.Protocol = p
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners
+}
+
+// TLSListeners returns the ssl and wss listeners without protocol version, whatever the configured transports,
+// for the checks of the TLS layer. None is returned if TLS is disabled.
+func (b BrokerInfo) TLSListeners() []Listener {
+	if !b.TLS {
+		return nil
+	}
+	wsPath := b.WSPath
+	if wsPath == "" {
+		wsPath = defaultWSPath
+	}
+	return []Listener{
+		{Transport: TransportSSL, Host: b.Host, Port: b.MQTTSPort, Certificate: b.clientCertificate},
+		{Transport: TransportWSS, Host: b.Host, Port: b.WSSPort, Path: wsPath, Certificate: b.clientCertificate},
+	}
+}
+
+// WebSocketListeners returns the ws and wss listeners without protocol version, whatever the configured transports,
+// for the checks of the WebSocket layer. The wss listener is only returned if TLS is enabled.
+func (b BrokerInfo) WebSocketListeners() []Listener {
+	wsPath := b.WSPath
+	if wsPath == "" {
+		wsPath = defaultWSPath
+	}
+	listeners := []Listener{{Transport: TransportWS, Host: b.Host, Port: b.WSPort, Path: wsPath}}
+	if b.TLS {
+		listeners = append(listeners, Listener{Transport: TransportWSS, Host: b.Host, Port: b.WSSPort, Path: wsPath, Certificate: b.clientCertificate})
+	}
+	return listeners
+}
+
+// validateTransports checks that only known transports are configured
+func validateTransports(transports []string) error {
+	for _, t := range transports {
+		switch t {
+		case TransportTCP, TransportSSL, TransportWS, TransportWSS:
+		default:
+			return fmt.Errorf("unknown transport %s, must be one of tcp/ssl/ws/wss", t)
+		}
+	}
+	return nil
+}
+
+// validateProtocols checks that only known MQTT protocol versions are configured
+func validateProtocols(protocols []string) error {
+	for _, p := range protocols {
+		switch p {
+		case ProtocolMQTT311, ProtocolMQTT5:
+		default:
+			return fmt.Errorf("unknown protocol %s, must be one of 3.1.1/5.0", p)
+		}
+	}
+	return nil
+}
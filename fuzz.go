@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"mqtt-security-scanner/app/fuzzer"
+	"mqtt-security-scanner/config"
+)
+
+// fuzzCommand runs the fuzz command with its arguments and returns the exit code
+func fuzzCommand(args []string) int {
+	fs := flag.NewFlagSet("fuzz", flag.ContinueOnError)
+	configPath := fs.String("config", "config/config.json", "config address")
+	fTransport := fs.String("transport", "", "transport(tcp/ssl/ws/wss) of the listener to fuzz, the first configured transport by default")
+	fProtocol := fs.String("protocol", "", "MQTT protocol version(3.1.1/5.0) to fuzz with, the first configured protocol by default")
+	fIterations := fs.Int("iterations", 1000, "number of fuzzed packets to send, 0 means no limit")
+	fDuration := fs.Duration("duration", 0, "maximum duration of the fuzzing, e.g. 10m, 0 means no limit")
+	fSeed := fs.Int64("seed", 0, "seed of the mutations to repeat a run, a random seed by default")
+	fWait := fs.Duration("wait", fuzzer.DefaultWait, "how long the broker is given to answer or close the connection after a fuzzed packet")
+	fOut := fs.String("o", fuzzer.DefaultDir, "directory the reproducers of the anomalies are saved to")
+	fReplay := fs.String("replay", "", "path of a saved reproducer to send again instead of fuzzing")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+
+	var c *fuzzer.Case
+	if *fReplay != "" {
+		var err error
+		if c, err = fuzzer.LoadCase(*fReplay); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		// The reproducer is replayed over the listener it was found on unless another one is chosen
+		if *fTransport == "" {
+			*fTransport = c.Transport
+		}
+		if *fProtocol == "" {
+			*fProtocol = c.Protocol
+		}
+	}
+
+	cfg, err := config.InitConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	l, err := selectListener(cfg, *fTransport, *fProtocol)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	seed := *fSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	opts := fuzzer.Options{
+		Listener:   l,
+		Username:   cfg.BrokerInfo.Username,
+		Password:   cfg.BrokerInfo.Password,
+		Iterations: *fIterations,
+		Duration:   *fDuration,
+		Seed:       seed,
+		Wait:       *fWait,
+		Dir:        *fOut,
+		OnAnomaly: func(a *fuzzer.Anomaly) {
+			fmt.Fprintf(os.Stderr, "Anomaly [%s] at iteration %d, %s mutated by %s, reproducer saved to %s\n", a.Kind, a.Iteration, a.Packet, a.Mutator, a.Path)
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if c != nil {
+		return replayCase(ctx, opts, c)
+	}
+
+	fmt.Fprintf(os.Stderr, "Fuzzing %s with seed %d\n", l, seed)
+	start := time.Now()
+	res, err := fuzzer.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitErrors
+	}
+
+	counts := map[fuzzer.Kind]int{}
+	for _, a := range res.Anomalies {
+		counts[a.Kind]++
+	}
+	fmt.Printf("Fuzzed %s with seed %d: %d packets in %v, %d failed to send\n", l, seed, res.Iterations, time.Since(start).Round(time.Millisecond), res.Errors)
+	for _, kind := range []fuzzer.Kind{fuzzer.KindUnavailable, fuzzer.KindRefused, fuzzer.KindAccepted, fuzzer.KindHang} {
+		fmt.Printf("%s: %d\n", kind, counts[kind])
+	}
+	if res.Stopped {
+		if res.Anomalies[len(res.Anomalies)-1].Kind == fuzzer.KindRefused {
+			fmt.Println("Stopped early, the broker refuses the valid CONNECT")
+		} else {
+			fmt.Println("Stopped early, the broker is unavailable")
+		}
+	}
+
+	if len(res.Anomalies) > 0 {
+		return exitFindings
+	}
+	return 0
+}
+
+// replayCase sends a saved case once more and prints the broker behavior
+func replayCase(ctx context.Context, opts fuzzer.Options, c *fuzzer.Case) int {
+	fmt.Fprintf(os.Stderr, "Replaying %s mutated by %s over %s\n", c.Packet, c.Mutator, opts.Listener)
+	a, err := fuzzer.Replay(ctx, opts, c)
+	for _, event := range a.Observed {
+		fmt.Println(event)
+	}
+	if err != nil && a.Kind == "" {
+		fmt.Fprintln(os.Stderr, err)
+		return exitErrors
+	}
+	if a.Kind == "" {
+		fmt.Println("Not reproduced")
+		return 0
+	}
+	fmt.Printf("Reproduced: %s\n", a.Kind)
+	return exitFindings
+}
+
+// selectListener returns the first configured listener with the transport and protocol, empty values match any
+func selectListener(cfg *config.Config, transport, protocol string) (config.Listener, error) {
+	for _, l := range cfg.BrokerInfo.Listeners() {
+		if (transport == "" || l.Transport == transport) && (protocol == "" || l.Protocol == protocol) {
+			return l, nil
+		}
+	}
+	var want []string
+	if transport != "" {
+		want = append(want, "transport "+transport)
+	}
+	if protocol != "" {
+		want = append(want, "protocol "+protocol)
+	}
+	return config.Listener{}, fmt.Errorf("no listener with %s in the configured transports and protocols", strings.Join(want, " and "))
+}
@@ -1,125 +1,162 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"mqtt-security-scanner/app/mqtt_scanner"
-	"mqtt-security-scanner/app/port_scanner"
+	_ "mqtt-security-scanner/app/mqtt_scanner"
+	_ "mqtt-security-scanner/app/port_scanner"
+	"mqtt-security-scanner/app/report"
+	"mqtt-security-scanner/app/scanner"
 	"mqtt-security-scanner/config"
 )
 
-type ScannerFunc func(*config.Config) (*config.ScanItem, error)
+// version is set at build time by goreleaser
+var version = "dev"
+
+// Exit codes of the scan, they are combined when both findings and execution errors are present
+const (
+	exitFindings = 1 << iota // At least one scan item at or above the -fail-on severity did not pass
+	exitErrors               // At least one scanner failed to execute
+	exitUsage                // The command line is invalid
+)
 
 func main() {
-	fReport := flag.String("r", "stdout", "report output type(stdout/file)")
+	// The fuzz command has flags of its own
+	if len(os.Args) > 1 && os.Args[1] == "fuzz" {
+		os.Exit(fuzzCommand(os.Args[2:]))
+	}
+
+	fReport := flag.String("r", "stdout", "report output type(stdout/file/"+strings.Join(report.Formats(), "/")+")")
+	fOutput := flag.String("o", "", "report output path, defaults to the terminal, or result.txt for the file type")
 	configPath := flag.String("config", "config/config.json", "config address")
+	fList := flag.Bool("list", false, "list the available scanners with descriptions and exit")
+	fOnly := flag.String("only", "", "comma-separated scanner IDs, categories or glob patterns to run")
+	fSkip := flag.String("skip", "", "comma-separated scanner IDs, categories or glob patterns to exclude")
+	fFailOn := flag.String("fail-on", "info", "minimum severity(info/low/medium/high/critical) of a finding to set the exit code")
 	flag.Parse()
 
-	// Initialize configuration
-	cfg := config.InitConfig(*configPath)
-
-	// Define items of scanners
-	scanners := map[string]ScannerFunc{
-		// protocol related scanner
-		"Invalid MQTT Message":       mqtt_scanner.InvalidMQTTProtocolScanner,
-		"Invalid Websocket Protocol": mqtt_scanner.InvalidWSProtocolScanner,
-
-		// MQTT client related scanner
-		"Client Authentication":       mqtt_scanner.MQTTClientAuthentication,
-		"MQTT Client Username Length": mqtt_scanner.MQTTClientUsernameLength,
-		"MQTT Client password Length": mqtt_scanner.MQTTClientPasswordLength,
-		"MQTT Client ID Length":       mqtt_scanner.MQTTClientIDLength,
-		"MQTT Client Flapping":        mqtt_scanner.MQTTClientFlapping,
-
-		// MQTT message related scanner
-		"MQTT Topic Level":            mqtt_scanner.MQTTTopicLevel,
-		"MQTT Topic Length":           mqtt_scanner.MQTTTopicLength,
-		"MQTT Message Payload Length": mqtt_scanner.MQTTMessagePayloadLength,
-
-		// port scanner
-		"Host Port Scan": port_scanner.HostPortScan,
+	failOn, err := config.ParseSeverity(*fFailOn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
 
-	// Set tls scanner
-	if cfg.BrokerInfo.TLS {
-		scanners["TLS Version"] = mqtt_scanner.TLSVersionsScanner
+	if *fReport != "stdout" && *fReport != "file" && !report.Supported(*fReport) {
+		fmt.Fprintf(os.Stderr, "unsupported report output type %s\n", *fReport)
+		os.Exit(exitUsage)
 	}
 
-	// Create a buffered channel to store the results of each scan
-	scannerNum := len(scanners)
-	results := make(chan *config.ScanItem, scannerNum+1)
-
-	// Launch each scanner in separate goroutine
-	var wg sync.WaitGroup
-	wg.Add(scannerNum)
-	for name, scanner := range scanners {
-		go func(name string, scanner ScannerFunc) {
-			defer wg.Done()
-			results <- runScanner(cfg, name, scanner)
-		}(name, scanner)
+	// Select the registered scanners
+	selected, err := scanner.Select(scanner.All(), scanner.ParsePatterns(*fOnly), scanner.ParsePatterns(*fSkip))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
 
-	// Record the starting time and wait for all scans to complete
-	start := time.Now()
-	wg.Wait()
+	if *fList {
+		listScanners(selected)
+		return
+	}
 
-	// Delay execute MQTT client connection scanner, because it will affect other scanners
-	results <- runScanner(cfg, "MQTT Client Connection", mqtt_scanner.MQTTClientConnection)
+	// Initialize configuration
+	cfg, err := config.InitConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
 
-	close(results)
+	// Cancel in-flight scanners on Ctrl-C, the results collected so far are still reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Timeout.Scan > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout.Scan)*time.Second)
+		defer cancel()
+	}
 
-	fmt.Println(time.Since(start))
+	// Run the scanners phase by phase, the results are kept in the order of the selected scanners
+	engine := &scanner.Engine{
+		Config: cfg,
+		OnStart: func(s scanner.Scanner) {
+			fmt.Fprintf(os.Stderr, "Start running scanner item [%s]\n", scanner.DisplayName(s))
+		},
+		OnFinish: printProgress,
+	}
+	start := time.Now()
+	results := engine.Run(ctx, selected)
+	stop()
+	end := time.Now()
+
+	fmt.Fprintln(os.Stderr, end.Sub(start))
 	// Output results based on the chosen mode
-	output(results, *fReport)
+	r := report.New(cfg, version, start, end, results)
+	if err := output(r, *fReport, *fOutput); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitErrors)
+	}
+	os.Exit(exitCode(results, failOn))
 }
 
-func runScanner(cfg *config.Config, name string, scanner ScannerFunc) *config.ScanItem {
-	fmt.Printf("Start running scanner item [%s]\n", name)
-	si, err := scanner(cfg)
-	if err != nil {
-		errMsg := fmt.Sprintf("Failed to execute scanner item [%s], %v", name, err)
-		panic(errMsg)
+// listScanners prints the given scanners with their descriptions
+func listScanners(scanners []scanner.Scanner) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCATEGORY\tSEVERITY\tNAME\tDESCRIPTION")
+	for _, s := range scanners {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID(), s.Category(), s.Severity(), s.Name(), s.Description())
 	}
-	fmt.Printf("Finish running scanner item [%s]\n", name)
-	return si
+	w.Flush()
 }
 
-// 'output' function takes a channel of scan results and a mode string, it supports 'stdout' and 'file' mode
-// 'stdout' mode outputs the results directly to the terminal
-// 'file' mode writes results to a 'result.txt' file
-func output(results chan *config.ScanItem, mode string) {
-	var buf bytes.Buffer
-	for si := range results {
-		if !si.Pass {
-			buf.WriteString(fmt.Sprintf("[%s]\t do not pass: %s\n", si.Name, strings.Join(si.Message, ", ")))
-			continue
-		}
-		buf.WriteString(fmt.Sprintf("[%s]\t pass\n", si.Name))
+// printProgress prints the completion of a scanner, progress is printed to stderr to keep stdout for the report
+func printProgress(s scanner.Scanner, si *config.ScanItem) {
+	switch si.Outcome {
+	case config.OutcomeError:
+		fmt.Fprintf(os.Stderr, "Failed to execute scanner item [%s], %s\n", scanner.DisplayName(s), si.Error)
+	case config.OutcomeSkipped:
+		fmt.Fprintf(os.Stderr, "Skip scanner item [%s], %s\n", scanner.DisplayName(s), si.Error)
+	default:
+		fmt.Fprintf(os.Stderr, "Finish running scanner item [%s]\n", scanner.DisplayName(s))
 	}
+}
 
+// output writes the report according to the report type and output path
+// 'stdout' type writes the text report directly to the terminal
+// 'file' type writes the text report to the output path, 'result.txt' by default
+// The other types are report formats written to the output path, or to the terminal if no path is given
+func output(r *report.Report, mode, path string) error {
 	switch mode {
 	case "stdout":
-		fmt.Print(buf.String())
+		return report.Write(r, "text", "")
 	case "file":
-		file, err := os.Create("result.txt")
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed to create scan result file, %v", err)
-			panic(errMsg)
+		if path == "" {
+			path = "result.txt"
 		}
-		defer file.Close()
+		return report.Write(r, "text", path)
+	default:
+		return report.Write(r, mode, path)
+	}
+}
 
-		_, err = buf.WriteTo(file)
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed to write scan result, %v", err)
-			panic(errMsg)
+// exitCode returns the exit code of the scan results, only findings at or above the threshold severity count
+func exitCode(results []*config.ScanItem, threshold config.Severity) int {
+	code := 0
+	for _, si := range results {
+		switch si.Outcome {
+		case config.OutcomeFail:
+			if si.Severity.AtLeast(threshold) {
+				code |= exitFindings
+			}
+		case config.OutcomeError:
+			code |= exitErrors
 		}
-	default:
-		panic("Unsupported output format")
 	}
+	return code
 }